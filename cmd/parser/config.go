@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/eth"
+)
+
+// config holds the parser settings. They are read from an optional JSON config file,
+// then overridden by environment variables, then by command line flags.
+type config struct {
+	// ListenAddr is the address the HTTP API listens on
+	ListenAddr string `json:"listenAddr"`
+	// DBPath is the SQLite database file, in-memory storage is used if empty
+	DBPath string `json:"db"`
+	// RPCHTTPURL is the JSON-RPC endpoint requests are posted to
+	RPCHTTPURL string `json:"rpcHTTPURL"`
+	// RPCWSURL is the JSON-RPC websocket endpoint subscriptions are made on
+	RPCWSURL string `json:"rpcWSURL"`
+	// RPCHeaders are extra headers, such as authorization, sent to the JSON-RPC endpoints
+	RPCHeaders map[string]string `json:"rpcHeaders"`
+	// RPCFallbacks are the JSON-RPC endpoints calls fail over to, the healthiest endpoint is used at any time
+	RPCFallbacks []rpcEndpoint `json:"rpcFallbacks"`
+	// RPCMaxBatchSize is the maximum number of requests sent in a single JSON-RPC batch, the default one is used if 0
+	RPCMaxBatchSize int `json:"rpcMaxBatchSize"`
+	// TrackTransfers enables the block-driven tracking of the transactions sent from or to subscribed addresses
+	TrackTransfers bool `json:"trackTransfers"`
+	// StreamAllowedOrigins are the origins of the pages allowed to open websocket streams, "*" allowing any.
+	// Only the pages served from the same host as the API are allowed if empty.
+	StreamAllowedOrigins []string `json:"streamAllowedOrigins"`
+}
+
+// rpcEndpoint is a fallback JSON-RPC endpoint
+type rpcEndpoint struct {
+	HTTPURL string            `json:"httpURL"`
+	WSURL   string            `json:"wsURL"`
+	Headers map[string]string `json:"headers"`
+}
+
+// envPrefix prefixes the environment variables holding config values
+const envPrefix = "PARSER_"
+
+// defaultConfig returns the config used for the values set nowhere else
+func defaultConfig() config {
+	endpoint := eth.DefaultEndpoint()
+
+	return config{
+		ListenAddr: ":8080",
+		RPCHTTPURL: endpoint.HTTPURL,
+		RPCWSURL:   endpoint.WSURL,
+	}
+}
+
+// loadConfig builds the config from the config file, the environment and the command line arguments
+func loadConfig(args []string) (config, error) {
+	cfg := defaultConfig()
+
+	flags := flag.NewFlagSet("parser", flag.ContinueOnError)
+	configPath := flags.String("config", os.Getenv(envPrefix+"CONFIG"), "path to a JSON config file (env PARSER_CONFIG)")
+	listenAddr := flags.String("listen", "", "address the HTTP API listens on (env PARSER_LISTEN_ADDR)")
+	dbPath := flags.String("db", "", "path to the SQLite database file, in-memory storage is used if empty (env PARSER_DB)")
+	rpcHTTPURL := flags.String("rpc-http-url", "", "JSON-RPC HTTP endpoint (env PARSER_RPC_HTTP_URL)")
+	rpcWSURL := flags.String("rpc-ws-url", "", "JSON-RPC websocket endpoint (env PARSER_RPC_WS_URL)")
+	var rpcFallbacks fallbackFlag
+	flags.Var(&rpcFallbacks, "rpc-fallback", `fallback "HTTP_URL,WS_URL" JSON-RPC endpoints, can be repeated (env PARSER_RPC_FALLBACKS, separated by ";")`)
+	rpcMaxBatchSize := flags.Int("rpc-max-batch-size", 0, "maximum number of requests sent in a single JSON-RPC batch, the default one is used if 0 (env PARSER_RPC_MAX_BATCH_SIZE)")
+	trackTransfers := flags.Bool("track-transfers", false, "record the transactions sent from or to subscribed addresses by following new blocks (env PARSER_TRACK_TRANSFERS)")
+	var rpcHeaders headerFlag
+	flags.Var(&rpcHeaders, "rpc-header", `extra "Key: Value" header sent to the JSON-RPC endpoints, can be repeated (env PARSER_RPC_HEADERS, separated by ";")`)
+	streamAllowedOrigins := flags.String("stream-allowed-origins", "", `comma separated origins of the pages allowed to open websocket streams, "*" allowing any, only the same host if empty (env PARSER_STREAM_ALLOWED_ORIGINS)`)
+
+	if err := flags.Parse(args); err != nil {
+		return config{}, err
+	}
+
+	if *configPath != "" {
+		if err := cfg.loadFile(*configPath); err != nil {
+			return config{}, fmt.Errorf("failed to load config file %q: %w", *configPath, err)
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return config{}, fmt.Errorf("failed to load config from environment: %w", err)
+	}
+
+	// Only flags that were explicitly set override the values loaded so far
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			cfg.ListenAddr = *listenAddr
+		case "db":
+			cfg.DBPath = *dbPath
+		case "rpc-http-url":
+			cfg.RPCHTTPURL = *rpcHTTPURL
+		case "rpc-ws-url":
+			cfg.RPCWSURL = *rpcWSURL
+		case "rpc-header":
+			cfg.setHeaders(rpcHeaders)
+		case "rpc-fallback":
+			cfg.RPCFallbacks = rpcFallbacks
+		case "rpc-max-batch-size":
+			cfg.RPCMaxBatchSize = *rpcMaxBatchSize
+		case "track-transfers":
+			cfg.TrackTransfers = *trackTransfers
+		case "stream-allowed-origins":
+			cfg.StreamAllowedOrigins = splitList(*streamAllowedOrigins)
+		}
+	})
+
+	for i, endpoint := range cfg.Endpoints() {
+		if err := endpoint.Validate(); err != nil {
+			if i == 0 {
+				return config{}, fmt.Errorf("invalid RPC endpoint: %w", err)
+			}
+			return config{}, fmt.Errorf("invalid RPC fallback %d: %w", i, err)
+		}
+	}
+
+	return cfg, nil
+}
+
+// Endpoints returns the JSON-RPC endpoints described by the config, the primary one first
+func (c config) Endpoints() []eth.Endpoint {
+	endpoints := []eth.Endpoint{c.endpoint(c.RPCHTTPURL, c.RPCWSURL, c.RPCHeaders)}
+	for _, fallback := range c.RPCFallbacks {
+		endpoints = append(endpoints, c.endpoint(fallback.HTTPURL, fallback.WSURL, fallback.Headers))
+	}
+
+	return endpoints
+}
+
+// endpoint returns a JSON-RPC endpoint with the settings shared by all of them
+func (c config) endpoint(httpURL, wsURL string, headers map[string]string) eth.Endpoint {
+	header := make(http.Header)
+	for key, value := range headers {
+		header.Set(key, value)
+	}
+
+	return eth.Endpoint{
+		HTTPURL:      httpURL,
+		WSURL:        wsURL,
+		Header:       header,
+		MaxBatchSize: c.RPCMaxBatchSize,
+	}
+}
+
+// loadFile overrides the config with the values set in a JSON config file
+func (c *config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(c)
+}
+
+// loadEnv overrides the config with the values set in the environment
+func (c *config) loadEnv() error {
+	for name, field := range map[string]*string{
+		"LISTEN_ADDR":  &c.ListenAddr,
+		"DB":           &c.DBPath,
+		"RPC_HTTP_URL": &c.RPCHTTPURL,
+		"RPC_WS_URL":   &c.RPCWSURL,
+	} {
+		if value, ok := os.LookupEnv(envPrefix + name); ok {
+			*field = value
+		}
+	}
+
+	if value, ok := os.LookupEnv(envPrefix + "TRACK_TRANSFERS"); ok {
+		trackTransfers, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid %sTRACK_TRANSFERS %q: %w", envPrefix, value, err)
+		}
+		c.TrackTransfers = trackTransfers
+	}
+
+	if value, ok := os.LookupEnv(envPrefix + "RPC_MAX_BATCH_SIZE"); ok {
+		maxBatchSize, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid %sRPC_MAX_BATCH_SIZE %q: %w", envPrefix, value, err)
+		}
+		c.RPCMaxBatchSize = maxBatchSize
+	}
+
+	if value, ok := os.LookupEnv(envPrefix + "RPC_FALLBACKS"); ok {
+		var fallbacks fallbackFlag
+		for _, fallback := range strings.Split(value, ";") {
+			if strings.TrimSpace(fallback) == "" {
+				continue
+			}
+
+			if err := fallbacks.Set(fallback); err != nil {
+				return err
+			}
+		}
+		c.RPCFallbacks = fallbacks
+	}
+
+	if value, ok := os.LookupEnv(envPrefix + "STREAM_ALLOWED_ORIGINS"); ok {
+		c.StreamAllowedOrigins = splitList(value)
+	}
+
+	if value, ok := os.LookupEnv(envPrefix + "RPC_HEADERS"); ok {
+		var headers headerFlag
+		for _, header := range strings.Split(value, ";") {
+			if strings.TrimSpace(header) == "" {
+				continue
+			}
+
+			if err := headers.Set(header); err != nil {
+				return err
+			}
+		}
+		c.setHeaders(headers)
+	}
+
+	return nil
+}
+
+// splitList returns the non-empty values of a comma separated list
+func splitList(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
+
+// setHeaders replaces the RPC headers
+func (c *config) setHeaders(headers headerFlag) {
+	c.RPCHeaders = make(map[string]string, len(headers))
+	for _, header := range headers {
+		c.RPCHeaders[header[0]] = header[1]
+	}
+}
+
+// headerFlag collects repeated "Key: Value" header flags
+type headerFlag [][2]string
+
+// String returns the headers in their flag format
+func (h *headerFlag) String() string {
+	headers := make([]string, 0, len(*h))
+	for _, header := range *h {
+		headers = append(headers, header[0]+": "+header[1])
+	}
+
+	return strings.Join(headers, "; ")
+}
+
+// Set parses a "Key: Value" header
+func (h *headerFlag) Set(value string) error {
+	key, val, ok := strings.Cut(value, ":")
+	if !ok || strings.TrimSpace(key) == "" {
+		return fmt.Errorf("invalid header %q, expected \"Key: Value\"", value)
+	}
+
+	*h = append(*h, [2]string{strings.TrimSpace(key), strings.TrimSpace(val)})
+	return nil
+}
+
+// fallbackFlag collects repeated "HTTP_URL,WS_URL" fallback endpoint flags
+type fallbackFlag []rpcEndpoint
+
+// String returns the fallback endpoints in their flag format
+func (f *fallbackFlag) String() string {
+	fallbacks := make([]string, 0, len(*f))
+	for _, fallback := range *f {
+		fallbacks = append(fallbacks, fallback.HTTPURL+","+fallback.WSURL)
+	}
+
+	return strings.Join(fallbacks, "; ")
+}
+
+// Set parses a "HTTP_URL,WS_URL" fallback endpoint
+func (f *fallbackFlag) Set(value string) error {
+	httpURL, wsURL, ok := strings.Cut(value, ",")
+	if !ok || strings.TrimSpace(httpURL) == "" || strings.TrimSpace(wsURL) == "" {
+		return fmt.Errorf("invalid fallback endpoint %q, expected \"HTTP_URL,WS_URL\"", value)
+	}
+
+	*f = append(*f, rpcEndpoint{HTTPURL: strings.TrimSpace(httpURL), WSURL: strings.TrimSpace(wsURL)})
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		args    []string
+		want    func(cfg *config)
+		wantErr bool
+	}{
+		{
+			name: "Defaults",
+			want: func(cfg *config) {},
+		},
+		{
+			name: "File",
+			file: `{"listenAddr": ":1", "db": "file.db", "rpcHeaders": {"Authorization": "Bearer file"}, "rpcMaxBatchSize": 10}`,
+			want: func(cfg *config) {
+				cfg.ListenAddr = ":1"
+				cfg.DBPath = "file.db"
+				cfg.RPCHeaders = map[string]string{"Authorization": "Bearer file"}
+				cfg.RPCMaxBatchSize = 10
+			},
+		},
+		{
+			name: "EnvOverridesFile",
+			file: `{"listenAddr": ":1", "db": "file.db"}`,
+			env:  map[string]string{"PARSER_LISTEN_ADDR": ":2", "PARSER_TRACK_TRANSFERS": "true", "PARSER_RPC_MAX_BATCH_SIZE": "20"},
+			want: func(cfg *config) {
+				cfg.ListenAddr = ":2"
+				cfg.DBPath = "file.db"
+				cfg.TrackTransfers = true
+				cfg.RPCMaxBatchSize = 20
+			},
+		},
+		{
+			name: "FlagsOverrideEnvAndFile",
+			file: `{"listenAddr": ":1", "trackTransfers": true}`,
+			env:  map[string]string{"PARSER_LISTEN_ADDR": ":2", "PARSER_RPC_MAX_BATCH_SIZE": "20"},
+			args: []string{"-listen", ":3", "-track-transfers=false", "-rpc-max-batch-size", "30"},
+			want: func(cfg *config) {
+				cfg.ListenAddr = ":3"
+				cfg.RPCMaxBatchSize = 30
+			},
+		},
+		{
+			name: "Headers",
+			env:  map[string]string{"PARSER_RPC_HEADERS": "Authorization: Bearer env; X-Extra: 1;"},
+			want: func(cfg *config) {
+				cfg.RPCHeaders = map[string]string{"Authorization": "Bearer env", "X-Extra": "1"}
+			},
+		},
+		{
+			name: "HeaderFlagsReplaceEnv",
+			env:  map[string]string{"PARSER_RPC_HEADERS": "Authorization: Bearer env"},
+			args: []string{"-rpc-header", "Authorization: Bearer flag", "-rpc-header", "X-Api-Key:key"},
+			want: func(cfg *config) {
+				cfg.RPCHeaders = map[string]string{"Authorization": "Bearer flag", "X-Api-Key": "key"}
+			},
+		},
+		{
+			name: "StreamAllowedOrigins",
+			file: `{"streamAllowedOrigins": ["https://file.example.com"]}`,
+			env:  map[string]string{"PARSER_STREAM_ALLOWED_ORIGINS": "https://a.example.com, https://b.example.com,"},
+			want: func(cfg *config) {
+				cfg.StreamAllowedOrigins = []string{"https://a.example.com", "https://b.example.com"}
+			},
+		},
+		{
+			name: "StreamAllowedOriginsFlag",
+			env:  map[string]string{"PARSER_STREAM_ALLOWED_ORIGINS": "https://a.example.com"},
+			args: []string{"-stream-allowed-origins", "*"},
+			want: func(cfg *config) {
+				cfg.StreamAllowedOrigins = []string{"*"}
+			},
+		},
+		{
+			name: "Fallbacks",
+			file: `{"rpcFallbacks": [{"httpURL": "https://file.example.com", "wsURL": "wss://file.example.com", "headers": {"X-Api-Key": "key"}}]}`,
+			want: func(cfg *config) {
+				cfg.RPCFallbacks = []rpcEndpoint{{HTTPURL: "https://file.example.com", WSURL: "wss://file.example.com", Headers: map[string]string{"X-Api-Key": "key"}}}
+			},
+		},
+		{
+			name: "FallbackEnv",
+			env:  map[string]string{"PARSER_RPC_FALLBACKS": "https://a.example.com,wss://a.example.com; https://b.example.com , wss://b.example.com/ws"},
+			want: func(cfg *config) {
+				cfg.RPCFallbacks = []rpcEndpoint{
+					{HTTPURL: "https://a.example.com", WSURL: "wss://a.example.com"},
+					{HTTPURL: "https://b.example.com", WSURL: "wss://b.example.com/ws"},
+				}
+			},
+		},
+		{
+			name: "FallbackFlagsReplaceEnv",
+			env:  map[string]string{"PARSER_RPC_FALLBACKS": "https://a.example.com,wss://a.example.com"},
+			args: []string{"-rpc-fallback", "https://c.example.com,wss://c.example.com"},
+			want: func(cfg *config) {
+				cfg.RPCFallbacks = []rpcEndpoint{{HTTPURL: "https://c.example.com", WSURL: "wss://c.example.com"}}
+			},
+		},
+		{name: "InvalidHeader", args: []string{"-rpc-header", "no colon"}, wantErr: true},
+		{name: "InvalidHeaderEnv", env: map[string]string{"PARSER_RPC_HEADERS": ": value"}, wantErr: true},
+		{name: "InvalidFallback", args: []string{"-rpc-fallback", "https://a.example.com"}, wantErr: true},
+		{name: "InvalidFallbackURL", env: map[string]string{"PARSER_RPC_FALLBACKS": "https://a.example.com,https://a.example.com"}, wantErr: true},
+		{name: "InvalidTrackTransfers", env: map[string]string{"PARSER_TRACK_TRANSFERS": "maybe"}, wantErr: true},
+		{name: "InvalidMaxBatchSize", env: map[string]string{"PARSER_RPC_MAX_BATCH_SIZE": "-1"}, wantErr: true},
+		{name: "InvalidEndpoint", args: []string{"-rpc-http-url", "ws://localhost:8546"}, wantErr: true},
+		{name: "UnknownFileField", file: `{"listen": ":1"}`, wantErr: true},
+		{name: "MissingFile", args: []string{"-config", "missing.json"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				path := filepath.Join(t.TempDir(), "config.json")
+				if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				args = append([]string{"-config", path}, args...)
+			}
+
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			cfg, err := loadConfig(args)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			want := defaultConfig()
+			tt.want(&want)
+			assert.NoError(t, err)
+			assert.Equal(t, want, cfg)
+		})
+	}
+}
+
+func TestConfig_Endpoints(t *testing.T) {
+	cfg := config{
+		RPCHTTPURL:      "https://primary.example.com",
+		RPCWSURL:        "wss://primary.example.com",
+		RPCHeaders:      map[string]string{"authorization": "Bearer primary"},
+		RPCFallbacks:    []rpcEndpoint{{HTTPURL: "https://fallback.example.com", WSURL: "wss://fallback.example.com"}},
+		RPCMaxBatchSize: 10,
+	}
+
+	endpoints := cfg.Endpoints()
+	if assert.Len(t, endpoints, 2) {
+		assert.Equal(t, "https://primary.example.com", endpoints[0].HTTPURL)
+		assert.Equal(t, "Bearer primary", endpoints[0].Header.Get("Authorization"))
+		assert.Equal(t, "wss://fallback.example.com", endpoints[1].WSURL)
+		assert.Empty(t, endpoints[1].Header)
+		// The batch size applies to every endpoint
+		assert.Equal(t, 10, endpoints[1].MaxBatchSize)
+	}
+}
@@ -1,26 +1,76 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"net/http"
+	"os"
 
 	"github.com/HomayoonAlimohammadi/blockchain-parser/internal/api"
 	parserpkg "github.com/HomayoonAlimohammadi/blockchain-parser/internal/parser"
 	storagepkg "github.com/HomayoonAlimohammadi/blockchain-parser/internal/storage"
+	"github.com/HomayoonAlimohammadi/blockchain-parser/internal/webhook"
 	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/eth"
 	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/log"
 	"github.com/gorilla/websocket"
 )
 
 func main() {
-	rpcCaller := eth.NewRPCCaller(http.DefaultClient, websocket.DefaultDialer)
-	storage := storagepkg.NewInMemory()
+	cfg, err := loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	} else if err != nil {
+		log.Error(err, "failed to load config")
+		os.Exit(1)
+	}
+
+	var storage storagepkg.Storage = storagepkg.NewInMemory()
+	if cfg.DBPath != "" {
+		sqliteStorage, err := storagepkg.NewSQLite(cfg.DBPath)
+		if err != nil {
+			log.Error(err, "failed to open sqlite storage", "path", cfg.DBPath)
+			os.Exit(1)
+		}
+		defer sqliteStorage.Close()
+
+		storage = sqliteStorage
+	}
+
+	rpcCaller := eth.NewRPCCaller(http.DefaultClient, websocket.DefaultDialer, cfg.Endpoints()...)
+	go rpcCaller.MonitorProviders(context.Background())
 	parser := parserpkg.NewEthereumParser(rpcCaller, storage)
 
+	dispatcher := webhook.NewDispatcher(http.DefaultClient, storage)
+	parser.SetNotifier(dispatcher)
+	go dispatcher.Run(context.Background())
+
+	if err := parser.Resume(context.Background()); err != nil {
+		log.Error(err, "failed to resume some subscriptions")
+	}
+
+	if cfg.TrackTransfers {
+		if err := parser.TrackTransfers(context.Background()); err != nil {
+			log.Error(err, "failed to track transfers")
+			os.Exit(1)
+		}
+	}
+
 	api := api.NewAPI(parser)
+	api.SetAllowedOrigins(cfg.StreamAllowedOrigins)
 	http.HandleFunc("/subscribe", api.SubscribeHandler)
+	http.HandleFunc("/subscriptions", api.GetSubscriptionsHandler)
+	http.HandleFunc("/subscriptions/{address}", api.UnsubscribeHandler)
+	http.HandleFunc("/subscriptions/{address}/abi", api.ABIHandler)
 	http.HandleFunc("/transactions", api.GetTransactionsHandler)
+	http.HandleFunc("/logs", api.GetLogsHandler)
+	http.HandleFunc("/nfts", api.GetNFTHoldingsHandler)
+	http.HandleFunc("/balance", api.GetBalanceHandler)
+	http.HandleFunc("/stream", api.StreamHandler)
+	http.HandleFunc("/webhooks/deadletters", api.GetDeadLettersHandler)
 	http.HandleFunc("/blocknumber", api.GetBlockNumberHandler)
+	http.HandleFunc("/providers", api.GetProvidersHandler)
 
-	log.Info("starting to listen on :8080")
-	log.Error(http.ListenAndServe(":8080", nil), "failed to listen and serve")
+	log.Info("starting to listen", "address", cfg.ListenAddr)
+	log.Error(http.ListenAndServe(cfg.ListenAddr, nil), "failed to listen and serve")
 }
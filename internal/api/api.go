@@ -2,14 +2,22 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
 
 	parserpkg "github.com/HomayoonAlimohammadi/blockchain-parser/internal/parser"
+	addresspkg "github.com/HomayoonAlimohammadi/blockchain-parser/pkg/eth/address"
 )
 
 type api struct {
-	parser parserpkg.Parser
+	parser   parserpkg.Parser
+	upgrader websocket.Upgrader
 }
 
 // NewAPI creates a new API instance
@@ -19,7 +27,7 @@ func NewAPI(parser parserpkg.Parser) *api {
 	}
 }
 
-// SubscribeHandler handles address subscription
+// SubscribeHandler handles address subscription, optionally with a callback the new records are delivered to
 func (a *api) SubscribeHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		JSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method), nil)
@@ -28,6 +36,7 @@ func (a *api) SubscribeHandler(w http.ResponseWriter, r *http.Request) {
 
 	var req struct {
 		Address string `json:"address"`
+		parserpkg.SubscribeOptions
 	}
 
 	defer r.Body.Close()
@@ -36,7 +45,21 @@ func (a *api) SubscribeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := a.parser.Subscribe(r.Context(), req.Address); err != nil {
+	address, err := parseAddress(req.Address)
+	if err != nil {
+		JSONError(w, http.StatusBadRequest, err, nil)
+		return
+	}
+
+	if err := req.SubscribeOptions.Validate(); err != nil {
+		JSONError(w, http.StatusBadRequest, err, nil)
+		return
+	}
+
+	if err := a.parser.Subscribe(r.Context(), address, req.SubscribeOptions); errors.Is(err, parserpkg.ErrFutureBlock) {
+		JSONError(w, http.StatusBadRequest, err, nil)
+		return
+	} else if err != nil {
 		JSONError(w, http.StatusInternalServerError, fmt.Errorf("failed to subscribe to address: %w", err), nil)
 		return
 	}
@@ -44,15 +67,78 @@ func (a *api) SubscribeHandler(w http.ResponseWriter, r *http.Request) {
 	JSONResponse(w, http.StatusCreated, "Address subscribed", nil)
 }
 
-// GetTransactionsHandler returns transactions for a given address
+// UnsubscribeHandler handles address unsubscription, the address transactions are purged too if requested
+func (a *api) UnsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		JSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method), nil)
+		return
+	}
+
+	address, err := parseAddress(r.PathValue("address"))
+	if err != nil {
+		JSONError(w, http.StatusBadRequest, err, nil)
+		return
+	}
+
+	purge := false
+	if purgeParam := r.URL.Query().Get("purge"); purgeParam != "" {
+		if purge, err = strconv.ParseBool(purgeParam); err != nil {
+			JSONError(w, http.StatusBadRequest, fmt.Errorf("invalid purge parameter: %w", err), nil)
+			return
+		}
+	}
+
+	if err := a.parser.Unsubscribe(r.Context(), address, purge); errors.Is(err, parserpkg.ErrNotSubscribed) {
+		JSONError(w, http.StatusNotFound, err, nil)
+		return
+	} else if err != nil {
+		JSONError(w, http.StatusInternalServerError, fmt.Errorf("failed to unsubscribe from address: %w", err), nil)
+		return
+	}
+
+	JSONResponse(w, http.StatusOK, "Address unsubscribed", nil)
+}
+
+// GetSubscriptionsHandler returns the subscribed addresses along with their status
+func (a *api) GetSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		JSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method), nil)
+		return
+	}
+
+	subscriptions, err := a.parser.GetSubscriptions()
+	if err != nil {
+		JSONError(w, http.StatusInternalServerError, fmt.Errorf("failed to get subscriptions: %w", err), nil)
+		return
+	}
+
+	resp := map[string]any{
+		"subscriptions": subscriptions,
+	}
+	JSONResponse(w, http.StatusOK, "Subscribed addresses", resp)
+}
+
+// GetTransactionsHandler returns a page of the transactions for a given address, optionally within a block range
+// and only the ones with a minimum number of confirmations
 func (a *api) GetTransactionsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		JSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method), nil)
 		return
 	}
 
-	address := r.URL.Query().Get("address")
-	transactions, err := a.parser.GetTransactions(address)
+	address, err := parseAddress(r.URL.Query().Get("address"))
+	if err != nil {
+		JSONError(w, http.StatusBadRequest, err, nil)
+		return
+	}
+
+	query, err := parseQuery(r)
+	if err != nil {
+		JSONError(w, http.StatusBadRequest, err, nil)
+		return
+	}
+
+	transactions, nextCursor, err := a.parser.GetTransactions(r.Context(), address, query)
 	if err != nil {
 		JSONError(w, http.StatusInternalServerError, fmt.Errorf("failed to get transactions: %w", err), nil)
 		return
@@ -66,9 +152,297 @@ func (a *api) GetTransactionsHandler(w http.ResponseWriter, r *http.Request) {
 	resp := map[string]any{
 		"transactions": transactions,
 	}
+	if nextCursor != "" {
+		resp["nextCursor"] = nextCursor
+	}
 	JSONResponse(w, http.StatusOK, "Transactions for address", resp)
 }
 
+// GetLogsHandler returns a page of the event logs emitted by a given address, optionally within a block range
+// and matching topic filters
+func (a *api) GetLogsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		JSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method), nil)
+		return
+	}
+
+	address, err := parseAddress(r.URL.Query().Get("address"))
+	if err != nil {
+		JSONError(w, http.StatusBadRequest, err, nil)
+		return
+	}
+
+	query, err := parseQuery(r)
+	if err != nil {
+		JSONError(w, http.StatusBadRequest, err, nil)
+		return
+	}
+
+	// topic0 to topic3 hold the comma separated topics allowed at each position
+	for i := range 4 {
+		if topics := r.URL.Query().Get(fmt.Sprintf("topic%d", i)); topics != "" {
+			for len(query.Topics) < i {
+				query.Topics = append(query.Topics, nil)
+			}
+			query.Topics = append(query.Topics, strings.Split(topics, ","))
+		}
+	}
+
+	logs, nextCursor, err := a.parser.GetLogs(r.Context(), address, query)
+	if err != nil {
+		JSONError(w, http.StatusInternalServerError, fmt.Errorf("failed to get logs: %w", err), nil)
+		return
+	}
+
+	if len(logs) == 0 {
+		JSONError(w, http.StatusNotFound, fmt.Errorf("no logs found"), nil)
+		return
+	}
+
+	resp := map[string]any{
+		"logs": logs,
+	}
+	if nextCursor != "" {
+		resp["nextCursor"] = nextCursor
+	}
+	JSONResponse(w, http.StatusOK, "Logs for address", resp)
+}
+
+// parseAddress validates an address and returns its canonical form
+func parseAddress(raw string) (string, error) {
+	if raw == "" {
+		return "", fmt.Errorf("missing address")
+	}
+	return addresspkg.Normalize(raw)
+}
+
+// parseBlock parses a block number, accepted in decimal, or in hex as they are returned
+func parseBlock(param string) (uint64, error) {
+	if hex, ok := strings.CutPrefix(param, "0x"); ok {
+		return strconv.ParseUint(hex, 16, 64)
+	}
+	return strconv.ParseUint(param, 10, 64)
+}
+
+// parseQuery parses the pagination, ordering and block range parameters of a request
+func parseQuery(r *http.Request) (parserpkg.Query, error) {
+	params := r.URL.Query()
+	query := parserpkg.Query{
+		Order:  parserpkg.Order(params.Get("order")),
+		Cursor: params.Get("cursor"),
+	}
+
+	if minConfirmationsParam := params.Get("minConfirmations"); minConfirmationsParam != "" {
+		var err error
+		if query.MinConfirmations, err = strconv.ParseUint(minConfirmationsParam, 10, 64); err != nil {
+			return query, fmt.Errorf("invalid minConfirmations parameter: %w", err)
+		}
+	}
+
+	if limitParam := params.Get("limit"); limitParam != "" {
+		var err error
+		if query.Limit, err = strconv.Atoi(limitParam); err != nil {
+			return query, fmt.Errorf("invalid limit parameter: %w", err)
+		}
+	}
+
+	for name, bound := range map[string]**uint64{"fromBlock": &query.FromBlock, "toBlock": &query.ToBlock} {
+		param := params.Get(name)
+		if param == "" {
+			continue
+		}
+
+		block, err := parseBlock(param)
+		if err != nil {
+			return query, fmt.Errorf("invalid %s parameter: %w", name, err)
+		}
+		*bound = &block
+	}
+
+	if err := query.Validate(); err != nil {
+		return query, err
+	}
+
+	return query, nil
+}
+
+// maxABISize bounds the size of the ABIs set, the largest contract ABIs are a few hundred kilobytes
+const maxABISize = 1 << 20
+
+// ABIHandler sets, on PUT, the contract ABI the logs of a subscribed address are decoded with,
+// the body being the ABI JSON, and returns it on GET
+func (a *api) ABIHandler(w http.ResponseWriter, r *http.Request) {
+	address, err := parseAddress(r.PathValue("address"))
+	if err != nil {
+		JSONError(w, http.StatusBadRequest, err, nil)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		defer r.Body.Close()
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxABISize))
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			JSONError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("ABI larger than %d bytes", maxBytesErr.Limit), nil)
+			return
+		} else if err != nil {
+			JSONError(w, http.StatusBadRequest, fmt.Errorf("failed to read request: %w", err), nil)
+			return
+		}
+
+		if err := a.parser.SetABI(address, data); errors.Is(err, parserpkg.ErrInvalidABI) {
+			JSONError(w, http.StatusBadRequest, err, nil)
+			return
+		} else if errors.Is(err, parserpkg.ErrNotSubscribed) {
+			JSONError(w, http.StatusNotFound, err, nil)
+			return
+		} else if err != nil {
+			JSONError(w, http.StatusInternalServerError, fmt.Errorf("failed to set ABI: %w", err), nil)
+			return
+		}
+
+		JSONResponse(w, http.StatusOK, "ABI set", nil)
+	case http.MethodGet:
+		data, err := a.parser.GetABI(address)
+		if err != nil {
+			JSONError(w, http.StatusInternalServerError, fmt.Errorf("failed to get ABI: %w", err), nil)
+			return
+		} else if data == nil {
+			JSONError(w, http.StatusNotFound, fmt.Errorf("no ABI set for address %q", address), nil)
+			return
+		}
+
+		resp := map[string]any{
+			"abi": json.RawMessage(data),
+		}
+		JSONResponse(w, http.StatusOK, "Contract ABI", resp)
+	default:
+		JSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method), nil)
+	}
+}
+
+// GetNFTHoldingsHandler returns the ERC-721 and ERC-1155 tokens held by an owner
+func (a *api) GetNFTHoldingsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		JSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method), nil)
+		return
+	}
+
+	owner := r.URL.Query().Get("owner")
+	if owner == "" {
+		JSONError(w, http.StatusBadRequest, fmt.Errorf("missing owner"), nil)
+		return
+	}
+
+	owner, err := addresspkg.Normalize(owner)
+	if err != nil {
+		JSONError(w, http.StatusBadRequest, err, nil)
+		return
+	}
+
+	holdings, err := a.parser.GetNFTHoldings(owner)
+	if err != nil {
+		JSONError(w, http.StatusInternalServerError, fmt.Errorf("failed to get NFT holdings: %w", err), nil)
+		return
+	}
+
+	resp := map[string]any{
+		"holdings": holdings,
+	}
+	JSONResponse(w, http.StatusOK, "NFT holdings", resp)
+}
+
+// GetBalanceHandler returns the ETH balance of an address along with its balances of the comma separated
+// ERC-20 tokens, at the given block or the current one
+func (a *api) GetBalanceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		JSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method), nil)
+		return
+	}
+
+	address, err := parseAddress(r.URL.Query().Get("address"))
+	if err != nil {
+		JSONError(w, http.StatusBadRequest, err, nil)
+		return
+	}
+
+	var tokens []string
+	if tokensParam := r.URL.Query().Get("tokens"); tokensParam != "" {
+		for _, token := range strings.Split(tokensParam, ",") {
+			token, err := addresspkg.Normalize(token)
+			if err != nil {
+				JSONError(w, http.StatusBadRequest, fmt.Errorf("invalid token: %w", err), nil)
+				return
+			}
+			tokens = append(tokens, token)
+		}
+	}
+
+	var block *uint64
+	if blockParam := r.URL.Query().Get("block"); blockParam != "" {
+		number, err := parseBlock(blockParam)
+		if err != nil {
+			JSONError(w, http.StatusBadRequest, fmt.Errorf("invalid block parameter: %w", err), nil)
+			return
+		}
+		block = &number
+	}
+
+	balance, err := a.parser.GetBalance(r.Context(), address, tokens, block)
+	if errors.Is(err, parserpkg.ErrUnknownBlock) {
+		JSONError(w, http.StatusNotFound, err, nil)
+		return
+	} else if err != nil {
+		JSONError(w, http.StatusInternalServerError, fmt.Errorf("failed to get balance: %w", err), nil)
+		return
+	}
+
+	JSONResponse(w, http.StatusOK, "Balance of address", balance)
+}
+
+// GetDeadLettersHandler returns the webhook deliveries given up on after exhausting their retries
+func (a *api) GetDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		JSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method), nil)
+		return
+	}
+
+	deadLetters, err := a.parser.GetDeadLetters()
+	if err != nil {
+		JSONError(w, http.StatusInternalServerError, fmt.Errorf("failed to get dead letters: %w", err), nil)
+		return
+	}
+
+	resp := map[string]any{
+		"deadLetters": deadLetters,
+	}
+	JSONResponse(w, http.StatusOK, "Dead-lettered webhook deliveries", resp)
+}
+
+// GetProvidersHandler returns the health of the JSON-RPC providers, with a 503 status when none is healthy
+func (a *api) GetProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		JSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method), nil)
+		return
+	}
+
+	providers := a.parser.GetProviderHealth()
+
+	status := http.StatusServiceUnavailable
+	for _, provider := range providers {
+		if provider.Healthy {
+			status = http.StatusOK
+			break
+		}
+	}
+
+	resp := map[string]any{
+		"providers": providers,
+	}
+	JSONResponse(w, status, "JSON-RPC provider health", resp)
+}
+
 // GetBlockNumberHandler returns the current block number
 func (a *api) GetBlockNumberHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
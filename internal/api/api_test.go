@@ -5,29 +5,92 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 
 	"github.com/HomayoonAlimohammadi/blockchain-parser/internal/api"
 	parserpkg "github.com/HomayoonAlimohammadi/blockchain-parser/internal/parser"
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/nft"
 )
 
 type MockParser struct {
 	mock.Mock
 }
 
-func (m *MockParser) Subscribe(ctx context.Context, address string) error {
-	args := m.Called(ctx, address)
+func (m *MockParser) Subscribe(ctx context.Context, address string, opts parserpkg.SubscribeOptions) error {
+	args := m.Called(ctx, address, opts)
 	return args.Error(0)
 }
 
-func (m *MockParser) GetTransactions(address string) ([]parserpkg.Transaction, error) {
+func (m *MockParser) Unsubscribe(ctx context.Context, address string, purge bool) error {
+	args := m.Called(ctx, address, purge)
+	return args.Error(0)
+}
+
+func (m *MockParser) GetTransactions(ctx context.Context, address string, query parserpkg.Query) ([]parserpkg.Transaction, string, error) {
+	args := m.Called(ctx, address, query)
+	txns, _ := args.Get(0).([]parserpkg.Transaction)
+	return txns, args.String(1), args.Error(2)
+}
+
+func (m *MockParser) GetLogs(ctx context.Context, address string, query parserpkg.Query) ([]parserpkg.Log, string, error) {
+	args := m.Called(ctx, address, query)
+	logs, _ := args.Get(0).([]parserpkg.Log)
+	return logs, args.String(1), args.Error(2)
+}
+
+func (m *MockParser) GetSubscriptions() ([]parserpkg.SubscriptionStatus, error) {
+	args := m.Called()
+	statuses, _ := args.Get(0).([]parserpkg.SubscriptionStatus)
+	return statuses, args.Error(1)
+}
+
+func (m *MockParser) GetProviderHealth() []parserpkg.ProviderHealth {
+	args := m.Called()
+	health, _ := args.Get(0).([]parserpkg.ProviderHealth)
+	return health
+}
+
+func (m *MockParser) GetDeadLetters() ([]parserpkg.DeadLetter, error) {
+	args := m.Called()
+	deadLetters, _ := args.Get(0).([]parserpkg.DeadLetter)
+	return deadLetters, args.Error(1)
+}
+
+func (m *MockParser) Stream(address string, lastEventID uint64) (<-chan parserpkg.StreamEvent, func(), error) {
+	args := m.Called(address, lastEventID)
+	events, _ := args.Get(0).(<-chan parserpkg.StreamEvent)
+	return events, func() {}, args.Error(1)
+}
+
+func (m *MockParser) SetABI(address string, data []byte) error {
+	args := m.Called(address, data)
+	return args.Error(0)
+}
+
+func (m *MockParser) GetABI(address string) ([]byte, error) {
 	args := m.Called(address)
-	return args.Get(0).([]parserpkg.Transaction), args.Error(1)
+	data, _ := args.Get(0).([]byte)
+	return data, args.Error(1)
+}
+
+func (m *MockParser) GetNFTHoldings(owner string) ([]nft.Holding, error) {
+	args := m.Called(owner)
+	holdings, _ := args.Get(0).([]nft.Holding)
+	return holdings, args.Error(1)
+}
+
+func (m *MockParser) GetBalance(ctx context.Context, address string, tokens []string, block *uint64) (*parserpkg.Balance, error) {
+	args := m.Called(ctx, address, tokens, block)
+	balance, _ := args.Get(0).(*parserpkg.Balance)
+	return balance, args.Error(1)
 }
 
 func (m *MockParser) GetCurrentBlock(ctx context.Context) (int, error) {
@@ -58,9 +121,52 @@ func TestSubscribeHandler(t *testing.T) {
 	})
 
 	t.Run("Success", func(t *testing.T) {
-		mockParser.On("Subscribe", mock.Anything, "test-address").Return(nil)
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		mockParser.On("Subscribe", mock.Anything, "0x28c6c06298d514db089934071355e5743bf21d60", parserpkg.SubscribeOptions{}).Return(nil)
+
+		body := map[string]string{"address": "0x28c6c06298d514db089934071355e5743bf21d60"}
+		bodyBytes, _ := json.Marshal(body)
+		req, _ := http.NewRequest(http.MethodPost, "/subscribe", bytes.NewBuffer(bodyBytes))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.SubscribeHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		mockParser.AssertExpectations(t)
+	})
+
+	t.Run("Address", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		// Checksummed addresses are keyed by their lowercase form
+		mockParser.On("Subscribe", mock.Anything, "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", parserpkg.SubscribeOptions{}).Return(nil)
+
+		for address, status := range map[string]int{
+			"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed": http.StatusCreated,
+			"0x5AAeb6053F3E94C9b9A09f33669435E7Ef1BeAed": http.StatusBadRequest,
+			"0x5aaeb6053f3e94c9b9a09f33669435e7ef1bea":   http.StatusBadRequest,
+			"5aaeb6053f3e94c9b9a09f33669435e7ef1beaed":   http.StatusBadRequest,
+			"": http.StatusBadRequest,
+		} {
+			bodyBytes, _ := json.Marshal(map[string]string{"address": address})
+			req, _ := http.NewRequest(http.MethodPost, "/subscribe", bytes.NewBuffer(bodyBytes))
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(apiInstance.SubscribeHandler)
+			handler.ServeHTTP(rr, req)
 
-		body := map[string]string{"address": "test-address"}
+			assert.Equal(t, status, rr.Code, address)
+		}
+		mockParser.AssertNumberOfCalls(t, "Subscribe", 1)
+	})
+
+	t.Run("Callback", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		opts := parserpkg.SubscribeOptions{CallbackURL: "https://example.com/hook", CallbackSecret: "secret"}
+		mockParser.On("Subscribe", mock.Anything, "0x28c6c06298d514db089934071355e5743bf21d60", opts).Return(nil)
+
+		body := map[string]string{"address": "0x28c6c06298d514db089934071355e5743bf21d60", "callbackUrl": opts.CallbackURL, "callbackSecret": opts.CallbackSecret}
 		bodyBytes, _ := json.Marshal(body)
 		req, _ := http.NewRequest(http.MethodPost, "/subscribe", bytes.NewBuffer(bodyBytes))
 		rr := httptest.NewRecorder()
@@ -71,10 +177,86 @@ func TestSubscribeHandler(t *testing.T) {
 		mockParser.AssertExpectations(t)
 	})
 
+	t.Run("Filter", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		transferTopic := "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+		opts := parserpkg.SubscribeOptions{
+			Addresses: []string{"0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"},
+			Topics:    [][]string{{transferTopic}, nil},
+		}
+		mockParser.On("Subscribe", mock.Anything, "0x28c6c06298d514db089934071355e5743bf21d60", opts).Return(nil)
+
+		body := `{"address": "0x28c6c06298d514db089934071355e5743bf21d60", "addresses": ["0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"], "topics": [["` + transferTopic + `"], null]}`
+		req, _ := http.NewRequest(http.MethodPost, "/subscribe", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.SubscribeHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		mockParser.AssertExpectations(t)
+
+		req, _ = http.NewRequest(http.MethodPost, "/subscribe", strings.NewReader(`{"address": "0x28c6c06298d514db089934071355e5743bf21d60", "topics": [["0x1234"]]}`))
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("FromBlock", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		fromBlock := uint64(19000000)
+		mockParser.On("Subscribe", mock.Anything, "0x28c6c06298d514db089934071355e5743bf21d60", parserpkg.SubscribeOptions{FromBlock: &fromBlock}).Return(nil)
+
+		body := `{"address": "0x28c6c06298d514db089934071355e5743bf21d60", "fromBlock": 19000000}`
+		req, _ := http.NewRequest(http.MethodPost, "/subscribe", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.SubscribeHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		mockParser.AssertExpectations(t)
+	})
+
+	t.Run("InvalidCallback", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+
+		body := map[string]string{"address": "0x28c6c06298d514db089934071355e5743bf21d60", "callbackUrl": "ftp://example.com/hook", "callbackSecret": "secret"}
+		bodyBytes, _ := json.Marshal(body)
+		req, _ := http.NewRequest(http.MethodPost, "/subscribe", bytes.NewBuffer(bodyBytes))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.SubscribeHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		mockParser.AssertNotCalled(t, "Subscribe", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("FutureBlock", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		fromBlock := uint64(99999999)
+		mockParser.On("Subscribe", mock.Anything, "0x28c6c06298d514db089934071355e5743bf21d60", parserpkg.SubscribeOptions{FromBlock: &fromBlock}).
+			Return(fmt.Errorf("%w: block 99999999 is past the current block 16", parserpkg.ErrFutureBlock))
+
+		bodyBytes := []byte(`{"address": "0x28c6c06298d514db089934071355e5743bf21d60", "fromBlock": 99999999}`)
+		req, _ := http.NewRequest(http.MethodPost, "/subscribe", bytes.NewBuffer(bodyBytes))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.SubscribeHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		mockParser.AssertExpectations(t)
+	})
+
 	t.Run("InternalServerError", func(t *testing.T) {
-		mockParser.On("Subscribe", mock.Anything, "test-address").Return(fmt.Errorf("error"))
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		mockParser.On("Subscribe", mock.Anything, "0x28c6c06298d514db089934071355e5743bf21d60", parserpkg.SubscribeOptions{}).Return(fmt.Errorf("error"))
 
-		body := map[string]string{"address": "test-address"}
+		body := map[string]string{"address": "0x28c6c06298d514db089934071355e5743bf21d60"}
 		bodyBytes, _ := json.Marshal(body)
 		req, _ := http.NewRequest(http.MethodPost, "/subscribe", bytes.NewBuffer(bodyBytes))
 		rr := httptest.NewRecorder()
@@ -86,6 +268,132 @@ func TestSubscribeHandler(t *testing.T) {
 	})
 }
 
+func TestUnsubscribeHandler(t *testing.T) {
+	mockParser := new(MockParser)
+	apiInstance := api.NewAPI(mockParser)
+
+	t.Run("MethodNotAllowed", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/subscriptions/0x28c6c06298d514db089934071355e5743bf21d60", nil)
+		req.SetPathValue("address", "0x28c6c06298d514db089934071355e5743bf21d60")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.UnsubscribeHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	})
+
+	t.Run("InvalidAddress", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodDelete, "/subscriptions/0xinvalid", nil)
+		req.SetPathValue("address", "0xinvalid")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.UnsubscribeHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("BadRequest", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodDelete, "/subscriptions/0x28c6c06298d514db089934071355e5743bf21d60?purge=maybe", nil)
+		req.SetPathValue("address", "0x28c6c06298d514db089934071355e5743bf21d60")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.UnsubscribeHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		mockParser.On("Unsubscribe", mock.Anything, "0x28c6c06298d514db089934071355e5743bf21d60", true).Return(nil)
+
+		req, _ := http.NewRequest(http.MethodDelete, "/subscriptions/0x28c6c06298d514db089934071355e5743bf21d60?purge=true", nil)
+		req.SetPathValue("address", "0x28c6c06298d514db089934071355e5743bf21d60")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.UnsubscribeHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		mockParser.AssertExpectations(t)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		mockParser.On("Unsubscribe", mock.Anything, "0x28c6c06298d514db089934071355e5743bf21d60", false).Return(fmt.Errorf("wrapped: %w", parserpkg.ErrNotSubscribed))
+
+		req, _ := http.NewRequest(http.MethodDelete, "/subscriptions/0x28c6c06298d514db089934071355e5743bf21d60", nil)
+		req.SetPathValue("address", "0x28c6c06298d514db089934071355e5743bf21d60")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.UnsubscribeHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		mockParser.AssertExpectations(t)
+	})
+
+	t.Run("InternalServerError", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		mockParser.On("Unsubscribe", mock.Anything, "0x28c6c06298d514db089934071355e5743bf21d60", false).Return(fmt.Errorf("error"))
+
+		req, _ := http.NewRequest(http.MethodDelete, "/subscriptions/0x28c6c06298d514db089934071355e5743bf21d60", nil)
+		req.SetPathValue("address", "0x28c6c06298d514db089934071355e5743bf21d60")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.UnsubscribeHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+		mockParser.AssertExpectations(t)
+	})
+}
+
+func TestGetSubscriptionsHandler(t *testing.T) {
+	mockParser := new(MockParser)
+	apiInstance := api.NewAPI(mockParser)
+
+	t.Run("MethodNotAllowed", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, "/subscriptions", nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.GetSubscriptionsHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		mockStatuses := []parserpkg.SubscriptionStatus{
+			{Address: "0x28c6c06298d514db089934071355e5743bf21d60", TransactionCount: 2, ConnectionState: parserpkg.ConnectionStateConnected},
+		}
+		mockParser.On("GetSubscriptions").Return(mockStatuses, nil)
+
+		req, _ := http.NewRequest(http.MethodGet, "/subscriptions", nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.GetSubscriptionsHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"connectionState":"connected"`)
+		mockParser.AssertExpectations(t)
+	})
+
+	t.Run("InternalServerError", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		mockParser.On("GetSubscriptions").Return(nil, fmt.Errorf("error"))
+
+		req, _ := http.NewRequest(http.MethodGet, "/subscriptions", nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.GetSubscriptionsHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+		mockParser.AssertExpectations(t)
+	})
+}
+
 func TestGetTransactionsHandler(t *testing.T) {
 	mockParser := new(MockParser)
 	apiInstance := api.NewAPI(mockParser)
@@ -100,10 +408,10 @@ func TestGetTransactionsHandler(t *testing.T) {
 	})
 
 	t.Run("Success", func(t *testing.T) {
-		mockTransactions := []parserpkg.Transaction{{Data: "tx1"}, {Data: "tx2"}}
-		mockParser.On("GetTransactions", "test-address").Return(mockTransactions, nil)
+		mockTransactions := []parserpkg.Transaction{{Hash: "tx1"}, {Hash: "tx2"}}
+		mockParser.On("GetTransactions", mock.Anything, "0x28c6c06298d514db089934071355e5743bf21d60", parserpkg.Query{}).Return(mockTransactions, "", nil)
 
-		req, _ := http.NewRequest(http.MethodGet, "/transactions?address=test-address", nil)
+		req, _ := http.NewRequest(http.MethodGet, "/transactions?address=0x28c6c06298d514db089934071355e5743bf21d60", nil)
 		rr := httptest.NewRecorder()
 		handler := http.HandlerFunc(apiInstance.GetTransactionsHandler)
 		handler.ServeHTTP(rr, req)
@@ -112,10 +420,86 @@ func TestGetTransactionsHandler(t *testing.T) {
 		mockParser.AssertExpectations(t)
 	})
 
+	t.Run("MinConfirmations", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		mockParser.On("GetTransactions", mock.Anything, "0x28c6c06298d514db089934071355e5743bf21d60", parserpkg.Query{MinConfirmations: 12}).Return([]parserpkg.Transaction{{Hash: "tx1"}}, "", nil)
+
+		req, _ := http.NewRequest(http.MethodGet, "/transactions?address=0x28c6c06298d514db089934071355e5743bf21d60&minConfirmations=12", nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.GetTransactionsHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		mockParser.AssertExpectations(t)
+	})
+
+	t.Run("InvalidMinConfirmations", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+
+		req, _ := http.NewRequest(http.MethodGet, "/transactions?address=0x28c6c06298d514db089934071355e5743bf21d60&minConfirmations=-1", nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.GetTransactionsHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		mockParser.AssertNotCalled(t, "GetTransactions", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Pagination", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		fromBlock, toBlock := uint64(16), uint64(32)
+		query := parserpkg.Query{FromBlock: &fromBlock, ToBlock: &toBlock, Order: parserpkg.OrderDesc, Cursor: "MTY6MA", Limit: 1}
+		mockParser.On("GetTransactions", mock.Anything, "0x28c6c06298d514db089934071355e5743bf21d60", query).Return([]parserpkg.Transaction{{Hash: "tx1"}}, "MTY6MQ", nil)
+
+		req, _ := http.NewRequest(http.MethodGet, "/transactions?address=0x28c6c06298d514db089934071355e5743bf21d60&fromBlock=16&toBlock=0x20&order=desc&cursor=MTY6MA&limit=1", nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.GetTransactionsHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"nextCursor":"MTY6MQ"`)
+		mockParser.AssertExpectations(t)
+	})
+
+	t.Run("InvalidQuery", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+
+		for _, params := range []string{"order=newest", "limit=5000", "fromBlock=32&toBlock=16", "toBlock=latest", "cursor=invalid"} {
+			req, _ := http.NewRequest(http.MethodGet, "/transactions?address=0x28c6c06298d514db089934071355e5743bf21d60&"+params, nil)
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(apiInstance.GetTransactionsHandler)
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusBadRequest, rr.Code, params)
+		}
+		mockParser.AssertNotCalled(t, "GetTransactions", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("InvalidAddress", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+
+		for _, params := range []string{"", "address=0x28c6", "address=0x28C6C06298d514db089934071355e5743bf21d60"} {
+			req, _ := http.NewRequest(http.MethodGet, "/transactions?"+params, nil)
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(apiInstance.GetTransactionsHandler)
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusBadRequest, rr.Code, params)
+		}
+		mockParser.AssertNotCalled(t, "GetTransactions", mock.Anything, mock.Anything, mock.Anything)
+	})
+
 	t.Run("InternalServerError", func(t *testing.T) {
-		mockParser.On("GetTransactions", "test-address").Return(nil, fmt.Errorf("error"))
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		mockParser.On("GetTransactions", mock.Anything, "0x28c6c06298d514db089934071355e5743bf21d60", parserpkg.Query{}).Return(nil, "", fmt.Errorf("error"))
 
-		req, _ := http.NewRequest(http.MethodGet, "/transactions?address=test-address", nil)
+		req, _ := http.NewRequest(http.MethodGet, "/transactions?address=0x28c6c06298d514db089934071355e5743bf21d60", nil)
 		rr := httptest.NewRecorder()
 		handler := http.HandlerFunc(apiInstance.GetTransactionsHandler)
 		handler.ServeHTTP(rr, req)
@@ -125,9 +509,11 @@ func TestGetTransactionsHandler(t *testing.T) {
 	})
 
 	t.Run("NotFound", func(t *testing.T) {
-		mockParser.On("GetTransactions", "test-address").Return(nil, nil)
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		mockParser.On("GetTransactions", mock.Anything, "0x28c6c06298d514db089934071355e5743bf21d60", parserpkg.Query{}).Return(nil, "", nil)
 
-		req, _ := http.NewRequest(http.MethodGet, "/transactions?address=test-address", nil)
+		req, _ := http.NewRequest(http.MethodGet, "/transactions?address=0x28c6c06298d514db089934071355e5743bf21d60", nil)
 		rr := httptest.NewRecorder()
 		handler := http.HandlerFunc(apiInstance.GetTransactionsHandler)
 		handler.ServeHTTP(rr, req)
@@ -137,6 +523,489 @@ func TestGetTransactionsHandler(t *testing.T) {
 	})
 }
 
+func TestGetLogsHandler(t *testing.T) {
+	t.Run("MethodNotAllowed", func(t *testing.T) {
+		apiInstance := api.NewAPI(new(MockParser))
+
+		req, _ := http.NewRequest(http.MethodPost, "/logs", nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.GetLogsHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		mockLogs := []parserpkg.Log{{Data: "log1"}, {Data: "log2"}}
+		mockParser.On("GetLogs", mock.Anything, "0x28c6c06298d514db089934071355e5743bf21d60", parserpkg.Query{}).Return(mockLogs, "", nil)
+
+		req, _ := http.NewRequest(http.MethodGet, "/logs?address=0x28c6c06298d514db089934071355e5743bf21d60", nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.GetLogsHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		mockParser.AssertExpectations(t)
+	})
+
+	t.Run("Topics", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		query := parserpkg.Query{Topics: [][]string{nil, {"0xa", "0xb"}}}
+		mockParser.On("GetLogs", mock.Anything, "0x28c6c06298d514db089934071355e5743bf21d60", query).Return([]parserpkg.Log{{Data: "log1"}}, "", nil)
+
+		req, _ := http.NewRequest(http.MethodGet, "/logs?address=0x28c6c06298d514db089934071355e5743bf21d60&topic1=0xa,0xb", nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.GetLogsHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NotContains(t, rr.Body.String(), "nextCursor")
+		mockParser.AssertExpectations(t)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		mockParser.On("GetLogs", mock.Anything, "0x28c6c06298d514db089934071355e5743bf21d60", parserpkg.Query{}).Return(nil, "", nil)
+
+		req, _ := http.NewRequest(http.MethodGet, "/logs?address=0x28c6c06298d514db089934071355e5743bf21d60", nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.GetLogsHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		mockParser.AssertExpectations(t)
+	})
+}
+
+// closedStream returns a stream of the given events that ends once they are read
+func closedStream(events ...parserpkg.StreamEvent) <-chan parserpkg.StreamEvent {
+	stream := make(chan parserpkg.StreamEvent, len(events))
+	for _, event := range events {
+		stream <- event
+	}
+	close(stream)
+	return stream
+}
+
+func TestStreamHandler(t *testing.T) {
+	mockParser := new(MockParser)
+	apiInstance := api.NewAPI(mockParser)
+
+	t.Run("MethodNotAllowed", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, "/stream?address=0x28c6c06298d514db089934071355e5743bf21d60", nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.StreamHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	})
+
+	t.Run("InvalidLastEventID", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/stream?address=0x28c6c06298d514db089934071355e5743bf21d60", nil)
+		req.Header.Set("Last-Event-ID", "abc")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.StreamHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("NotSubscribed", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		mockParser.On("Stream", "0x28c6c06298d514db089934071355e5743bf21d60", uint64(0)).Return(nil, parserpkg.ErrNotSubscribed)
+
+		req, _ := http.NewRequest(http.MethodGet, "/stream?address=0x28c6c06298d514db089934071355e5743bf21d60", nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.StreamHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		mockParser.AssertExpectations(t)
+	})
+
+	t.Run("SSE", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		mockParser.On("Stream", "0x28c6c06298d514db089934071355e5743bf21d60", uint64(7)).Return(closedStream(
+			parserpkg.StreamEvent{ID: 8, Event: parserpkg.Event{Address: "0x28c6c06298d514db089934071355e5743bf21d60", Transaction: &parserpkg.Transaction{Hash: "0xa"}}},
+			parserpkg.StreamEvent{ID: 9, Event: parserpkg.Event{Address: "0x28c6c06298d514db089934071355e5743bf21d60", Log: &parserpkg.Log{TransactionHash: "0xb"}}},
+		), nil)
+
+		req, _ := http.NewRequest(http.MethodGet, "/stream?address=0x28c6c06298d514db089934071355e5743bf21d60", nil)
+		req.Header.Set("Last-Event-ID", "7")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.StreamHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "text/event-stream", rr.Header().Get("Content-Type"))
+		assert.Contains(t, rr.Body.String(), "id: 8\nevent: transaction\ndata: {\"id\":8,\"address\":\"0x28c6c06298d514db089934071355e5743bf21d60\",\"transaction\":{")
+		assert.Contains(t, rr.Body.String(), "id: 9\nevent: log\ndata: {\"id\":9,")
+		mockParser.AssertExpectations(t)
+	})
+
+	t.Run("Websocket", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		mockParser.On("Stream", "0x28c6c06298d514db089934071355e5743bf21d60", uint64(7)).Return(closedStream(
+			parserpkg.StreamEvent{ID: 8, Event: parserpkg.Event{Address: "0x28c6c06298d514db089934071355e5743bf21d60", Transaction: &parserpkg.Transaction{Hash: "0xa"}}},
+		), nil)
+
+		server := httptest.NewServer(http.HandlerFunc(apiInstance.StreamHandler))
+		defer server.Close()
+
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/stream?address=0x28c6c06298d514db089934071355e5743bf21d60&lastEventId=7", nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+
+		var event parserpkg.StreamEvent
+		assert.NoError(t, conn.ReadJSON(&event))
+		assert.Equal(t, uint64(8), event.ID)
+		assert.Equal(t, "0xa", event.Transaction.Hash)
+
+		_, _, err = conn.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway))
+		mockParser.AssertExpectations(t)
+	})
+
+	t.Run("WebsocketOrigin", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		mockParser.On("Stream", "0x28c6c06298d514db089934071355e5743bf21d60", uint64(0)).Return(closedStream(), nil)
+
+		server := httptest.NewServer(http.HandlerFunc(apiInstance.StreamHandler))
+		defer server.Close()
+
+		dial := func(origin string) (*http.Response, error) {
+			conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/stream?address=0x28c6c06298d514db089934071355e5743bf21d60", http.Header{"Origin": {origin}})
+			if err == nil {
+				conn.Close()
+			}
+			return resp, err
+		}
+
+		// Cross-origin pages are rejected unless allowed
+		resp, err := dial("https://app.example.com")
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		_, err = dial(server.URL)
+		assert.NoError(t, err)
+
+		apiInstance.SetAllowedOrigins([]string{"https://app.example.com/"})
+		_, err = dial("https://app.example.com")
+		assert.NoError(t, err)
+		resp, err = dial("https://other.example.com")
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		apiInstance.SetAllowedOrigins([]string{"*"})
+		_, err = dial("https://other.example.com")
+		assert.NoError(t, err)
+	})
+}
+
+func TestABIHandler(t *testing.T) {
+	contractABI := []byte(`[{"type":"event","name":"Transfer","inputs":[]}]`)
+
+	t.Run("Set", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		mockParser.On("SetABI", "0x28c6c06298d514db089934071355e5743bf21d60", contractABI).Return(nil)
+
+		req, _ := http.NewRequest(http.MethodPut, "/subscriptions/0x28c6c06298d514db089934071355e5743bf21d60/abi", bytes.NewReader(contractABI))
+		req.SetPathValue("address", "0x28c6c06298d514db089934071355e5743bf21d60")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.ABIHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		mockParser.AssertExpectations(t)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		mockParser.On("SetABI", "0x28c6c06298d514db089934071355e5743bf21d60", []byte("{}")).Return(fmt.Errorf("%w: not an array", parserpkg.ErrInvalidABI))
+
+		req, _ := http.NewRequest(http.MethodPut, "/subscriptions/0x28c6c06298d514db089934071355e5743bf21d60/abi", strings.NewReader("{}"))
+		req.SetPathValue("address", "0x28c6c06298d514db089934071355e5743bf21d60")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.ABIHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("TooLarge", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+
+		req, _ := http.NewRequest(http.MethodPut, "/subscriptions/0x28c6c06298d514db089934071355e5743bf21d60/abi", strings.NewReader("["+strings.Repeat(" ", 1<<20)+"]"))
+		req.SetPathValue("address", "0x28c6c06298d514db089934071355e5743bf21d60")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.ABIHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+		mockParser.AssertNotCalled(t, "SetABI", mock.Anything, mock.Anything)
+	})
+
+	t.Run("NotSubscribed", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		mockParser.On("SetABI", "0x28c6c06298d514db089934071355e5743bf21d60", contractABI).Return(fmt.Errorf("wrapped: %w", parserpkg.ErrNotSubscribed))
+
+		req, _ := http.NewRequest(http.MethodPut, "/subscriptions/0x28c6c06298d514db089934071355e5743bf21d60/abi", bytes.NewReader(contractABI))
+		req.SetPathValue("address", "0x28c6c06298d514db089934071355e5743bf21d60")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.ABIHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		mockParser.On("GetABI", "0x28c6c06298d514db089934071355e5743bf21d60").Return(contractABI, nil)
+		mockParser.On("GetABI", "0x1111111111111111111111111111111111111111").Return(nil, nil)
+
+		req, _ := http.NewRequest(http.MethodGet, "/subscriptions/0x28c6c06298d514db089934071355e5743bf21d60/abi", nil)
+		req.SetPathValue("address", "0x28c6c06298d514db089934071355e5743bf21d60")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.ABIHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var resp struct {
+			Data struct {
+				ABI json.RawMessage `json:"abi"`
+			} `json:"data"`
+		}
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.JSONEq(t, string(contractABI), string(resp.Data.ABI))
+
+		req, _ = http.NewRequest(http.MethodGet, "/subscriptions/0x1111111111111111111111111111111111111111/abi", nil)
+		req.SetPathValue("address", "0x1111111111111111111111111111111111111111")
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("MethodNotAllowed", func(t *testing.T) {
+		apiInstance := api.NewAPI(new(MockParser))
+
+		req, _ := http.NewRequest(http.MethodPost, "/subscriptions/0x28c6c06298d514db089934071355e5743bf21d60/abi", nil)
+		req.SetPathValue("address", "0x28c6c06298d514db089934071355e5743bf21d60")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.ABIHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	})
+}
+
+func TestGetNFTHoldingsHandler(t *testing.T) {
+	t.Run("MissingOwner", func(t *testing.T) {
+		apiInstance := api.NewAPI(new(MockParser))
+
+		req, _ := http.NewRequest(http.MethodGet, "/nfts", nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.GetNFTHoldingsHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		holdings := []nft.Holding{{Contract: "0xcontract", Standard: nft.ERC721, TokenID: big.NewInt(7), Amount: big.NewInt(1)}}
+		mockParser.On("GetNFTHoldings", "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48").Return(holdings, nil)
+
+		req, _ := http.NewRequest(http.MethodGet, "/nfts?owner=0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48", nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.GetNFTHoldingsHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"holdings":[{"contract":"0xcontract","standard":"erc721","tokenId":"7","amount":"1"}]`)
+		mockParser.AssertExpectations(t)
+	})
+}
+
+func TestGetBalanceHandler(t *testing.T) {
+	t.Run("MethodNotAllowed", func(t *testing.T) {
+		apiInstance := api.NewAPI(new(MockParser))
+
+		req, _ := http.NewRequest(http.MethodPost, "/balance", nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.GetBalanceHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	})
+
+	t.Run("BadRequest", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+
+		for _, params := range []string{
+			"",
+			"address=0x28c6",
+			"address=0x28c6c06298d514db089934071355e5743bf21d60&tokens=0xtoken",
+			"address=0x28c6c06298d514db089934071355e5743bf21d60&block=latest",
+		} {
+			req, _ := http.NewRequest(http.MethodGet, "/balance?"+params, nil)
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(apiInstance.GetBalanceHandler)
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusBadRequest, rr.Code, params)
+		}
+		mockParser.AssertNotCalled(t, "GetBalance", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		block := uint64(16)
+		tokens := []string{"0xdac17f958d2ee523a2206206994597c13d831ec7", "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"}
+		balance := &parserpkg.Balance{
+			Address: "0x28c6c06298d514db089934071355e5743bf21d60",
+			Block:   16,
+			Balance: "1000",
+			Tokens:  []parserpkg.TokenBalance{{Token: tokens[0], Balance: "42"}, {Token: tokens[1], Balance: "0"}},
+		}
+		mockParser.On("GetBalance", mock.Anything, "0x28c6c06298d514db089934071355e5743bf21d60", tokens, &block).Return(balance, nil)
+
+		req, _ := http.NewRequest(http.MethodGet, "/balance?address=0x28C6c06298d514Db089934071355E5743bf21d60&tokens=0xdAC17F958D2ee523a2206206994597C13D831ec7,0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48&block=0x10", nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.GetBalanceHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"balance":"1000"`)
+		assert.Contains(t, rr.Body.String(), `{"token":"0xdac17f958d2ee523a2206206994597c13d831ec7","balance":"42"}`)
+		mockParser.AssertExpectations(t)
+	})
+
+	t.Run("UnknownBlock", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		mockParser.On("GetBalance", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, parserpkg.ErrUnknownBlock)
+
+		req, _ := http.NewRequest(http.MethodGet, "/balance?address=0x28c6c06298d514db089934071355e5743bf21d60&block=99999999", nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.GetBalanceHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+}
+
+func TestGetDeadLettersHandler(t *testing.T) {
+	mockParser := new(MockParser)
+	apiInstance := api.NewAPI(mockParser)
+
+	t.Run("MethodNotAllowed", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, "/webhooks/deadletters", nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.GetDeadLettersHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		deadLetters := []parserpkg.DeadLetter{{ID: 1, Address: "0x28c6c06298d514db089934071355e5743bf21d60", CallbackURL: "https://example.com/hook", Attempts: 10, LastError: "unexpected status 500"}}
+		mockParser.On("GetDeadLetters").Return(deadLetters, nil)
+
+		req, _ := http.NewRequest(http.MethodGet, "/webhooks/deadletters", nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.GetDeadLettersHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"lastError":"unexpected status 500"`)
+		mockParser.AssertExpectations(t)
+	})
+
+	t.Run("InternalServerError", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		mockParser.On("GetDeadLetters").Return(nil, fmt.Errorf("error"))
+
+		req, _ := http.NewRequest(http.MethodGet, "/webhooks/deadletters", nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.GetDeadLettersHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+		mockParser.AssertExpectations(t)
+	})
+}
+
+func TestGetProvidersHandler(t *testing.T) {
+	mockParser := new(MockParser)
+	apiInstance := api.NewAPI(mockParser)
+
+	t.Run("MethodNotAllowed", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, "/providers", nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.GetProvidersHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		mockParser.On("GetProviderHealth").Return([]parserpkg.ProviderHealth{
+			{Name: "https://primary.example.com", Healthy: true, Streaming: true, LatencyMS: 42, HeadBlock: 16},
+			{Name: "https://fallback.example.com", HeadBlock: 10, HeadLag: 6},
+		})
+
+		req, _ := http.NewRequest(http.MethodGet, "/providers", nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.GetProvidersHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"name":"https://primary.example.com"`)
+		assert.Contains(t, rr.Body.String(), `"headLag":6`)
+		mockParser.AssertExpectations(t)
+	})
+
+	t.Run("ServiceUnavailable", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
+		mockParser.On("GetProviderHealth").Return([]parserpkg.ProviderHealth{
+			{Name: "https://primary.example.com", ConsecutiveFailures: 3, LastError: "unexpected status 503 Service Unavailable"},
+		})
+
+		req, _ := http.NewRequest(http.MethodGet, "/providers", nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(apiInstance.GetProvidersHandler)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		mockParser.AssertExpectations(t)
+	})
+}
+
 func TestGetBlockNumberHandler(t *testing.T) {
 	mockParser := new(MockParser)
 	apiInstance := api.NewAPI(mockParser)
@@ -151,6 +1020,8 @@ func TestGetBlockNumberHandler(t *testing.T) {
 	})
 
 	t.Run("Success", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
 		mockParser.On("GetCurrentBlock", mock.Anything).Return(12345, nil)
 
 		req, _ := http.NewRequest(http.MethodGet, "/blocknumber", nil)
@@ -163,6 +1034,8 @@ func TestGetBlockNumberHandler(t *testing.T) {
 	})
 
 	t.Run("InternalServerError", func(t *testing.T) {
+		mockParser := new(MockParser)
+		apiInstance := api.NewAPI(mockParser)
 		mockParser.On("GetCurrentBlock", mock.Anything).Return(0, fmt.Errorf("error"))
 
 		req, _ := http.NewRequest(http.MethodGet, "/blocknumber", nil)
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
 )
 
@@ -21,7 +22,7 @@ func TestJSONResponse(t *testing.T) {
 	expected := standardResponse{
 		Status:  http.StatusText(http.StatusOK),
 		Message: message,
-		Data:    data,
+		Data:    map[string]any{"key": "value"},
 	}
 
 	var actual standardResponse
@@ -29,7 +30,7 @@ func TestJSONResponse(t *testing.T) {
 		t.Errorf("could not decode response: %v", err)
 	}
 
-	if actual != expected {
+	if !reflect.DeepEqual(actual, expected) {
 		t.Errorf("handler returned unexpected body: got %v want %v", actual, expected)
 	}
 }
@@ -47,7 +48,7 @@ func TestJSONError(t *testing.T) {
 	expected := standardError{
 		Status: http.StatusText(http.StatusInternalServerError),
 		Error:  http.ErrBodyNotAllowed.Error(),
-		Data:   data,
+		Data:   map[string]any{"key": "value"},
 	}
 
 	var actual standardError
@@ -55,7 +56,7 @@ func TestJSONError(t *testing.T) {
 		t.Errorf("could not decode response: %v", err)
 	}
 
-	if actual != expected {
+	if !reflect.DeepEqual(actual, expected) {
 		t.Errorf("handler returned unexpected body: got %v want %v", actual, expected)
 	}
 }
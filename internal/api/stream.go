@@ -0,0 +1,187 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	parserpkg "github.com/HomayoonAlimohammadi/blockchain-parser/internal/parser"
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/log"
+)
+
+const (
+	// keepAliveInterval is how often an idle stream is written to, so proxies do not close it
+	keepAliveInterval = 15 * time.Second
+	// writeTimeout bounds a single write to a websocket listener
+	writeTimeout = 10 * time.Second
+)
+
+// SetAllowedOrigins sets the origins of the pages allowed to open websocket streams, "*" allowing any.
+// Only the pages served from the same host as the API are allowed if none is set.
+func (a *api) SetAllowedOrigins(origins []string) {
+	allowed := make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		allowed[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+	}
+
+	a.upgrader.CheckOrigin = func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" || allowed["*"] || allowed[strings.ToLower(origin)] {
+			return true
+		}
+
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+}
+
+// StreamHandler streams the new transactions and logs of a subscribed address, as Server-Sent Events
+// or as JSON websocket messages when the request is a websocket upgrade. Listeners resume after the
+// event ID in the Last-Event-ID header or the lastEventId parameter.
+func (a *api) StreamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		JSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method), nil)
+		return
+	}
+
+	address, err := parseAddress(r.URL.Query().Get("address"))
+	if err != nil {
+		JSONError(w, http.StatusBadRequest, err, nil)
+		return
+	}
+
+	lastEventIDParam := r.Header.Get("Last-Event-ID")
+	if lastEventIDParam == "" {
+		lastEventIDParam = r.URL.Query().Get("lastEventId")
+	}
+
+	var lastEventID uint64
+	if lastEventIDParam != "" {
+		if lastEventID, err = strconv.ParseUint(lastEventIDParam, 10, 64); err != nil {
+			JSONError(w, http.StatusBadRequest, fmt.Errorf("invalid last event ID: %w", err), nil)
+			return
+		}
+	}
+
+	events, stop, err := a.parser.Stream(address, lastEventID)
+	if errors.Is(err, parserpkg.ErrNotSubscribed) {
+		JSONError(w, http.StatusNotFound, err, nil)
+		return
+	} else if err != nil {
+		JSONError(w, http.StatusInternalServerError, fmt.Errorf("failed to stream address: %w", err), nil)
+		return
+	}
+	defer stop()
+
+	if websocket.IsWebSocketUpgrade(r) {
+		a.streamWebsocket(w, r, events)
+		return
+	}
+
+	a.streamSSE(w, r, events)
+}
+
+// streamSSE writes the events as Server-Sent Events until the client goes away or the stream ends
+func (a *api) streamSSE(w http.ResponseWriter, r *http.Request, events <-chan parserpkg.StreamEvent) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		JSONError(w, http.StatusInternalServerError, fmt.Errorf("streaming not supported"), nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Error(err, "failed to marshal stream event", "id", event.ID)
+				continue
+			}
+
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, eventType(event), data); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// streamWebsocket writes the events as JSON messages until the client goes away or the stream ends
+func (a *api) streamWebsocket(w http.ResponseWriter, r *http.Request, events <-chan parserpkg.StreamEvent) {
+	conn, err := a.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already replied with an error
+		log.Warn("failed to upgrade stream to websocket", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	// Reading is needed to process the control messages, the client is not expected to send anything else
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(writeTimeout))
+				return
+			}
+
+			conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// eventType returns the SSE event type of a stream event
+func eventType(event parserpkg.StreamEvent) string {
+	if event.Transaction != nil {
+		return "transaction"
+	}
+	return "log"
+}
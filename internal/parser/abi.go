@@ -0,0 +1,117 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/abi"
+	addresspkg "github.com/HomayoonAlimohammadi/blockchain-parser/pkg/eth/address"
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/log"
+)
+
+// ErrInvalidABI is returned when setting an ABI that cannot be parsed
+var ErrInvalidABI = errors.New("invalid ABI")
+
+// SetABI sets the contract ABI the logs of a subscribed address are decoded with
+func (p *EthereumParser) SetABI(address string, data []byte) error {
+	address, err := addresspkg.Normalize(address)
+	if err != nil {
+		return err
+	}
+
+	if subscribed, err := p.isAlreadySubscribed(address); err != nil {
+		return fmt.Errorf("failed to check if address %q is subscribed: %w", address, err)
+	} else if !subscribed {
+		return fmt.Errorf("address %q: %w", address, ErrNotSubscribed)
+	}
+
+	contractABI, err := abi.Parse(data)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidABI, err)
+	}
+
+	if err := p.storage.SetABI(address, string(data)); err != nil {
+		return fmt.Errorf("failed to set ABI for address %q: %w", address, err)
+	}
+
+	p.mu.Lock()
+	p.abis[address] = contractABI
+	p.mu.Unlock()
+
+	return nil
+}
+
+// GetABI returns the contract ABI set for an address, or nil if it has none
+func (p *EthereumParser) GetABI(address string) ([]byte, error) {
+	address, err := addresspkg.Normalize(address)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := p.storage.GetABI(address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ABI for address %q: %w", address, err)
+	} else if data == "" {
+		return nil, nil
+	}
+
+	return []byte(data), nil
+}
+
+// loadABI loads the stored ABI of an address so its logs are decoded
+func (p *EthereumParser) loadABI(address string) error {
+	data, err := p.storage.GetABI(address)
+	if err != nil {
+		return fmt.Errorf("failed to get ABI: %w", err)
+	} else if data == "" {
+		return nil
+	}
+
+	contractABI, err := abi.Parse([]byte(data))
+	if err != nil {
+		return fmt.Errorf("failed to parse ABI: %w", err)
+	}
+
+	p.mu.Lock()
+	p.abis[address] = contractABI
+	p.mu.Unlock()
+
+	return nil
+}
+
+// removeABI forgets the ABI of an address
+func (p *EthereumParser) removeABI(address string) error {
+	p.mu.Lock()
+	delete(p.abis, address)
+	p.mu.Unlock()
+
+	if err := p.storage.RemoveABI(address); err != nil {
+		return fmt.Errorf("failed to remove ABI: %w", err)
+	}
+
+	return nil
+}
+
+// decodeEvent decodes a log of an address with its ABI, if it has one and the log was not already decoded
+func (p *EthereumParser) decodeEvent(address string, entry *Log) {
+	if entry.Decoded != nil {
+		return
+	}
+
+	p.mu.Lock()
+	contractABI, ok := p.abis[address]
+	p.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	decoded, err := contractABI.DecodeLog(entry.Topics, entry.Data)
+	if errors.Is(err, abi.ErrUnknownEvent) {
+		return
+	} else if err != nil {
+		log.Warn("failed to decode log with ABI", "address", address, "transactionHash", entry.TransactionHash, "logIndex", entry.LogIndex, "error", err)
+		return
+	}
+
+	entry.Decoded = decoded
+}
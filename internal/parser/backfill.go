@@ -0,0 +1,172 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/eth/quantity"
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/log"
+)
+
+// backfillChunkSize is the maximum number of blocks requested per eth_getLogs call while backfilling,
+// the ranges are halved whenever the node rejects them as too large
+const backfillChunkSize = 1000
+
+// ErrRangeTooLarge is returned by RPCCaller.GetLogs when the node refuses a block range spanning more
+// blocks or logs than it allows in a single call
+var ErrRangeTooLarge = errors.New("block range too large")
+
+// BackfillProgress describes the backfill of the logs an address emitted before it was watched live
+type BackfillProgress struct {
+	FromBlock uint64 `json:"fromBlock"`
+	// ToBlock is the chain head when the backfill started, the live stream takes over from there
+	ToBlock uint64 `json:"toBlock"`
+	// CurrentBlock is the last block backfilled
+	CurrentBlock uint64 `json:"currentBlock"`
+	Done         bool   `json:"done"`
+	// Error is why the backfill stopped before reaching ToBlock, if it did
+	Error string `json:"error,omitempty"`
+}
+
+// logKey uniquely identifies a log within the chain, the block hash tells apart
+// the same log included again in another branch after a reorganisation
+type logKey struct {
+	blockHash       string
+	transactionHash string
+	logIndex        string
+}
+
+// keyOf returns the de-duplication key of a log
+func keyOf(entry Log) logKey {
+	return logKey{blockHash: entry.BlockHash, transactionHash: entry.TransactionHash, logIndex: entry.LogIndex}
+}
+
+// backfill fetches the logs matching the filter of an address since its last processed block and stores the ones
+// that are missing. It returns the keys of the logs known within the backfilled range, so the live
+// stream can be de-duplicated against them, and the last block the backfill covered.
+// Addresses that were never processed before have no gap to fill and are skipped.
+func (p *EthereumParser) backfill(ctx context.Context, address string, filter LogFilter) (map[logKey]struct{}, uint64, error) {
+	lastBlock, err := p.storage.GetLastProcessedBlock(address)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get last processed block: %w", err)
+	} else if lastBlock == 0 {
+		return nil, 0, nil
+	}
+
+	currentBlock, err := p.GetCurrentBlock(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get current block: %w", err)
+	}
+	headBlock := uint64(currentBlock)
+
+	// The last processed block is included again as it might have been only partially stored
+	fromBlock := lastBlock
+	seen, err := p.storedLogKeys(address, fromBlock)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to load stored logs: %w", err)
+	}
+
+	progress := BackfillProgress{FromBlock: fromBlock, ToBlock: headBlock, CurrentBlock: lastBlock}
+	p.setBackfillProgress(address, progress)
+
+	// fail records why the backfill stopped in its progress
+	fail := func(err error) (map[logKey]struct{}, uint64, error) {
+		progress.Error = err.Error()
+		p.setBackfillProgress(address, progress)
+		return seen, lastBlock, err
+	}
+
+	err = GetLogsInChunks(ctx, p.rpcCaller, filter, fromBlock, headBlock, func(logs []Log, end uint64) error {
+		for _, entry := range logs {
+			key := keyOf(entry)
+			if _, ok := seen[key]; ok {
+				continue
+			}
+
+			p.decodeEvent(address, &entry)
+			if err := p.storage.AddLogFor(address, entry); err != nil {
+				return fmt.Errorf("failed to add log: %w", err)
+			}
+			p.notify(Event{Address: address, Log: &entry})
+			seen[key] = struct{}{}
+		}
+
+		if err := p.storage.SetLastProcessedBlock(address, end); err != nil {
+			return fmt.Errorf("failed to set last processed block: %w", err)
+		}
+		lastBlock = end
+
+		progress.CurrentBlock = end
+		p.setBackfillProgress(address, progress)
+		return nil
+	})
+	if err != nil {
+		return fail(err)
+	}
+
+	progress.Done = true
+	p.setBackfillProgress(address, progress)
+
+	return seen, lastBlock, nil
+}
+
+// GetLogsInChunks fetches the logs matching a filter from fromBlock to toBlock, in chunks of at most backfillChunkSize
+// blocks, and passes the logs of each chunk to handle along with its last block, in order. A chunk the node refuses
+// as too large is halved until it fits, and grows back after a success.
+func GetLogsInChunks(ctx context.Context, rpcCaller RPCCaller, filter LogFilter, fromBlock, toBlock uint64, handle func(logs []Log, toBlock uint64) error) error {
+	chunkSize := uint64(backfillChunkSize)
+	for start := fromBlock; start <= toBlock; {
+		end := min(start+chunkSize-1, toBlock)
+
+		logs, err := rpcCaller.GetLogs(ctx, filter, start, end)
+		if err != nil {
+			// Nodes limit the blocks or logs a single call may span, the range is split until it fits.
+			// Other failures, such as timeouts or outages, would not be solved by a smaller range.
+			if end > start && errors.Is(err, ErrRangeTooLarge) {
+				chunkSize = (end - start + 1) / 2
+				log.Warn("block range too large, splitting it", "addresses", filter.Addresses, "fromBlock", start, "toBlock", end, "error", err)
+				continue
+			}
+
+			return fmt.Errorf("failed to get logs for blocks %d-%d: %w", start, end, err)
+		}
+
+		if err := handle(logs, end); err != nil {
+			return err
+		}
+
+		// The range grows back after a success, the logs are rarely dense across the whole history
+		start = end + 1
+		chunkSize = min(2*chunkSize, backfillChunkSize)
+	}
+
+	return nil
+}
+
+// setBackfillProgress records the backfill progress of the watcher of an address, reported in its status
+func (p *EthereumParser) setBackfillProgress(address string, progress BackfillProgress) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if w, ok := p.watchers[address]; ok {
+		w.backfill = &progress
+	}
+}
+
+// storedLogKeys returns the keys of the stored logs of an address from the given block onwards
+func (p *EthereumParser) storedLogKeys(address string, fromBlock uint64) (map[logKey]struct{}, error) {
+	logs, err := p.storage.GetLogsFor(address)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[logKey]struct{})
+	for _, entry := range logs {
+		if blockNumber, err := quantity.ParseHex(entry.BlockNumber); err == nil && blockNumber >= fromBlock {
+			seen[keyOf(entry)] = struct{}{}
+		}
+	}
+
+	return seen, nil
+}
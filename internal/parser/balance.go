@@ -0,0 +1,75 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	addresspkg "github.com/HomayoonAlimohammadi/blockchain-parser/pkg/eth/address"
+)
+
+// ErrUnknownBlock is returned when reading balances at a block past the head of the chain
+var ErrUnknownBlock = errors.New("unknown block")
+
+// Balance is what an address holds at a block: its ETH balance in wei and the balances of the
+// requested ERC-20 tokens in their smallest unit, as decimal strings
+type Balance struct {
+	Address string         `json:"address"`
+	Block   uint64         `json:"block"`
+	Balance string         `json:"balance"`
+	Tokens  []TokenBalance `json:"tokens,omitempty"`
+}
+
+// TokenBalance is the balance of an ERC-20 token contract
+type TokenBalance struct {
+	Token   string `json:"token"`
+	Balance string `json:"balance"`
+}
+
+// GetBalance returns the ETH and ERC-20 token balances of an address at a block. Without a block, they are
+// read at the current one, so the balances are consistent with each other and the block is reported.
+// Reading old blocks requires an archive node.
+func (p *EthereumParser) GetBalance(ctx context.Context, address string, tokens []string, block *uint64) (*Balance, error) {
+	address, err := addresspkg.Normalize(address)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens = slices.Clone(tokens)
+	for i, token := range tokens {
+		if tokens[i], err = addresspkg.Normalize(token); err != nil {
+			return nil, err
+		}
+	}
+
+	currentBlock, err := p.GetCurrentBlock(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current block: %w", err)
+	}
+
+	at := uint64(currentBlock)
+	if block != nil {
+		if *block > at {
+			return nil, fmt.Errorf("block %d is past the current block %d: %w", *block, at, ErrUnknownBlock)
+		}
+		at = *block
+	}
+
+	balance, err := p.rpcCaller.GetBalance(ctx, address, at)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get balance of address %q: %w", address, err)
+	}
+
+	result := &Balance{Address: address, Block: at, Balance: balance.String()}
+	for _, token := range tokens {
+		tokenBalance, err := p.rpcCaller.GetTokenBalance(ctx, token, address, at)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get balance of address %q on token %q: %w", address, token, err)
+		}
+
+		result.Tokens = append(result.Tokens, TokenBalance{Token: token, Balance: tokenBalance.String()})
+	}
+
+	return result, nil
+}
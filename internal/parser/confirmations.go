@@ -0,0 +1,124 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/eth/quantity"
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/log"
+)
+
+// Finality tells how safe a block is from being reorganised away
+type Finality string
+
+const (
+	// FinalityLatest means the block is not yet considered safe
+	FinalityLatest Finality = "latest"
+	// FinalitySafe means the block is at or below the node's safe block
+	FinalitySafe Finality = "safe"
+	// FinalityFinalized means the block is at or below the node's finalized block and can no longer be reorganised
+	FinalityFinalized Finality = "finalized"
+)
+
+// chainStatus holds the heights the stored records are compared against to tell how safe they are
+type chainStatus struct {
+	head      uint64
+	safe      uint64
+	finalized uint64
+}
+
+// chainStatusCache holds the chain status fetched last, reused until the head moves, along with the tags
+// the node failed to report so a node without them is warned about once rather than on every query
+type chainStatusCache struct {
+	mu     sync.Mutex
+	status chainStatus
+	// missingTags holds the tags that failed since they were last reported
+	missingTags map[Finality]bool
+}
+
+// get returns the cached status if it is at the given head
+func (c *chainStatusCache) get(head uint64) (chainStatus, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.status, head != 0 && c.status.head == head
+}
+
+// set caches a status unless a more recent one is
+func (c *chainStatusCache) set(status chainStatus) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if status.head >= c.status.head {
+		c.status = status
+	}
+}
+
+// recordTag records the outcome of getting a tagged block, warning when the tag starts failing
+func (c *chainStatusCache) recordTag(tag Finality, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err == nil {
+		delete(c.missingTags, tag)
+		return
+	}
+
+	if c.missingTags == nil {
+		c.missingTags = make(map[Finality]bool)
+	}
+	if !c.missingTags[tag] {
+		c.missingTags[tag] = true
+		log.Warn("failed to get tagged block, reporting confirmations only", "tag", tag, "error", err)
+	}
+}
+
+// chainStatus returns the current, safe and finalized block numbers. The safe and finalized ones are
+// fetched once per head. Nodes without the safe and finalized tags only report confirmations.
+func (p *EthereumParser) chainStatus(ctx context.Context) (chainStatus, error) {
+	head, err := p.GetCurrentBlock(ctx)
+	if err != nil {
+		return chainStatus{}, fmt.Errorf("failed to get current block: %w", err)
+	}
+
+	if status, ok := p.chainStatuses.get(uint64(head)); ok {
+		return status, nil
+	}
+
+	status := chainStatus{head: uint64(head)}
+	for tag, number := range map[Finality]*uint64{
+		FinalitySafe:      &status.safe,
+		FinalityFinalized: &status.finalized,
+	} {
+		header, err := p.rpcCaller.GetHeaderByTag(ctx, string(tag))
+		if err == nil {
+			if *number, err = quantity.ParseHex(header.Number); err != nil {
+				err = fmt.Errorf("failed to parse block number: %w", err)
+			}
+		}
+
+		p.chainStatuses.recordTag(tag, err)
+	}
+
+	p.chainStatuses.set(status)
+	return status, nil
+}
+
+// confirm returns the number of confirmations and the finality of a block,
+// a block at the head of the chain has one confirmation
+func (s chainStatus) confirm(blockNumber string) (uint64, Finality) {
+	number, err := quantity.ParseHex(blockNumber)
+	if err != nil || number > s.head {
+		return 0, FinalityLatest
+	}
+
+	finality := FinalityLatest
+	if number <= s.finalized {
+		finality = FinalityFinalized
+	} else if number <= s.safe {
+		finality = FinalitySafe
+	}
+
+	return s.head - number + 1, finality
+}
@@ -0,0 +1,35 @@
+package parser
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/erc20"
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/log"
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/nft"
+)
+
+// decodeLog sets the decoded event of a log emitting a known event
+func decodeLog(entry *Log) {
+	if len(entry.Topics) == 0 {
+		return
+	}
+
+	var err error
+	switch strings.ToLower(entry.Topics[0]) {
+	case erc20.TransferTopic:
+		// ERC-721 transfers share the signature, with their token ID indexed
+		if entry.Transfer, err = erc20.DecodeTransfer(entry.Topics, entry.Data); errors.Is(err, erc20.ErrNotERC20) {
+			entry.NFTTransfer, err = nft.DecodeTransfer(entry.Topics, entry.Data)
+		}
+	case erc20.ApprovalTopic:
+		entry.Approval, err = erc20.DecodeApproval(entry.Topics, entry.Data)
+	case nft.TransferSingleTopic, nft.TransferBatchTopic:
+		entry.NFTTransfer, err = nft.DecodeTransfer(entry.Topics, entry.Data)
+	}
+
+	// Other standards share the event signatures, only malformed ERC-20 and NFT events are worth a warning
+	if err != nil && !errors.Is(err, erc20.ErrNotERC20) && !errors.Is(err, nft.ErrNotNFT) {
+		log.Warn("failed to decode log", "transactionHash", entry.TransactionHash, "logIndex", entry.LogIndex, "error", err)
+	}
+}
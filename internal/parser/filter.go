@@ -0,0 +1,81 @@
+package parser
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	addresspkg "github.com/HomayoonAlimohammadi/blockchain-parser/pkg/eth/address"
+)
+
+// LogFilter selects the logs of a subscription, the way eth_subscribe and eth_getLogs filter them
+type LogFilter struct {
+	// Addresses are the contracts emitting the logs, any of them
+	Addresses []string `json:"addresses"`
+	// Topics holds the topics allowed at each position, a log matches when its topic at every position
+	// is one of them. An empty position matches any topic, as do the positions past the last one.
+	Topics [][]string `json:"topics,omitempty"`
+}
+
+// filterOf returns the filter of the logs observed for a subscribed address, the logs of the address itself
+// and of the additional addresses of the options, matching their topic filters if any
+func filterOf(address string, opts SubscribeOptions) LogFilter {
+	filter := LogFilter{Addresses: []string{address}, Topics: opts.Topics}
+	for _, other := range opts.Addresses {
+		// The options are validated, so the addresses parse
+		other, _ = addresspkg.Normalize(other)
+		if !slices.Contains(filter.Addresses, other) {
+			filter.Addresses = append(filter.Addresses, other)
+		}
+	}
+
+	return filter
+}
+
+// IsDefault checks whether the filter selects every log of the given address and no other
+func (f LogFilter) IsDefault(address string) bool {
+	if len(f.Addresses) != 1 || f.Addresses[0] != address {
+		return false
+	}
+
+	for _, topics := range f.Topics {
+		if len(topics) > 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validateFilter checks the additional addresses and the topic filters of subscribe options
+func validateFilter(addresses []string, topics [][]string) error {
+	for _, address := range addresses {
+		if _, err := addresspkg.Parse(address); err != nil {
+			return err
+		}
+	}
+
+	if len(topics) > maxTopics {
+		return fmt.Errorf("invalid topics, expected at most %d positions", maxTopics)
+	}
+
+	for _, allowed := range topics {
+		for _, topic := range allowed {
+			if !isHex(topic, 32) {
+				return fmt.Errorf("invalid topic %q, expected 32 bytes of 0x prefixed hex", topic)
+			}
+		}
+	}
+
+	return nil
+}
+
+// isHex checks whether a string is 0x prefixed hex of the given number of bytes
+func isHex(s string, size int) bool {
+	digits, ok := strings.CutPrefix(s, "0x")
+	if !ok || len(digits) != 2*size {
+		return false
+	}
+
+	return strings.Trim(strings.ToLower(digits), "0123456789abcdef") == ""
+}
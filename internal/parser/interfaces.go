@@ -1,15 +1,43 @@
 package parser
 
-import "context"
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/nft"
+)
 
 // Parser interface for blockchain parsing
 type Parser interface {
 	// GetCurrentBlock returns the current block number
 	GetCurrentBlock(context.Context) (int, error)
 	// Subscribe adds an address to the observer
-	Subscribe(ctx context.Context, address string) error
-	// GetTransactions returns the list of inbound or outbound transactions for an address
-	GetTransactions(address string) ([]Transaction, error)
+	Subscribe(ctx context.Context, address string, opts SubscribeOptions) error
+	// Unsubscribe removes an address from the observer, purging its transactions if requested
+	Unsubscribe(ctx context.Context, address string, purge bool) error
+	// GetTransactions returns a page of the inbound or outbound transactions for an address
+	// selected by the query, and the cursor of the next page
+	GetTransactions(ctx context.Context, address string, query Query) ([]Transaction, string, error)
+	// GetLogs returns a page of the event logs emitted by an address selected by the query,
+	// and the cursor of the next page
+	GetLogs(ctx context.Context, address string, query Query) ([]Log, string, error)
+	// GetSubscriptions returns the status of every subscribed address
+	GetSubscriptions() ([]SubscriptionStatus, error)
+	// GetDeadLetters returns the callback deliveries given up on
+	GetDeadLetters() ([]DeadLetter, error)
+	// SetABI sets the contract ABI the logs of a subscribed address are decoded with
+	SetABI(address string, data []byte) error
+	// GetABI returns the contract ABI set for an address, or nil if it has none
+	GetABI(address string) ([]byte, error)
+	// GetNFTHoldings returns the ERC-721 and ERC-1155 tokens held by an owner, derived from the stored transfers
+	GetNFTHoldings(owner string) ([]nft.Holding, error)
+	// GetBalance returns the ETH and ERC-20 token balances of an address at a block, the current one if nil
+	GetBalance(ctx context.Context, address string, tokens []string, block *uint64) (*Balance, error)
+	// GetProviderHealth returns the health of every JSON-RPC provider, in the order calls are routed to them
+	GetProviderHealth() []ProviderHealth
+	// Stream returns the live events of an address, resuming after lastEventID, and a function to stop listening
+	Stream(address string, lastEventID uint64) (<-chan StreamEvent, func(), error)
 }
 
 // Storage interface for storing transactions
@@ -20,16 +48,91 @@ type Storage interface {
 	GetActiveAddresses() (map[string]struct{}, error)
 	// RemoveActiveAddress removes an address from the set of observed addresses
 	RemoveActiveAddress(address string) error
+	// GetSubscribedAt returns when an address was added to the set of observed addresses
+	GetSubscribedAt(address string) (time.Time, error)
 	// GetTransactionsFor returns the transactions for a given address
 	GetTransactionsFor(address string) ([]Transaction, error)
+	// QueryTransactionsFor returns the transactions for a given address selected by the query, in its order.
+	// The query limit and order are set, its topics are ignored.
+	QueryTransactionsFor(address string, query Query) ([]Transaction, error)
 	// AddTransactionFor adds a transaction for a given address
 	AddTransactionFor(address string, txn Transaction) error
+	// RemoveTransactionsFor removes all the transactions of a given address
+	RemoveTransactionsFor(address string) error
+	// CountTransactionsFor returns the number of transactions stored for a given address
+	CountTransactionsFor(address string) (int, error)
+	// GetLatestTransactionFor returns the transaction of a given address at the highest block and position,
+	// or nil if there is none
+	GetLatestTransactionFor(address string) (*Transaction, error)
+	// GetLogsFor returns the logs for a given address
+	GetLogsFor(address string) ([]Log, error)
+	// QueryLogsFor returns the logs for a given address selected by the query, in its order.
+	// The query limit and order are set.
+	QueryLogsFor(address string, query Query) ([]Log, error)
+	// AddLogFor adds a log for a given address
+	AddLogFor(address string, entry Log) error
+	// RemoveLogsFor removes all the logs of a given address
+	RemoveLogsFor(address string) error
+	// CountLogsFor returns the number of logs stored for a given address
+	CountLogsFor(address string) (int, error)
+	// GetLatestLogFor returns the log of a given address at the highest block and position, or nil if there is none
+	GetLatestLogFor(address string) (*Log, error)
+	// SetCallback sets where the new records of a given address are delivered
+	SetCallback(address string, callback Callback) error
+	// GetCallback returns the callback of a given address, or nil if it has none
+	GetCallback(address string) (*Callback, error)
+	// RemoveCallback removes the callback of a given address
+	RemoveCallback(address string) error
+	// SetABI sets the contract ABI JSON of a given address
+	SetABI(address string, abi string) error
+	// GetABI returns the contract ABI JSON of a given address, or an empty string if it has none
+	GetABI(address string) (string, error)
+	// RemoveABI removes the contract ABI of a given address
+	RemoveABI(address string) error
+	// SetFilter sets the log filter of a given address
+	SetFilter(address string, filter LogFilter) error
+	// GetFilter returns the log filter of a given address, or nil if it has none
+	GetFilter(address string) (*LogFilter, error)
+	// RemoveFilter removes the log filter of a given address
+	RemoveFilter(address string) error
+	// RemoveTransactionsInBlock removes the transactions of every address included in the given block
+	RemoveTransactionsInBlock(blockHash string) error
+	// RemoveLogsInBlock removes the logs of every address emitted in the given block
+	RemoveLogsInBlock(blockHash string) error
+	// GetLastProcessedBlock returns the last block processed for a given address, or 0 if none was
+	GetLastProcessedBlock(address string) (uint64, error)
+	// SetLastProcessedBlock records the last block processed for a given address
+	SetLastProcessedBlock(address string, block uint64) error
 }
 
 // RPCCaller calls methods of eth JSON RPC
 type RPCCaller interface {
-	// Subscribe calls the eth_subscribe method
-	Subscribe(ctx context.Context, address string) (<-chan Transaction, error)
+	// Subscribe calls the eth_subscribe method for the logs matching the filter of a subscribed address
+	Subscribe(ctx context.Context, address string, filter LogFilter) (<-chan Log, error)
+	// Unsubscribe calls the eth_unsubscribe method
+	Unsubscribe(ctx context.Context, address string) error
+	// ConnectionState returns the state of the subscription stream of an address
+	ConnectionState(address string) ConnectionState
+	// ProviderHealth returns the health of every provider, in the order calls are routed to them
+	ProviderHealth() []ProviderHealth
 	// BlockNumber calls the eth_blockNumber method
-	BlockNumber(ctx context.Context) (string, error)
+	BlockNumber(ctx context.Context) (uint64, error)
+	// GetLogs calls the eth_getLogs method for the logs matching a filter within an inclusive block range,
+	// it returns ErrRangeTooLarge if the node refuses the range as too large
+	GetLogs(ctx context.Context, filter LogFilter, fromBlock, toBlock uint64) ([]Log, error)
+	// SubscribeNewHeads calls the eth_subscribe method for the headers of new blocks
+	SubscribeNewHeads(ctx context.Context) (<-chan Header, error)
+	// GetBlockByNumber calls the eth_getBlockByNumber method, including the full transactions
+	GetBlockByNumber(ctx context.Context, number uint64) (*Block, error)
+	// GetBlocksByNumber calls the eth_getBlockByNumber method for several blocks in batches, including the full
+	// transactions. The blocks that failed to be fetched are nil, with their error at the same index.
+	GetBlocksByNumber(ctx context.Context, numbers []uint64) ([]*Block, []error, error)
+	// GetHeaderByTag calls the eth_getBlockByNumber method for a block tag such as safe or finalized
+	GetHeaderByTag(ctx context.Context, tag string) (*Header, error)
+	// GetTransactionReceipts calls the eth_getTransactionReceipt method for several transactions in batches
+	GetTransactionReceipts(ctx context.Context, hashes []string) ([]*Receipt, error)
+	// GetBalance calls the eth_getBalance method for the wei balance of an address at a block
+	GetBalance(ctx context.Context, address string, block uint64) (*big.Int, error)
+	// GetTokenBalance calls the eth_call method for the ERC-20 balanceOf of an owner on a token contract at a block
+	GetTokenBalance(ctx context.Context, token, owner string, block uint64) (*big.Int, error)
 }
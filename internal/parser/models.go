@@ -0,0 +1,84 @@
+package parser
+
+import (
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/abi"
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/erc20"
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/nft"
+)
+
+// Transaction structure of a transaction sent from or to a subscribed address.
+// The fields are the ones returned by eth_getBlockByNumber, Status and GasUsed
+// are taken from the receipt of the transaction once it is recorded.
+type Transaction struct {
+	Hash             string `json:"hash"`
+	BlockHash        string `json:"blockHash"`
+	BlockNumber      string `json:"blockNumber"`
+	TransactionIndex string `json:"transactionIndex"`
+	From             string `json:"from"`
+	To               string `json:"to"`
+	Value            string `json:"value"`
+	Gas              string `json:"gas"`
+	GasPrice         string `json:"gasPrice"`
+	Nonce            string `json:"nonce"`
+	Input            string `json:"input"`
+	Status           string `json:"status,omitempty"`
+	GasUsed          string `json:"gasUsed,omitempty"`
+	// Confirmations and Finality are not stored, they are set relative to the chain head when read
+	Confirmations uint64   `json:"confirmations"`
+	Finality      Finality `json:"finality,omitempty"`
+}
+
+// Log structure of an event log emitted by a subscribed contract
+type Log struct {
+	Address          string   `json:"address"`
+	BlockHash        string   `json:"blockHash"`
+	BlockNumber      string   `json:"blockNumber"`
+	Data             string   `json:"data"`
+	LogIndex         string   `json:"logIndex"`
+	Topics           []string `json:"topics"`
+	TransactionHash  string   `json:"transactionHash"`
+	TransactionIndex string   `json:"transactionIndex"`
+	// Removed is set by the node when a log it delivered earlier was orphaned by a chain reorganisation
+	Removed bool `json:"removed,omitempty"`
+	// Confirmations and Finality are not stored, they are set relative to the chain head when read
+	Confirmations uint64   `json:"confirmations"`
+	Finality      Finality `json:"finality,omitempty"`
+	// Transfer, Approval and NFTTransfer are not stored either, they are decoded from the topics
+	// and data of the ERC-20, ERC-721 and ERC-1155 events when read
+	Transfer    *erc20.Transfer `json:"transfer,omitempty"`
+	Approval    *erc20.Approval `json:"approval,omitempty"`
+	NFTTransfer *nft.Transfer   `json:"nftTransfer,omitempty"`
+	// Decoded is the log decoded with the ABI set for the subscribed address, stored along with the raw log
+	Decoded *abi.DecodedEvent `json:"decoded,omitempty"`
+}
+
+// Receipt structure as returned by eth_getTransactionReceipt
+type Receipt struct {
+	TransactionHash   string `json:"transactionHash"`
+	BlockHash         string `json:"blockHash"`
+	BlockNumber       string `json:"blockNumber"`
+	TransactionIndex  string `json:"transactionIndex"`
+	From              string `json:"from"`
+	To                string `json:"to"`
+	ContractAddress   string `json:"contractAddress"`
+	GasUsed           string `json:"gasUsed"`
+	EffectiveGasPrice string `json:"effectiveGasPrice"`
+	// Status is 0x1 for successful transactions and 0x0 for reverted ones
+	Status string `json:"status"`
+	Logs   []Log  `json:"logs"`
+}
+
+// Header structure of a block as pushed by newHeads subscriptions
+type Header struct {
+	Number     string `json:"number"`
+	Hash       string `json:"hash"`
+	ParentHash string `json:"parentHash"`
+}
+
+// Block structure as returned by eth_getBlockByNumber with full transactions
+type Block struct {
+	Number       string        `json:"number"`
+	Hash         string        `json:"hash"`
+	ParentHash   string        `json:"parentHash"`
+	Transactions []Transaction `json:"transactions"`
+}
@@ -0,0 +1,68 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	addresspkg "github.com/HomayoonAlimohammadi/blockchain-parser/pkg/eth/address"
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/log"
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/nft"
+)
+
+// GetNFTHoldings returns the ERC-721 and ERC-1155 tokens held by an owner, derived from the
+// transfers emitted by the subscribed contracts stored so far
+func (p *EthereumParser) GetNFTHoldings(owner string) ([]nft.Holding, error) {
+	owner, err := addresspkg.Normalize(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	activeAddrs, err := p.storage.GetActiveAddresses()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get active addresses: %w", err)
+	}
+
+	// Only the transfer logs are read, the ERC-721 Transfer topic being shared with ERC-20 transfers,
+	// which are told apart when decoded. A log observed by several subscriptions is stored under each
+	// of them, so it is only applied once.
+	query := Query{Topics: [][]string{{nft.TransferTopic, nft.TransferSingleTopic, nft.TransferBatchTopic}}}
+	var logs []Log
+	seen := make(map[logKey]struct{})
+	for address := range activeAddrs {
+		addressLogs, err := p.storage.QueryLogsFor(address, query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get logs for address %q: %w", address, err)
+		}
+
+		for _, entry := range addressLogs {
+			if _, ok := seen[keyOf(entry)]; ok {
+				continue
+			}
+
+			seen[keyOf(entry)] = struct{}{}
+			logs = append(logs, entry)
+		}
+	}
+
+	// The logs are stored as they arrive, backfilled ones after live ones, so they are replayed in chain order
+	slices.SortStableFunc(logs, func(a, b Log) int {
+		return a.Position().Compare(b.Position())
+	})
+
+	ledger := nft.NewLedger()
+	for _, entry := range logs {
+		transfer, err := nft.DecodeTransfer(entry.Topics, entry.Data)
+		if errors.Is(err, nft.ErrNotNFT) {
+			continue
+		} else if err != nil {
+			log.Warn("failed to decode NFT transfer", "address", entry.Address, "transactionHash", entry.TransactionHash, "logIndex", entry.LogIndex, "error", err)
+			continue
+		}
+
+		ledger.Apply(strings.ToLower(entry.Address), *transfer)
+	}
+
+	return ledger.HoldingsOf(owner), nil
+}
@@ -2,28 +2,68 @@ package parser
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"strconv"
+	"sync"
+	"time"
 
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/abi"
+	addresspkg "github.com/HomayoonAlimohammadi/blockchain-parser/pkg/eth/address"
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/eth/quantity"
 	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/log"
 )
 
-// Transaction structure
-type Transaction struct {
-	Address          string   `json:"address"`
-	BlockHash        string   `json:"blockHash"`
-	BlockNumber      string   `json:"blockNumber"`
-	Data             string   `json:"data"`
-	LogIndex         string   `json:"logIndex"`
-	Topics           []string `json:"topics"`
-	TransactionHash  string   `json:"transactionHash"`
-	TransactionIndex string   `json:"transactionIndex"`
-}
+// minResumeDelay and maxResumeDelay bound the backoff between the attempts to resume a subscription
+const (
+	minResumeDelay = time.Second
+	maxResumeDelay = time.Minute
+)
+
+// ErrNotSubscribed is returned when unsubscribing an address that is not subscribed
+var ErrNotSubscribed = errors.New("address not subscribed")
+
+// ErrFutureBlock is returned when subscribing from a block past the current one
+var ErrFutureBlock = errors.New("block not produced yet")
 
 // EthereumParser implements the Parser interface
 type EthereumParser struct {
 	rpcCaller RPCCaller
 	storage   Storage
+	notifier  Notifier
+	hub       *streamHub
+
+	mu       sync.Mutex
+	watchers map[string]*watcher
+	// abis holds the parsed ABIs of the addresses having one
+	abis map[string]*abi.ABI
+	// callbacks holds the callbacks of the addresses having one
+	callbacks map[string]Callback
+
+	minResumeDelay time.Duration
+	maxResumeDelay time.Duration
+	// chainStatuses caches the chain status the queried records are confirmed against
+	chainStatuses chainStatusCache
+}
+
+// watcher is the goroutine watching the transactions of a subscribed address
+type watcher struct {
+	cancel context.CancelFunc
+	// done is closed once the watcher exited
+	done chan struct{}
+	// backfill is the progress of the backfill run before watching live, nil until it starts
+	backfill *BackfillProgress
+}
+
+// stop cancels the watcher and waits for it to exit
+func (w *watcher) stop(ctx context.Context) error {
+	w.cancel()
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // NewEthereumParser creates a new parser
@@ -31,80 +71,475 @@ func NewEthereumParser(rpcCaller RPCCaller, storage Storage) *EthereumParser {
 	return &EthereumParser{
 		rpcCaller: rpcCaller,
 		storage:   storage,
+		hub:       newStreamHub(),
+		watchers:  make(map[string]*watcher),
+		abis:      make(map[string]*abi.ABI),
+		callbacks: make(map[string]Callback),
+
+		minResumeDelay: minResumeDelay,
+		maxResumeDelay: maxResumeDelay,
 	}
 }
 
 // GetCurrentBlock returns the current block number
 func (p *EthereumParser) GetCurrentBlock(ctx context.Context) (int, error) {
-	blockHex, err := p.rpcCaller.BlockNumber(ctx)
+	blockNumber, err := p.rpcCaller.BlockNumber(ctx)
 	if err != nil {
 		return 0, fmt.Errorf("failed to call eth_blockNumber: %w", err)
 	}
 
-	result, err := strconv.ParseInt(blockHex[2:], 16, 64)
+	return int(blockNumber), nil
+}
+
+// Subscribe adds an address to the subscribed list, its new records are delivered to the callback set in opts if any
+func (p *EthereumParser) Subscribe(ctx context.Context, address string, opts SubscribeOptions) error {
+	address, err := addresspkg.Normalize(address)
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse block hex: %w", err)
+		return err
 	}
 
-	return int(result), nil
-}
+	if err := opts.Validate(); err != nil {
+		return fmt.Errorf("invalid subscribe options: %w", err)
+	}
 
-// Subscribe adds an address to the subscribed list
-func (p *EthereumParser) Subscribe(ctx context.Context, address string) error {
 	if alreadySubscribed, err := p.isAlreadySubscribed(address); err != nil {
 		return fmt.Errorf("failed to check if address %q is already subscribed: %w", address, err)
 	} else if alreadySubscribed {
 		return fmt.Errorf("address %q already subscribed", address)
 	}
 
-	resChan, err := p.rpcCaller.Subscribe(ctx, address)
+	// The watcher backfills the logs from the last processed block, so moving it back backfills the history.
+	// An address never processed starts from the current block, so the logs it emits while the parser is
+	// down are backfilled on restart even if none was observed before.
+	previousBlock, err := p.storage.GetLastProcessedBlock(address)
 	if err != nil {
-		return fmt.Errorf("failed to subscribe to address %q: %w", address, err)
+		return fmt.Errorf("failed to get last processed block for address %q: %w", address, err)
 	}
 
-	if err := p.storage.AddActiveAddress(address); err != nil {
-		return fmt.Errorf("failed to add active address %q: %w", address, err)
+	var fromBlock uint64
+	if opts.FromBlock != nil || previousBlock == 0 {
+		currentBlock, err := p.GetCurrentBlock(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get current block: %w", err)
+		}
+		fromBlock = uint64(currentBlock)
 	}
 
-	watchCtx := context.Background()
-	go p.watchForTransactions(watchCtx, resChan, address)
+	if opts.FromBlock != nil {
+		// A block past the head would have the live logs below it skipped as already backfilled
+		if *opts.FromBlock > fromBlock {
+			return fmt.Errorf("%w: block %d is past the current block %d", ErrFutureBlock, *opts.FromBlock, fromBlock)
+		}
+
+		// 0 means no block was processed, the genesis block has no logs anyway
+		fromBlock = max(*opts.FromBlock, 1)
+	}
+
+	// The callback is set first so no record stored once subscribed is missed
+	if opts.CallbackURL != "" {
+		if err := p.setCallback(address, Callback{URL: opts.CallbackURL, Secret: opts.CallbackSecret}); err != nil {
+			return fmt.Errorf("failed to set callback for address %q: %w", address, err)
+		}
+	}
+
+	// Only the filters narrowing or widening the default one are stored, so they apply again on resume
+	filter := filterOf(address, opts)
+	if !filter.IsDefault(address) {
+		if err := p.storage.SetFilter(address, filter); err != nil {
+			return fmt.Errorf("failed to set filter for address %q: %w", address, err)
+		}
+	}
+
+	if fromBlock != 0 {
+		if err := p.storage.SetLastProcessedBlock(address, fromBlock); err != nil {
+			return fmt.Errorf("failed to set last processed block for address %q: %w", address, err)
+		}
+	}
+
+	if err := p.subscribe(ctx, address, filter); err != nil {
+		if fromBlock != 0 {
+			if err := p.storage.SetLastProcessedBlock(address, previousBlock); err != nil {
+				log.Error(err, "failed to restore last processed block of failed subscription", "address", address)
+			}
+		}
+		if opts.CallbackURL != "" {
+			if err := p.removeCallback(address); err != nil {
+				log.Error(err, "failed to remove callback of failed subscription", "address", address)
+			}
+		}
+		if !filter.IsDefault(address) {
+			if err := p.storage.RemoveFilter(address); err != nil {
+				log.Error(err, "failed to remove filter of failed subscription", "address", address)
+			}
+		}
+		return err
+	}
 
 	return nil
 }
 
-// GetTransactions returns the transactions for a given address
-func (p *EthereumParser) GetTransactions(address string) ([]Transaction, error) {
-	txns, err := p.storage.GetTransactionsFor(address)
+// Resume re-subscribes to every address recorded as active in the storage, so the addresses observed
+// before a restart keep being watched. The subscriptions failing are retried in the background.
+func (p *EthereumParser) Resume(ctx context.Context) error {
+	activeAddrs, err := p.storage.GetActiveAddresses()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get transactions for address %q: %w", address, err)
+		return fmt.Errorf("failed to get active addresses: %w", err)
+	}
+
+	var errs []error
+	for address := range activeAddrs {
+		// The ABI and callback are loaded first so the logs backfilled when subscribing are decoded and delivered
+		if err := p.loadABI(address); err != nil {
+			log.Error(err, "failed to load ABI, logs are not decoded", "address", address)
+		}
+		if err := p.loadCallback(address); err != nil {
+			log.Error(err, "failed to load callback, records are not delivered", "address", address)
+		}
+
+		filter, err := p.storage.GetFilter(address)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to get filter for address %q: %w", address, err))
+			continue
+		} else if filter == nil {
+			filter = &LogFilter{Addresses: []string{address}}
+		}
+
+		if err := p.subscribe(ctx, address, *filter); err != nil {
+			errs = append(errs, fmt.Errorf("failed to resume subscription for address %q, retrying: %w", address, err))
+			p.resumeLater(address, *filter)
+			continue
+		}
+
+		log.Info("resumed subscription", "address", address)
 	}
 
-	return txns, nil
+	return errors.Join(errs...)
 }
 
-// watchForTransactions watches for transactions and adds them to the storage
-func (p *EthereumParser) watchForTransactions(ctx context.Context, resChan <-chan Transaction, address string) {
-	defer func() {
-		if err := p.storage.RemoveActiveAddress(address); err != nil {
-			log.Error(err, "failed to remove active address", "address", address)
+// Unsubscribe stops watching an address and removes it from the subscribed list,
+// its stored transactions and logs are removed as well if purge is set
+func (p *EthereumParser) Unsubscribe(ctx context.Context, address string, purge bool) error {
+	address, err := addresspkg.Normalize(address)
+	if err != nil {
+		return err
+	}
+
+	if subscribed, err := p.isAlreadySubscribed(address); err != nil {
+		return fmt.Errorf("failed to check if address %q is subscribed: %w", address, err)
+	} else if !subscribed {
+		return fmt.Errorf("address %q: %w", address, ErrNotSubscribed)
+	}
+
+	p.mu.Lock()
+	w, ok := p.watchers[address]
+	delete(p.watchers, address)
+	p.mu.Unlock()
+
+	// The watcher is stopped before the subscription so it does not mistake the closing of its
+	// channel for a dropped subscription, and before the records are purged so a backfill in
+	// progress does not write any back
+	if ok {
+		if err := w.stop(ctx); err != nil {
+			return fmt.Errorf("failed to stop watching address %q: %w", address, err)
 		}
+
+		if err := p.rpcCaller.Unsubscribe(ctx, address); err != nil {
+			log.Error(err, "failed to unsubscribe from node", "address", address)
+		}
+	}
+
+	if err := p.storage.RemoveActiveAddress(address); err != nil {
+		return fmt.Errorf("failed to remove active address %q: %w", address, err)
+	}
+
+	if err := p.removeCallback(address); err != nil {
+		return fmt.Errorf("failed to remove callback for address %q: %w", address, err)
+	}
+
+	if err := p.storage.RemoveFilter(address); err != nil {
+		return fmt.Errorf("failed to remove filter for address %q: %w", address, err)
+	}
+
+	if err := p.removeABI(address); err != nil {
+		return fmt.Errorf("failed to remove ABI for address %q: %w", address, err)
+	}
+
+	p.hub.close(address)
+
+	if !purge {
+		return nil
+	}
+
+	if err := p.storage.RemoveTransactionsFor(address); err != nil {
+		return fmt.Errorf("failed to remove transactions for address %q: %w", address, err)
+	}
+
+	if err := p.storage.RemoveLogsFor(address); err != nil {
+		return fmt.Errorf("failed to remove logs for address %q: %w", address, err)
+	}
+
+	// Without stored logs there is no gap to backfill on a later subscription
+	if err := p.storage.SetLastProcessedBlock(address, 0); err != nil {
+		return fmt.Errorf("failed to reset last processed block for address %q: %w", address, err)
+	}
+
+	return nil
+}
+
+// GetTransactions returns a page of the transactions of a given address, along with their confirmations
+// and finality, and the cursor of the next page
+func (p *EthereumParser) GetTransactions(ctx context.Context, address string, query Query) ([]Transaction, string, error) {
+	address, err := addresspkg.Normalize(address)
+	if err != nil {
+		return nil, "", err
+	}
+
+	status, query, ok, err := p.prepareQuery(ctx, query)
+	if err != nil {
+		return nil, "", err
+	} else if !ok {
+		return nil, "", nil
+	}
+
+	limit := query.Limit
+	query.Limit++
+	txns, err := p.storage.QueryTransactionsFor(address, query)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query transactions for address %q: %w", address, err)
+	}
+
+	txns, next := page(txns, limit)
+	for i := range txns {
+		txns[i].Confirmations, txns[i].Finality = status.confirm(txns[i].BlockNumber)
+	}
+
+	return txns, next, nil
+}
+
+// GetLogs returns a page of the logs emitted by a given address, along with their confirmations
+// and finality, and the cursor of the next page
+func (p *EthereumParser) GetLogs(ctx context.Context, address string, query Query) ([]Log, string, error) {
+	address, err := addresspkg.Normalize(address)
+	if err != nil {
+		return nil, "", err
+	}
+
+	status, query, ok, err := p.prepareQuery(ctx, query)
+	if err != nil {
+		return nil, "", err
+	} else if !ok {
+		return nil, "", nil
+	}
+
+	limit := query.Limit
+	query.Limit++
+	logs, err := p.storage.QueryLogsFor(address, query)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query logs for address %q: %w", address, err)
+	}
+
+	logs, next := page(logs, limit)
+	for i := range logs {
+		logs[i].Confirmations, logs[i].Finality = status.confirm(logs[i].BlockNumber)
+		decodeLog(&logs[i])
+		// The logs stored before the ABI was set are decoded when read
+		p.decodeEvent(address, &logs[i])
+	}
+
+	return logs, next, nil
+}
+
+// prepareQuery validates a query and fills in its defaults. The minimum number of confirmations is turned
+// into an upper block bound, so the storage only has to filter by block, ok is false if no block has enough.
+func (p *EthereumParser) prepareQuery(ctx context.Context, query Query) (status chainStatus, _ Query, ok bool, err error) {
+	if err := query.Validate(); err != nil {
+		return chainStatus{}, query, false, fmt.Errorf("invalid query: %w", err)
+	}
+
+	if query.Limit == 0 {
+		query.Limit = DefaultPageSize
+	}
+	if query.Order == "" {
+		query.Order = OrderAsc
+	}
+
+	if status, err = p.chainStatus(ctx); err != nil {
+		return chainStatus{}, query, false, fmt.Errorf("failed to get chain status: %w", err)
+	}
+
+	if query.MinConfirmations > 0 {
+		// A block has head - block + 1 confirmations
+		if query.MinConfirmations > status.head+1 {
+			return status, query, false, nil
+		}
+
+		toBlock := status.head + 1 - query.MinConfirmations
+		if query.ToBlock == nil || *query.ToBlock > toBlock {
+			query.ToBlock = &toBlock
+		}
+	}
+
+	return status, query, true, nil
+}
+
+// subscribe opens the subscription stream of the logs matching the filter of an address,
+// marks it as active and starts watching it
+func (p *EthereumParser) subscribe(ctx context.Context, address string, filter LogFilter) error {
+	resChan, err := p.rpcCaller.Subscribe(ctx, address, filter)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to address %q: %w", address, err)
+	}
+
+	if err := p.storage.AddActiveAddress(address); err != nil {
+		return fmt.Errorf("failed to add active address %q: %w", address, err)
+	}
+
+	// The request context is done once the subscription is made, the watcher lives until unsubscribed
+	watchCtx, cancel := context.WithCancel(context.Background())
+	w := &watcher{cancel: cancel, done: make(chan struct{})}
+
+	p.mu.Lock()
+	p.watchers[address] = w
+	p.mu.Unlock()
+
+	go func() {
+		defer close(w.done)
+		defer p.removeWatcher(address, w)
+		p.watchForLogs(watchCtx, resChan, address, filter)
 	}()
 
-	log.Info("watching for transactions...", "address", address)
+	return nil
+}
+
+// resumeLater retries to subscribe to an active address in the background, backing off exponentially between
+// attempts, then watches it. Its watcher is set right away, so unsubscribing the address stops the retries.
+func (p *EthereumParser) resumeLater(address string, filter LogFilter) {
+	watchCtx, cancel := context.WithCancel(context.Background())
+	w := &watcher{cancel: cancel, done: make(chan struct{})}
+
+	p.mu.Lock()
+	p.watchers[address] = w
+	p.mu.Unlock()
+
+	go func() {
+		defer close(w.done)
+		defer p.removeWatcher(address, w)
+
+		for attempt := 0; ; attempt++ {
+			select {
+			case <-watchCtx.Done():
+				return
+			case <-time.After(p.resumeDelay(attempt)):
+			}
+
+			resChan, err := p.rpcCaller.Subscribe(watchCtx, address, filter)
+			if err != nil {
+				log.Error(err, "failed to resume subscription, retrying", "address", address, "attempt", attempt+1)
+				continue
+			}
+
+			// Unsubscribing meanwhile cancels the subscription made once the watcher exited
+			if watchCtx.Err() != nil {
+				return
+			}
+
+			log.Info("resumed subscription", "address", address, "attempt", attempt+1)
+			p.watchForLogs(watchCtx, resChan, address, filter)
+			return
+		}
+	}()
+}
+
+// resumeDelay returns the delay before an attempt to resume a subscription, doubling from minResumeDelay up to maxResumeDelay
+func (p *EthereumParser) resumeDelay(attempt int) time.Duration {
+	if attempt >= 32 {
+		return p.maxResumeDelay
+	}
+	return min(p.minResumeDelay<<attempt, p.maxResumeDelay)
+}
+
+// removeWatcher forgets the watcher of an address unless it was already replaced
+func (p *EthereumParser) removeWatcher(address string, w *watcher) {
+	w.cancel()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.watchers[address] == w {
+		delete(p.watchers, address)
+	}
+}
+
+// watchForLogs watches for logs and adds them to the storage.
+// The address is no longer considered active if its channel gets closed, unless
+// that is because the watch was cancelled.
+func (p *EthereumParser) watchForLogs(ctx context.Context, resChan <-chan Log, address string, filter LogFilter) {
+	// Logs arriving on the live stream while backfilling are buffered in resChan,
+	// the ones already stored by the backfill are skipped using seen
+	seen, lastBlock, err := p.backfill(ctx, address, filter)
+	if err != nil {
+		log.Error(err, "failed to backfill missed logs", "address", address)
+	}
+	backfilledTo := lastBlock
+
+	log.Info("watching for logs...", "address", address)
 	for {
 		select {
 		case <-ctx.Done():
-			log.Error(ctx.Err(), "context done")
+			log.Info("stopped watching for logs", "address", address)
 			return
-		case txn, ok := <-resChan:
+		case entry, ok := <-resChan:
 			if !ok {
+				if ctx.Err() != nil {
+					log.Info("stopped watching for logs", "address", address)
+					return
+				}
+
 				log.Info("response channel close")
+				if err := p.storage.RemoveActiveAddress(address); err != nil {
+					log.Error(err, "failed to remove active address", "address", address)
+				}
 				return
 			}
 
-			log.Info("got transaction", "txn", txn)
-			if err := p.storage.AddTransactionFor(address, txn); err != nil {
-				log.Error(err, "failed to add transaction for address", "address", address)
+			// The logs replacing an orphaned one are delivered next as regular logs
+			if entry.Removed {
+				log.Warn("log removed by chain reorganisation", "log", entry)
+				if err := p.storage.RemoveLogsInBlock(entry.BlockHash); err != nil {
+					log.Error(err, "failed to remove logs of orphaned block", "blockHash", entry.BlockHash)
+				}
+				continue
+			}
+
+			blockNumber, err := quantity.ParseHex(entry.BlockNumber)
+			if err != nil {
+				log.Error(err, "failed to parse log block number", "log", entry)
+			}
+
+			if seen != nil {
+				if _, ok := seen[keyOf(entry)]; ok {
+					continue
+				}
+
+				// Past the backfilled range the live stream can no longer overlap with it
+				if blockNumber > backfilledTo {
+					seen = nil
+				}
+			}
+
+			log.Info("got log", "log", entry)
+			p.decodeEvent(address, &entry)
+			if err := p.storage.AddLogFor(address, entry); err != nil {
+				log.Error(err, "failed to add log for address", "address", address)
+				continue
+			}
+			p.notify(Event{Address: address, Log: &entry})
+
+			if blockNumber > lastBlock {
+				if err := p.storage.SetLastProcessedBlock(address, blockNumber); err != nil {
+					log.Error(err, "failed to set last processed block", "address", address)
+				}
+				lastBlock = blockNumber
 			}
 		}
 	}
@@ -3,10 +3,31 @@ package parser
 import (
 	"context"
 	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/erc20"
+	addresspkg "github.com/HomayoonAlimohammadi/blockchain-parser/pkg/eth/address"
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/nft"
+)
+
+// Test addresses, in their canonical lowercase form
+const (
+	testAddress    = "0xdac17f958d2ee523a2206206994597c13d831ec7"
+	testAddress1   = "0x1000000000000000000000000000000000000001"
+	testAddress2   = "0x2000000000000000000000000000000000000002"
+	testOther      = "0x3000000000000000000000000000000000000003"
+	testIdle       = "0x4000000000000000000000000000000000000004"
+	testWatched    = "0x5000000000000000000000000000000000000005"
+	testCollection = "0x6000000000000000000000000000000000000006"
 )
 
 // MockRPCCaller is a mock implementation of the RPCCaller interface
@@ -14,14 +35,81 @@ type MockRPCCaller struct {
 	mock.Mock
 }
 
-func (m *MockRPCCaller) BlockNumber(ctx context.Context) (string, error) {
+func (m *MockRPCCaller) BlockNumber(ctx context.Context) (uint64, error) {
 	args := m.Called(ctx)
-	return args.String(0), args.Error(1)
+	blockNumber, _ := args.Get(0).(uint64)
+	return blockNumber, args.Error(1)
 }
 
-func (m *MockRPCCaller) Subscribe(ctx context.Context, address string) (<-chan Transaction, error) {
+func (m *MockRPCCaller) Subscribe(ctx context.Context, address string, filter LogFilter) (<-chan Log, error) {
+	args := m.Called(ctx, address, filter)
+	resChan, _ := args.Get(0).(<-chan Log)
+	return resChan, args.Error(1)
+}
+
+func (m *MockRPCCaller) Unsubscribe(ctx context.Context, address string) error {
 	args := m.Called(ctx, address)
-	return args.Get(0).(<-chan Transaction), args.Error(1)
+	return args.Error(0)
+}
+
+func (m *MockRPCCaller) ConnectionState(address string) ConnectionState {
+	args := m.Called(address)
+	return args.Get(0).(ConnectionState)
+}
+
+func (m *MockRPCCaller) ProviderHealth() []ProviderHealth {
+	args := m.Called()
+	health, _ := args.Get(0).([]ProviderHealth)
+	return health
+}
+
+func (m *MockRPCCaller) GetLogs(ctx context.Context, filter LogFilter, fromBlock, toBlock uint64) ([]Log, error) {
+	args := m.Called(ctx, filter, fromBlock, toBlock)
+	logs, _ := args.Get(0).([]Log)
+	return logs, args.Error(1)
+}
+
+func (m *MockRPCCaller) SubscribeNewHeads(ctx context.Context) (<-chan Header, error) {
+	args := m.Called(ctx)
+	heads, _ := args.Get(0).(<-chan Header)
+	return heads, args.Error(1)
+}
+
+func (m *MockRPCCaller) GetBlockByNumber(ctx context.Context, number uint64) (*Block, error) {
+	args := m.Called(ctx, number)
+	block, _ := args.Get(0).(*Block)
+	return block, args.Error(1)
+}
+
+func (m *MockRPCCaller) GetBlocksByNumber(ctx context.Context, numbers []uint64) ([]*Block, []error, error) {
+	args := m.Called(ctx, numbers)
+	blocks, _ := args.Get(0).([]*Block)
+	errs, _ := args.Get(1).([]error)
+	return blocks, errs, args.Error(2)
+}
+
+func (m *MockRPCCaller) GetHeaderByTag(ctx context.Context, tag string) (*Header, error) {
+	args := m.Called(ctx, tag)
+	header, _ := args.Get(0).(*Header)
+	return header, args.Error(1)
+}
+
+func (m *MockRPCCaller) GetTransactionReceipts(ctx context.Context, hashes []string) ([]*Receipt, error) {
+	args := m.Called(ctx, hashes)
+	receipts, _ := args.Get(0).([]*Receipt)
+	return receipts, args.Error(1)
+}
+
+func (m *MockRPCCaller) GetBalance(ctx context.Context, address string, block uint64) (*big.Int, error) {
+	args := m.Called(ctx, address, block)
+	balance, _ := args.Get(0).(*big.Int)
+	return balance, args.Error(1)
+}
+
+func (m *MockRPCCaller) GetTokenBalance(ctx context.Context, token, owner string, block uint64) (*big.Int, error) {
+	args := m.Called(ctx, token, owner, block)
+	balance, _ := args.Get(0).(*big.Int)
+	return balance, args.Error(1)
 }
 
 // MockStorage is a mock implementation of the Storage interface
@@ -31,7 +119,14 @@ type MockStorage struct {
 
 func (m *MockStorage) GetTransactionsFor(address string) ([]Transaction, error) {
 	args := m.Called(address)
-	return args.Get(0).([]Transaction), args.Error(1)
+	txns, _ := args.Get(0).([]Transaction)
+	return txns, args.Error(1)
+}
+
+func (m *MockStorage) QueryTransactionsFor(address string, query Query) ([]Transaction, error) {
+	args := m.Called(address, query)
+	txns, _ := args.Get(0).([]Transaction)
+	return txns, args.Error(1)
 }
 
 func (m *MockStorage) AddTransactionFor(address string, txn Transaction) error {
@@ -39,6 +134,11 @@ func (m *MockStorage) AddTransactionFor(address string, txn Transaction) error {
 	return args.Error(0)
 }
 
+func (m *MockStorage) RemoveTransactionsFor(address string) error {
+	args := m.Called(address)
+	return args.Error(0)
+}
+
 func (m *MockStorage) AddActiveAddress(address string) error {
 	args := m.Called(address)
 	return args.Error(0)
@@ -49,18 +149,149 @@ func (m *MockStorage) GetActiveAddresses() (map[string]struct{}, error) {
 	return args.Get(0).(map[string]struct{}), args.Error(1)
 }
 
+func (m *MockStorage) GetSubscribedAt(address string) (time.Time, error) {
+	args := m.Called(address)
+	return args.Get(0).(time.Time), args.Error(1)
+}
+
+func (m *MockStorage) CountTransactionsFor(address string) (int, error) {
+	args := m.Called(address)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockStorage) GetLatestTransactionFor(address string) (*Transaction, error) {
+	args := m.Called(address)
+	txn, _ := args.Get(0).(*Transaction)
+	return txn, args.Error(1)
+}
+
+func (m *MockStorage) GetLogsFor(address string) ([]Log, error) {
+	args := m.Called(address)
+	logs, _ := args.Get(0).([]Log)
+	return logs, args.Error(1)
+}
+
+func (m *MockStorage) QueryLogsFor(address string, query Query) ([]Log, error) {
+	args := m.Called(address, query)
+	logs, _ := args.Get(0).([]Log)
+	return logs, args.Error(1)
+}
+
+func (m *MockStorage) AddLogFor(address string, entry Log) error {
+	args := m.Called(address, entry)
+	return args.Error(0)
+}
+
+func (m *MockStorage) RemoveLogsFor(address string) error {
+	args := m.Called(address)
+	return args.Error(0)
+}
+
+func (m *MockStorage) CountLogsFor(address string) (int, error) {
+	args := m.Called(address)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockStorage) GetLatestLogFor(address string) (*Log, error) {
+	args := m.Called(address)
+	entry, _ := args.Get(0).(*Log)
+	return entry, args.Error(1)
+}
+
+func (m *MockStorage) RemoveTransactionsInBlock(blockHash string) error {
+	args := m.Called(blockHash)
+	return args.Error(0)
+}
+
+func (m *MockStorage) RemoveLogsInBlock(blockHash string) error {
+	args := m.Called(blockHash)
+	return args.Error(0)
+}
+
+func (m *MockStorage) SetCallback(address string, callback Callback) error {
+	args := m.Called(address, callback)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetCallback(address string) (*Callback, error) {
+	args := m.Called(address)
+	callback, _ := args.Get(0).(*Callback)
+	return callback, args.Error(1)
+}
+
+func (m *MockStorage) RemoveCallback(address string) error {
+	args := m.Called(address)
+	return args.Error(0)
+}
+
+func (m *MockStorage) SetFilter(address string, filter LogFilter) error {
+	args := m.Called(address, filter)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetFilter(address string) (*LogFilter, error) {
+	args := m.Called(address)
+	filter, _ := args.Get(0).(*LogFilter)
+	return filter, args.Error(1)
+}
+
+func (m *MockStorage) RemoveFilter(address string) error {
+	args := m.Called(address)
+	return args.Error(0)
+}
+
+func (m *MockStorage) SetABI(address string, abi string) error {
+	args := m.Called(address, abi)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetABI(address string) (string, error) {
+	args := m.Called(address)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockStorage) RemoveABI(address string) error {
+	args := m.Called(address)
+	return args.Error(0)
+}
+
 func (m *MockStorage) RemoveActiveAddress(address string) error {
 	args := m.Called(address)
 	return args.Error(0)
 }
 
+func (m *MockStorage) GetLastProcessedBlock(address string) (uint64, error) {
+	args := m.Called(address)
+	return args.Get(0).(uint64), args.Error(1)
+}
+
+func (m *MockStorage) SetLastProcessedBlock(address string, block uint64) error {
+	args := m.Called(address, block)
+	return args.Error(0)
+}
+
+type MockNotifier struct {
+	mock.Mock
+}
+
+func (m *MockNotifier) Notify(callback Callback, event Event) error {
+	args := m.Called(callback, event)
+	return args.Error(0)
+}
+
+func (m *MockNotifier) DeadLetters() ([]DeadLetter, error) {
+	args := m.Called()
+	deadLetters, _ := args.Get(0).([]DeadLetter)
+	return deadLetters, args.Error(1)
+}
+
 func TestGetCurrentBlock(t *testing.T) {
 	ctx := context.Background()
 	mockRPCCaller := new(MockRPCCaller)
 	mockStorage := new(MockStorage)
 	parser := NewEthereumParser(mockRPCCaller, mockStorage)
 
-	mockRPCCaller.On("BlockNumber", ctx).Return("0x10", nil)
+	mockRPCCaller.On("BlockNumber", ctx).Return(uint64(16), nil)
 
 	blockNumber, err := parser.GetCurrentBlock(ctx)
 	assert.NoError(t, err)
@@ -75,7 +306,7 @@ func TestGetCurrentBlock_Error(t *testing.T) {
 	mockStorage := new(MockStorage)
 	parser := NewEthereumParser(mockRPCCaller, mockStorage)
 
-	mockRPCCaller.On("BlockNumber", ctx).Return("", errors.New("rpc error"))
+	mockRPCCaller.On("BlockNumber", ctx).Return(uint64(0), errors.New("rpc error"))
 
 	blockNumber, err := parser.GetCurrentBlock(ctx)
 	assert.Error(t, err)
@@ -90,13 +321,64 @@ func TestSubscribe(t *testing.T) {
 	mockStorage := new(MockStorage)
 	parser := NewEthereumParser(mockRPCCaller, mockStorage)
 
-	resChan := make(chan Transaction)
-	mockRPCCaller.On("Subscribe", ctx, "0xAddress").Return(resChan, nil)
+	// An address never processed starts from the current block, so the logs emitted while down are backfilled
+	resChan := make(chan Log)
+	mockStorage.On("GetActiveAddresses").Return(map[string]struct{}{}, nil)
+	mockStorage.On("AddActiveAddress", testAddress).Return(nil)
+	mockStorage.On("GetLastProcessedBlock", testAddress).Return(uint64(0), nil)
+	mockRPCCaller.On("BlockNumber", ctx).Return(uint64(16), nil)
+	mockStorage.On("SetLastProcessedBlock", testAddress, uint64(16)).Return(nil)
+	mockRPCCaller.On("Subscribe", ctx, testAddress, LogFilter{Addresses: []string{testAddress}}).Return((<-chan Log)(resChan), nil)
+
+	err := parser.Subscribe(ctx, testAddress, SubscribeOptions{})
+	assert.NoError(t, err)
+
+	mockRPCCaller.AssertExpectations(t)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestSubscribe_Address(t *testing.T) {
+	ctx := context.Background()
+	mockRPCCaller := new(MockRPCCaller)
+	mockStorage := new(MockStorage)
+	parser := NewEthereumParser(mockRPCCaller, mockStorage)
+
+	// The checksummed address is subscribed under its lowercase form
+	resChan := make(chan Log)
+	mockStorage.On("GetActiveAddresses").Return(map[string]struct{}{}, nil)
+	mockStorage.On("AddActiveAddress", testAddress).Return(nil)
+	mockStorage.On("GetLastProcessedBlock", testAddress).Return(uint64(0), nil)
+	mockRPCCaller.On("BlockNumber", ctx).Return(uint64(16), nil)
+	mockStorage.On("SetLastProcessedBlock", testAddress, uint64(16)).Return(nil)
+	mockRPCCaller.On("Subscribe", ctx, testAddress, LogFilter{Addresses: []string{testAddress}}).Return((<-chan Log)(resChan), nil)
 
-	err := parser.Subscribe(ctx, "0xAddress")
+	err := parser.Subscribe(ctx, "0xdAC17F958D2ee523a2206206994597C13D831ec7", SubscribeOptions{})
 	assert.NoError(t, err)
 
+	for _, invalid := range []string{"", "0xAddress", "0xdac17f958d2ee523a2206206994597c13d831e", "0xDAC17F958D2ee523a2206206994597C13D831ec7"} {
+		err := parser.Subscribe(ctx, invalid, SubscribeOptions{})
+		assert.ErrorIs(t, err, addresspkg.ErrInvalid, invalid)
+
+		_, _, err = parser.GetLogs(ctx, invalid, Query{})
+		assert.ErrorIs(t, err, addresspkg.ErrInvalid, invalid)
+	}
+
 	mockRPCCaller.AssertExpectations(t)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestSubscribe_AlreadySubscribed(t *testing.T) {
+	ctx := context.Background()
+	mockRPCCaller := new(MockRPCCaller)
+	mockStorage := new(MockStorage)
+	parser := NewEthereumParser(mockRPCCaller, mockStorage)
+
+	mockStorage.On("GetActiveAddresses").Return(map[string]struct{}{testAddress: {}}, nil)
+
+	err := parser.Subscribe(ctx, testAddress, SubscribeOptions{})
+	assert.Error(t, err)
+
+	mockRPCCaller.AssertNotCalled(t, "Subscribe", ctx, testAddress, mock.Anything)
 }
 
 func TestSubscribe_Error(t *testing.T) {
@@ -105,40 +387,955 @@ func TestSubscribe_Error(t *testing.T) {
 	mockStorage := new(MockStorage)
 	parser := NewEthereumParser(mockRPCCaller, mockStorage)
 
-	mockRPCCaller.On("Subscribe", ctx, "0xAddress").Return(nil, errors.New("subscribe error"))
+	// The last processed block recorded when subscribing is reverted if the subscription fails
+	mockStorage.On("GetActiveAddresses").Return(map[string]struct{}{}, nil)
+	mockStorage.On("GetLastProcessedBlock", testAddress).Return(uint64(0), nil)
+	mockRPCCaller.On("BlockNumber", ctx).Return(uint64(16), nil)
+	mockStorage.On("SetLastProcessedBlock", testAddress, uint64(16)).Return(nil).Once()
+	mockRPCCaller.On("Subscribe", ctx, testAddress, LogFilter{Addresses: []string{testAddress}}).Return(nil, errors.New("subscribe error"))
+	mockStorage.On("SetLastProcessedBlock", testAddress, uint64(0)).Return(nil).Once()
+
+	err := parser.Subscribe(ctx, testAddress, SubscribeOptions{})
+	assert.Error(t, err)
+
+	mockRPCCaller.AssertExpectations(t)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestSubscribe_Callback(t *testing.T) {
+	ctx := context.Background()
+	mockRPCCaller := new(MockRPCCaller)
+	mockStorage := new(MockStorage)
+	parser := NewEthereumParser(mockRPCCaller, mockStorage)
+
+	opts := SubscribeOptions{CallbackURL: "https://example.com/hook", CallbackSecret: "secret"}
+	mockStorage.On("GetActiveAddresses").Return(map[string]struct{}{}, nil)
+	mockStorage.On("GetLastProcessedBlock", testAddress).Return(uint64(42), nil)
+	mockStorage.On("SetCallback", testAddress, Callback{URL: opts.CallbackURL, Secret: opts.CallbackSecret}).Return(nil)
+	mockRPCCaller.On("Subscribe", ctx, testAddress, LogFilter{Addresses: []string{testAddress}}).Return(nil, errors.New("subscribe error"))
+	mockStorage.On("RemoveCallback", testAddress).Return(nil)
+
+	// The callback of a failed subscription is removed
+	err := parser.Subscribe(ctx, testAddress, opts)
+	assert.Error(t, err)
+	assert.Empty(t, parser.callbacks)
+
+	// An invalid callback is rejected before anything is stored
+	err = parser.Subscribe(ctx, testAddress, SubscribeOptions{CallbackURL: "https://example.com/hook"})
+	assert.ErrorContains(t, err, "missing callback secret")
+
+	mockRPCCaller.AssertExpectations(t)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestSubscribe_FromBlock(t *testing.T) {
+	ctx := context.Background()
+	mockRPCCaller := new(MockRPCCaller)
+	mockStorage := new(MockStorage)
+	parser := NewEthereumParser(mockRPCCaller, mockStorage)
+
+	// The backfill starts from the given block, which is reverted if the subscription fails
+	fromBlock := uint64(100)
+	mockStorage.On("GetActiveAddresses").Return(map[string]struct{}{}, nil)
+	mockStorage.On("GetLastProcessedBlock", testAddress).Return(uint64(42), nil)
+	mockRPCCaller.On("BlockNumber", ctx).Return(uint64(200), nil)
+	mockStorage.On("SetLastProcessedBlock", testAddress, uint64(100)).Return(nil).Once()
+	mockRPCCaller.On("Subscribe", ctx, testAddress, LogFilter{Addresses: []string{testAddress}}).Return(nil, errors.New("subscribe error"))
+	mockStorage.On("SetLastProcessedBlock", testAddress, uint64(42)).Return(nil).Once()
+
+	err := parser.Subscribe(ctx, testAddress, SubscribeOptions{FromBlock: &fromBlock})
+	assert.Error(t, err)
+
+	// A block past the current one is rejected before anything is stored
+	fromBlock = 201
+	err = parser.Subscribe(ctx, testAddress, SubscribeOptions{FromBlock: &fromBlock})
+	assert.ErrorIs(t, err, ErrFutureBlock)
+
+	mockRPCCaller.AssertExpectations(t)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestSubscribe_Filter(t *testing.T) {
+	ctx := context.Background()
+	mockRPCCaller := new(MockRPCCaller)
+	mockStorage := new(MockStorage)
+	parser := NewEthereumParser(mockRPCCaller, mockStorage)
+
+	const (
+		address = "0xdac17f958d2ee523a2206206994597c13d831ec7"
+		other   = "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"
+		holder  = "0x00000000000000000000000028c6c06298d514db089934071355e5743bf21d60"
+	)
+
+	// Transfers from or to the holder on either contract, the address being listed again is ignored
+	opts := SubscribeOptions{
+		Addresses: []string{other, "0x" + strings.ToUpper(address[2:])},
+		Topics:    [][]string{{erc20.TransferTopic}, nil, {holder}},
+	}
+	filter := LogFilter{Addresses: []string{address, other}, Topics: opts.Topics}
+
+	resChan := make(chan Log)
+	mockStorage.On("GetActiveAddresses").Return(map[string]struct{}{}, nil)
+	mockStorage.On("SetFilter", address, filter).Return(nil)
+	mockStorage.On("AddActiveAddress", address).Return(nil)
+	mockStorage.On("GetLastProcessedBlock", address).Return(uint64(0), nil)
+	mockRPCCaller.On("BlockNumber", ctx).Return(uint64(16), nil)
+	mockStorage.On("SetLastProcessedBlock", address, uint64(16)).Return(nil)
+	mockRPCCaller.On("Subscribe", ctx, address, filter).Return((<-chan Log)(resChan), nil)
+
+	err := parser.Subscribe(ctx, address, opts)
+	assert.NoError(t, err)
+
+	// A failed subscription does not keep its filter
+	mockStorage.On("GetLastProcessedBlock", other).Return(uint64(42), nil)
+	mockRPCCaller.On("Subscribe", ctx, other, mock.Anything).Return(nil, errors.New("subscribe error"))
+	mockStorage.On("SetFilter", other, mock.Anything).Return(nil)
+	mockStorage.On("RemoveFilter", other).Return(nil)
+
+	err = parser.Subscribe(ctx, other, SubscribeOptions{Topics: [][]string{{erc20.ApprovalTopic}}})
+	assert.Error(t, err)
+
+	// Malformed filters are rejected before anything is stored
+	err = parser.Subscribe(ctx, address, SubscribeOptions{Topics: [][]string{{"0xddf252ad"}}})
+	assert.ErrorContains(t, err, "invalid topic")
+
+	err = parser.Subscribe(ctx, address, SubscribeOptions{Topics: make([][]string, 5)})
+	assert.ErrorContains(t, err, "at most 4 positions")
+
+	err = parser.Subscribe(ctx, address, SubscribeOptions{Addresses: []string{"0xnot-an-address"}})
+	assert.ErrorContains(t, err, "invalid address")
+
+	mockRPCCaller.AssertExpectations(t)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestResume(t *testing.T) {
+	ctx := context.Background()
+	mockRPCCaller := new(MockRPCCaller)
+	mockStorage := new(MockStorage)
+	parser := NewEthereumParser(mockRPCCaller, mockStorage)
+
+	resChan := make(chan Log)
+	mockStorage.On("GetActiveAddresses").Return(map[string]struct{}{testAddress1: {}, testAddress2: {}}, nil)
+	mockStorage.On("GetABI", mock.Anything).Return("", nil)
+	callback := &Callback{URL: "https://example.com/hook", Secret: "secret"}
+	mockStorage.On("GetCallback", testAddress1).Return(callback, nil)
+	mockStorage.On("GetCallback", testAddress2).Return(nil, nil)
+	// The stored filter of a subscription applies again when resumed
+	filter := &LogFilter{Addresses: []string{testAddress1, testOther}, Topics: [][]string{{erc20.TransferTopic}}}
+	mockStorage.On("GetFilter", testAddress1).Return(filter, nil)
+	mockStorage.On("GetFilter", testAddress2).Return(nil, nil)
+	mockStorage.On("AddActiveAddress", testAddress1).Return(nil)
+	mockStorage.On("GetLastProcessedBlock", testAddress1).Return(uint64(0), nil).Maybe()
+	mockRPCCaller.On("Subscribe", ctx, testAddress1, *filter).Return((<-chan Log)(resChan), nil)
+	// A subscription failing to resume is retried in the background
+	parser.minResumeDelay = time.Millisecond
+	resChan2 := make(chan Log)
+	resumed := make(chan struct{})
+	mockRPCCaller.On("Subscribe", ctx, testAddress2, LogFilter{Addresses: []string{testAddress2}}).Return(nil, errors.New("subscribe error")).Once()
+	mockRPCCaller.On("Subscribe", mock.Anything, testAddress2, LogFilter{Addresses: []string{testAddress2}}).Run(func(mock.Arguments) {
+		close(resumed)
+	}).Return((<-chan Log)(resChan2), nil).Once()
+	mockStorage.On("GetLastProcessedBlock", testAddress2).Return(uint64(0), nil).Maybe()
+
+	err := parser.Resume(ctx)
+	assert.ErrorContains(t, err, testAddress2)
+	assert.Equal(t, map[string]Callback{testAddress1: *callback}, parser.callbacks)
+
+	select {
+	case <-resumed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the subscription to be resumed")
+	}
+	parser.mu.Lock()
+	assert.Contains(t, parser.watchers, testAddress2)
+	parser.mu.Unlock()
+
+	mockRPCCaller.AssertExpectations(t)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestUnsubscribe(t *testing.T) {
+	ctx := context.Background()
+	mockRPCCaller := new(MockRPCCaller)
+	mockStorage := new(MockStorage)
+	parser := NewEthereumParser(mockRPCCaller, mockStorage)
+
+	resChan := make(chan Log)
+	mockStorage.On("GetActiveAddresses").Return(map[string]struct{}{}, nil).Once()
+	mockStorage.On("AddActiveAddress", testAddress).Return(nil)
+	mockStorage.On("GetLastProcessedBlock", testAddress).Return(uint64(0), nil)
+	mockRPCCaller.On("BlockNumber", ctx).Return(uint64(16), nil)
+	mockStorage.On("SetLastProcessedBlock", testAddress, uint64(16)).Return(nil)
+	mockRPCCaller.On("Subscribe", ctx, testAddress, LogFilter{Addresses: []string{testAddress}}).Return((<-chan Log)(resChan), nil)
+	assert.NoError(t, parser.Subscribe(ctx, testAddress, SubscribeOptions{}))
+
+	mockStorage.On("GetActiveAddresses").Return(map[string]struct{}{testAddress: {}}, nil).Once()
+	mockRPCCaller.On("Unsubscribe", ctx, testAddress).Run(func(mock.Arguments) { close(resChan) }).Return(nil)
+	mockStorage.On("RemoveActiveAddress", testAddress).Return(nil).Once()
+	mockStorage.On("RemoveCallback", testAddress).Return(nil)
+	mockStorage.On("RemoveABI", testAddress).Return(nil)
+	mockStorage.On("RemoveFilter", testAddress).Return(nil)
+	mockStorage.On("RemoveTransactionsFor", testAddress).Return(nil)
+	mockStorage.On("RemoveLogsFor", testAddress).Return(nil)
+	mockStorage.On("SetLastProcessedBlock", testAddress, uint64(0)).Return(nil)
 
-	err := parser.Subscribe(ctx, "0xAddress")
+	err := parser.Unsubscribe(ctx, testAddress, true)
+	assert.NoError(t, err)
+
+	// The watcher stopped without removing the address on its own
+	assert.Empty(t, parser.watchers)
+
+	mockRPCCaller.AssertExpectations(t)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestUnsubscribe_WaitsForBackfill(t *testing.T) {
+	ctx := context.Background()
+	mockRPCCaller := new(MockRPCCaller)
+	mockStorage := new(MockStorage)
+	parser := NewEthereumParser(mockRPCCaller, mockStorage)
+
+	filter := LogFilter{Addresses: []string{testAddress}}
+	backfilling := make(chan struct{})
+	var backfillStopped atomic.Bool
+
+	resChan := make(chan Log)
+	mockStorage.On("GetActiveAddresses").Return(map[string]struct{}{}, nil).Once()
+	mockStorage.On("AddActiveAddress", testAddress).Return(nil)
+	mockStorage.On("GetLastProcessedBlock", testAddress).Return(uint64(16), nil)
+	mockStorage.On("GetLogsFor", testAddress).Return([]Log{}, nil)
+	mockRPCCaller.On("Subscribe", ctx, testAddress, filter).Return((<-chan Log)(resChan), nil)
+	mockRPCCaller.On("BlockNumber", mock.Anything).Return(uint64(18), nil)
+	// The backfill is still running when unsubscribing
+	mockRPCCaller.On("GetLogs", mock.Anything, filter, uint64(16), uint64(18)).Run(func(args mock.Arguments) {
+		close(backfilling)
+		<-args.Get(0).(context.Context).Done()
+		backfillStopped.Store(true)
+	}).Return(nil, context.Canceled)
+	assert.NoError(t, parser.Subscribe(ctx, testAddress, SubscribeOptions{}))
+	<-backfilling
+
+	mockStorage.On("GetActiveAddresses").Return(map[string]struct{}{testAddress: {}}, nil).Once()
+	mockRPCCaller.On("Unsubscribe", ctx, testAddress).Return(nil)
+	mockStorage.On("RemoveActiveAddress", testAddress).Return(nil).Once()
+	mockStorage.On("RemoveCallback", testAddress).Return(nil)
+	mockStorage.On("RemoveABI", testAddress).Return(nil)
+	mockStorage.On("RemoveFilter", testAddress).Return(nil)
+	mockStorage.On("RemoveTransactionsFor", testAddress).Run(func(mock.Arguments) {
+		assert.True(t, backfillStopped.Load(), "records purged while backfilling")
+	}).Return(nil)
+	mockStorage.On("RemoveLogsFor", testAddress).Return(nil)
+	mockStorage.On("SetLastProcessedBlock", testAddress, uint64(0)).Return(nil)
+
+	err := parser.Unsubscribe(ctx, testAddress, true)
+	assert.NoError(t, err)
+
+	mockRPCCaller.AssertExpectations(t)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestUnsubscribe_WhileResuming(t *testing.T) {
+	ctx := context.Background()
+	mockRPCCaller := new(MockRPCCaller)
+	mockStorage := new(MockStorage)
+	parser := NewEthereumParser(mockRPCCaller, mockStorage)
+	parser.minResumeDelay = time.Millisecond
+
+	// The node keeps refusing the subscription until the address is unsubscribed
+	retried := make(chan struct{}, 1)
+	mockStorage.On("GetActiveAddresses").Return(map[string]struct{}{testAddress: {}}, nil)
+	mockStorage.On("GetABI", testAddress).Return("", nil)
+	mockStorage.On("GetCallback", testAddress).Return(nil, nil)
+	mockStorage.On("GetFilter", testAddress).Return(nil, nil)
+	mockRPCCaller.On("Subscribe", mock.Anything, testAddress, LogFilter{Addresses: []string{testAddress}}).Run(func(mock.Arguments) {
+		select {
+		case retried <- struct{}{}:
+		default:
+		}
+	}).Return(nil, errors.New("subscribe error"))
+
+	err := parser.Resume(ctx)
 	assert.Error(t, err)
+	<-retried
+	<-retried
+
+	mockRPCCaller.On("Unsubscribe", ctx, testAddress).Return(errors.New("not subscribed"))
+	mockStorage.On("RemoveActiveAddress", testAddress).Return(nil)
+	mockStorage.On("RemoveCallback", testAddress).Return(nil)
+	mockStorage.On("RemoveFilter", testAddress).Return(nil)
+	mockStorage.On("RemoveABI", testAddress).Return(nil)
+
+	err = parser.Unsubscribe(ctx, testAddress, false)
+	assert.NoError(t, err)
+	assert.Empty(t, parser.watchers)
 
 	mockRPCCaller.AssertExpectations(t)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestUnsubscribe_NotSubscribed(t *testing.T) {
+	ctx := context.Background()
+	mockRPCCaller := new(MockRPCCaller)
+	mockStorage := new(MockStorage)
+	parser := NewEthereumParser(mockRPCCaller, mockStorage)
+
+	mockStorage.On("GetActiveAddresses").Return(map[string]struct{}{}, nil)
+
+	err := parser.Unsubscribe(ctx, testAddress, false)
+	assert.ErrorIs(t, err, ErrNotSubscribed)
+
+	mockRPCCaller.AssertNotCalled(t, "Unsubscribe", ctx, testAddress)
+}
+
+func TestGetSubscriptions(t *testing.T) {
+	mockRPCCaller := new(MockRPCCaller)
+	mockStorage := new(MockStorage)
+	parser := NewEthereumParser(mockRPCCaller, mockStorage)
+
+	subscribedAt := time.Unix(1700000000, 0)
+	parser.watchers[testWatched] = &watcher{cancel: func() {}}
+
+	mockStorage.On("GetActiveAddresses").Return(map[string]struct{}{testWatched: {}, testIdle: {}}, nil)
+	mockStorage.On("GetSubscribedAt", mock.Anything).Return(subscribedAt, nil)
+	mockStorage.On("CountTransactionsFor", testWatched).Return(2, nil)
+	mockStorage.On("CountTransactionsFor", testIdle).Return(0, nil)
+	mockStorage.On("CountLogsFor", testWatched).Return(3, nil)
+	mockStorage.On("CountLogsFor", testIdle).Return(0, nil)
+	mockStorage.On("GetLatestTransactionFor", testWatched).Return(&Transaction{BlockNumber: "0x10"}, nil)
+	mockStorage.On("GetLatestTransactionFor", testIdle).Return(nil, nil)
+	mockStorage.On("GetLatestLogFor", testWatched).Return(&Log{BlockNumber: "0xf"}, nil)
+	mockStorage.On("GetLatestLogFor", testIdle).Return(nil, nil)
+	filter := &LogFilter{Addresses: []string{testWatched}, Topics: [][]string{{erc20.TransferTopic}}}
+	mockStorage.On("GetFilter", testWatched).Return(filter, nil)
+	mockStorage.On("GetFilter", testIdle).Return(nil, nil)
+	mockRPCCaller.On("ConnectionState", testWatched).Return(ConnectionStateReconnecting)
+
+	statuses, err := parser.GetSubscriptions()
+	assert.NoError(t, err)
+	assert.Equal(t, []SubscriptionStatus{
+		{Address: testIdle, SubscribedAt: subscribedAt, ConnectionState: ConnectionStateDisconnected},
+		{Address: testWatched, SubscribedAt: subscribedAt, LastEventBlock: 16, TransactionCount: 2, LogCount: 3, ConnectionState: ConnectionStateReconnecting, Filter: filter},
+	}, statuses)
+
+	mockRPCCaller.AssertExpectations(t)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestWatchForLogs_Backfill(t *testing.T) {
+	ctx := context.Background()
+	mockRPCCaller := new(MockRPCCaller)
+	mockStorage := new(MockStorage)
+	parser := NewEthereumParser(mockRPCCaller, mockStorage)
+
+	stored := Log{TransactionHash: "0xa", LogIndex: "0x0", BlockNumber: "0x10"}
+	missed := Log{TransactionHash: "0xb", LogIndex: "0x0", BlockNumber: "0x11"}
+	live := Log{TransactionHash: "0xc", LogIndex: "0x1", BlockNumber: "0x13"}
+
+	mockStorage.On("GetLastProcessedBlock", testAddress).Return(uint64(16), nil)
+	mockStorage.On("GetLogsFor", testAddress).Return([]Log{stored}, nil)
+	mockRPCCaller.On("BlockNumber", ctx).Return(uint64(18), nil)
+	mockRPCCaller.On("GetLogs", ctx, LogFilter{Addresses: []string{testAddress}}, uint64(16), uint64(18)).Return([]Log{stored, missed}, nil)
+	mockStorage.On("AddLogFor", testAddress, missed).Return(nil).Once()
+	mockStorage.On("SetLastProcessedBlock", testAddress, uint64(18)).Return(nil)
+	mockStorage.On("AddLogFor", testAddress, live).Return(nil).Once()
+	mockStorage.On("SetLastProcessedBlock", testAddress, uint64(19)).Return(nil)
+	mockStorage.On("RemoveActiveAddress", testAddress).Return(nil)
+
+	// The live stream overlaps with the backfilled range
+	resChan := make(chan Log, 2)
+	resChan <- missed
+	resChan <- live
+	close(resChan)
+
+	parser.watchForLogs(ctx, resChan, testAddress, LogFilter{Addresses: []string{testAddress}})
+
+	mockRPCCaller.AssertExpectations(t)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestWatchForLogs_BackfillSplit(t *testing.T) {
+	ctx := context.Background()
+	mockRPCCaller := new(MockRPCCaller)
+	mockStorage := new(MockStorage)
+	parser := NewEthereumParser(mockRPCCaller, mockStorage)
+
+	w := &watcher{cancel: func() {}}
+	parser.watchers[testAddress] = w
+
+	filter := LogFilter{Addresses: []string{testAddress}}
+	entry := Log{TransactionHash: "0xa", LogIndex: "0x0", BlockNumber: "0x10"}
+
+	// The node rejects the first range, which is halved, then grows back once it succeeds
+	mockStorage.On("GetLastProcessedBlock", testAddress).Return(uint64(1), nil)
+	mockStorage.On("GetLogsFor", testAddress).Return([]Log{}, nil)
+	mockRPCCaller.On("BlockNumber", ctx).Return(uint64(2000), nil)
+	mockRPCCaller.On("GetLogs", ctx, filter, uint64(1), uint64(1000)).Return(nil, fmt.Errorf("%w: query returned more than 10000 results", ErrRangeTooLarge)).Once()
+	mockRPCCaller.On("GetLogs", ctx, filter, uint64(1), uint64(500)).Return([]Log{entry}, nil).Once()
+	mockRPCCaller.On("GetLogs", ctx, filter, uint64(501), uint64(1500)).Return([]Log{}, nil).Once()
+	mockRPCCaller.On("GetLogs", ctx, filter, uint64(1501), uint64(2000)).Return([]Log{}, nil).Once()
+	mockStorage.On("AddLogFor", testAddress, entry).Return(nil).Once()
+	mockStorage.On("SetLastProcessedBlock", testAddress, uint64(500)).Return(nil).Once()
+	mockStorage.On("SetLastProcessedBlock", testAddress, uint64(1500)).Return(nil).Once()
+	mockStorage.On("SetLastProcessedBlock", testAddress, uint64(2000)).Return(nil).Once()
+	mockStorage.On("RemoveActiveAddress", testAddress).Return(nil)
+
+	resChan := make(chan Log)
+	close(resChan)
+
+	parser.watchForLogs(ctx, resChan, testAddress, filter)
+
+	assert.Equal(t, &BackfillProgress{FromBlock: 1, ToBlock: 2000, CurrentBlock: 2000, Done: true}, w.backfill)
+
+	mockRPCCaller.AssertExpectations(t)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestWatchForLogs_BackfillError(t *testing.T) {
+	ctx := context.Background()
+	mockRPCCaller := new(MockRPCCaller)
+	mockStorage := new(MockStorage)
+	parser := NewEthereumParser(mockRPCCaller, mockStorage)
+
+	w := &watcher{cancel: func() {}}
+	parser.watchers[testAddress] = w
+
+	// A single block cannot be split any further
+	filter := LogFilter{Addresses: []string{testAddress}}
+	mockStorage.On("GetLastProcessedBlock", testAddress).Return(uint64(16), nil)
+	mockStorage.On("GetLogsFor", testAddress).Return([]Log{}, nil)
+	mockRPCCaller.On("BlockNumber", ctx).Return(uint64(17), nil)
+	mockRPCCaller.On("GetLogs", ctx, filter, uint64(16), uint64(17)).Return(nil, fmt.Errorf("%w: too many results", ErrRangeTooLarge)).Once()
+	mockRPCCaller.On("GetLogs", ctx, filter, uint64(16), uint64(16)).Return(nil, errors.New("node error")).Once()
+	mockStorage.On("RemoveActiveAddress", testAddress).Return(nil)
+
+	resChan := make(chan Log)
+	close(resChan)
+
+	parser.watchForLogs(ctx, resChan, testAddress, filter)
+
+	assert.Equal(t, uint64(16), w.backfill.CurrentBlock)
+	assert.False(t, w.backfill.Done)
+	assert.Contains(t, w.backfill.Error, "node error")
+
+	mockRPCCaller.AssertExpectations(t)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestWatchForLogs_BackfillOutage(t *testing.T) {
+	ctx := context.Background()
+	mockRPCCaller := new(MockRPCCaller)
+	mockStorage := new(MockStorage)
+	parser := NewEthereumParser(mockRPCCaller, mockStorage)
+
+	w := &watcher{cancel: func() {}}
+	parser.watchers[testAddress] = w
+
+	// Failures other than the range being too large do not split it
+	filter := LogFilter{Addresses: []string{testAddress}}
+	mockStorage.On("GetLastProcessedBlock", testAddress).Return(uint64(1), nil)
+	mockStorage.On("GetLogsFor", testAddress).Return([]Log{}, nil)
+	mockRPCCaller.On("BlockNumber", ctx).Return(uint64(2000), nil)
+	mockRPCCaller.On("GetLogs", ctx, filter, uint64(1), uint64(1000)).Return(nil, context.DeadlineExceeded).Once()
+	mockStorage.On("RemoveActiveAddress", testAddress).Return(nil)
+
+	resChan := make(chan Log)
+	close(resChan)
+
+	parser.watchForLogs(ctx, resChan, testAddress, filter)
+
+	assert.Equal(t, uint64(1), w.backfill.CurrentBlock)
+	assert.False(t, w.backfill.Done)
+	assert.Contains(t, w.backfill.Error, "blocks 1-1000")
+
+	mockRPCCaller.AssertExpectations(t)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestWatchForLogs_Removed(t *testing.T) {
+	ctx := context.Background()
+	mockRPCCaller := new(MockRPCCaller)
+	mockStorage := new(MockStorage)
+	parser := NewEthereumParser(mockRPCCaller, mockStorage)
+
+	orphaned := Log{BlockHash: "0xorphaned", TransactionHash: "0xa", LogIndex: "0x0", BlockNumber: "0x10", Removed: true}
+	canonical := Log{BlockHash: "0xcanonical", TransactionHash: "0xa", LogIndex: "0x0", BlockNumber: "0x10"}
+
+	mockStorage.On("GetLastProcessedBlock", testAddress).Return(uint64(0), nil)
+	mockStorage.On("RemoveLogsInBlock", "0xorphaned").Return(nil).Once()
+	mockStorage.On("AddLogFor", testAddress, canonical).Return(nil).Once()
+	mockStorage.On("SetLastProcessedBlock", testAddress, uint64(16)).Return(nil)
+	mockStorage.On("RemoveActiveAddress", testAddress).Return(nil)
+
+	resChan := make(chan Log, 2)
+	resChan <- orphaned
+	resChan <- canonical
+	close(resChan)
+
+	parser.watchForLogs(ctx, resChan, testAddress, LogFilter{Addresses: []string{testAddress}})
+
+	mockRPCCaller.AssertExpectations(t)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestWatchForLogs_Notify(t *testing.T) {
+	ctx := context.Background()
+	mockRPCCaller := new(MockRPCCaller)
+	mockStorage := new(MockStorage)
+	mockNotifier := new(MockNotifier)
+	parser := NewEthereumParser(mockRPCCaller, mockStorage)
+	parser.SetNotifier(mockNotifier)
+
+	entry := Log{BlockHash: "0xblock", TransactionHash: "0xa", LogIndex: "0x0", BlockNumber: "0x10"}
+	callback := Callback{URL: "https://example.com/hook", Secret: "secret"}
+	// The callback is looked up in memory rather than in the storage
+	parser.callbacks[testAddress] = callback
+
+	mockStorage.On("GetLastProcessedBlock", testAddress).Return(uint64(0), nil)
+	mockStorage.On("AddLogFor", testAddress, entry).Return(nil).Once()
+	mockNotifier.On("Notify", callback, Event{Address: testAddress, Log: &entry}).Return(nil).Once()
+	mockStorage.On("SetLastProcessedBlock", testAddress, uint64(16)).Return(nil)
+	mockStorage.On("RemoveActiveAddress", testAddress).Return(nil)
+
+	resChan := make(chan Log, 1)
+	resChan <- entry
+	close(resChan)
+
+	parser.watchForLogs(ctx, resChan, testAddress, LogFilter{Addresses: []string{testAddress}})
+
+	mockRPCCaller.AssertExpectations(t)
+	mockStorage.AssertExpectations(t)
+	mockNotifier.AssertExpectations(t)
+}
+
+func TestStream(t *testing.T) {
+	mockRPCCaller := new(MockRPCCaller)
+	mockStorage := new(MockStorage)
+	parser := NewEthereumParser(mockRPCCaller, mockStorage)
+
+	mockStorage.On("GetActiveAddresses").Return(map[string]struct{}{testAddress: {}}, nil)
+
+	// The listener registered before checking the subscription is removed
+	_, _, err := parser.Stream(testOther, 0)
+	assert.ErrorIs(t, err, ErrNotSubscribed)
+	assert.Empty(t, parser.hub.listeners)
+
+	parser.notify(Event{Address: testAddress, Log: &Log{TransactionHash: "0xa"}})
+	parser.notify(Event{Address: testOther, Log: &Log{TransactionHash: "0xb"}})
+	parser.notify(Event{Address: testAddress, Transaction: &Transaction{Hash: "0xc"}})
+
+	// A listener resuming after the first event only gets the later ones of its address
+	events, stop, err := parser.Stream(testAddress, 1)
+	assert.NoError(t, err)
+	defer stop()
+
+	live, stopLive, err := parser.Stream(testAddress, 3)
+	assert.NoError(t, err)
+	defer stopLive()
+
+	parser.notify(Event{Address: testAddress, Log: &Log{TransactionHash: "0xd"}})
+
+	assert.Equal(t, StreamEvent{ID: 3, Event: Event{Address: testAddress, Transaction: &Transaction{Hash: "0xc"}}}, <-events)
+	assert.Equal(t, uint64(4), (<-events).ID)
+	assert.Equal(t, uint64(4), (<-live).ID)
+
+	// A listener falling behind is dropped instead of blocking the parser
+	for range listenerBuffer + 1 {
+		parser.notify(Event{Address: testAddress, Log: &Log{}})
+	}
+	for range live {
+	}
+
+	// Every listener is closed on unsubscribe
+	stopLive()
+	parser.hub.close(testAddress)
+	for range events {
+	}
+}
+
+func TestWatchHeads_Reorg(t *testing.T) {
+	ctx := context.Background()
+	mockRPCCaller := new(MockRPCCaller)
+	mockStorage := new(MockStorage)
+	parser := NewEthereumParser(mockRPCCaller, mockStorage)
+
+	mockStorage.On("GetActiveAddresses").Return(map[string]struct{}{testAddress: {}}, nil)
+	mockRPCCaller.On("GetBlocksByNumber", ctx, []uint64{16}).Return([]*Block{{Number: "0x10", Hash: "0xa"}}, make([]error, 1), nil).Once()
+	mockRPCCaller.On("GetBlocksByNumber", ctx, []uint64{17}).Return([]*Block{{Number: "0x11", Hash: "0xb"}}, make([]error, 1), nil).Once()
+	// Block 16 is fetched to find it is still canonical while block 17 is replaced, the transactions
+	// stored for it are removed before it is processed again along with the new head
+	mockRPCCaller.On("GetBlockByNumber", ctx, uint64(16)).Return(&Block{Number: "0x10", Hash: "0xa"}, nil).Once()
+	mockStorage.On("RemoveTransactionsInBlock", "0xb").Return(nil).Once()
+	mockRPCCaller.On("GetBlocksByNumber", ctx, []uint64{17, 18}).Return([]*Block{
+		{Number: "0x11", Hash: "0xc"},
+		{Number: "0x12", Hash: "0xd"},
+	}, make([]error, 2), nil).Once()
+
+	heads := make(chan Header, 5)
+	heads <- Header{Number: "0x10", Hash: "0xa"}
+	heads <- Header{Number: "0x11", Hash: "0xb", ParentHash: "0xa"}
+	// Headers already processed are skipped
+	heads <- Header{Number: "0x11", Hash: "0xb", ParentHash: "0xa"}
+	heads <- Header{Number: "0x12", Hash: "0xd", ParentHash: "0xc"}
+	close(heads)
+
+	parser.watchHeads(ctx, heads)
+
+	mockRPCCaller.AssertExpectations(t)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestWatchHeads_Retry(t *testing.T) {
+	ctx := context.Background()
+	mockRPCCaller := new(MockRPCCaller)
+	mockStorage := new(MockStorage)
+	parser := NewEthereumParser(mockRPCCaller, mockStorage)
+
+	// The blocks failing are processed again along with the next header
+	mockStorage.On("GetActiveAddresses").Return(map[string]struct{}{testAddress: {}}, nil)
+	mockRPCCaller.On("GetBlocksByNumber", ctx, []uint64{16}).Return(nil, nil, errors.New("rpc error")).Once()
+	mockRPCCaller.On("GetBlocksByNumber", ctx, []uint64{16, 17}).Return([]*Block{
+		{Number: "0x10", Hash: "0xa"},
+		nil,
+	}, []error{nil, errors.New("block 17 not found")}, nil).Once()
+	mockRPCCaller.On("GetBlocksByNumber", ctx, []uint64{17}).Return([]*Block{nil}, []error{errors.New("block 17 not found")}, nil).Once()
+	mockRPCCaller.On("GetBlockByNumber", ctx, uint64(16)).Return(&Block{Number: "0x10", Hash: "0xa"}, nil).Once()
+	mockRPCCaller.On("GetBlocksByNumber", ctx, []uint64{17, 18}).Return([]*Block{
+		{Number: "0x11", Hash: "0xb"},
+		{Number: "0x12", Hash: "0xc"},
+	}, make([]error, 2), nil).Once()
+
+	heads := make(chan Header, 3)
+	heads <- Header{Number: "0x10", Hash: "0xa"}
+	heads <- Header{Number: "0x11", Hash: "0xb", ParentHash: "0xa"}
+	heads <- Header{Number: "0x12", Hash: "0xc", ParentHash: "0xb"}
+	close(heads)
+
+	parser.watchHeads(ctx, heads)
+
+	mockRPCCaller.AssertExpectations(t)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestProcessBlocks(t *testing.T) {
+	ctx := context.Background()
+	mockRPCCaller := new(MockRPCCaller)
+	mockStorage := new(MockStorage)
+	parser := NewEthereumParser(mockRPCCaller, mockStorage)
+
+	outbound := Transaction{Hash: "0xa", From: "0xSender", To: "0xother", Value: "0x1", BlockNumber: "0x10"}
+	inbound := Transaction{Hash: "0xb", From: "0xother", To: "0xRECIPIENT", Value: "0x2", BlockNumber: "0x10"}
+	unrelated := Transaction{Hash: "0xc", From: "0xother", To: "0xanother", Value: "0x3", BlockNumber: "0x10"}
+	creation := Transaction{Hash: "0xd", From: "0xother", Input: "0x60", BlockNumber: "0x10"}
+
+	retried := Transaction{Hash: "0xe", From: "0xSender", To: "0xother", Value: "0x4", BlockNumber: "0x11"}
+	later := Transaction{Hash: "0xf", From: "0xother", To: "0xRecipient", Value: "0x5", BlockNumber: "0x12"}
+
+	mockStorage.On("GetActiveAddresses").Return(map[string]struct{}{"0xsender": {}, "0xRecipient": {}}, nil)
+	// Receipts are fetched in a single batch for the matching transactions of a block. A block failing
+	// to be fetched is fetched once more.
+	mockRPCCaller.On("GetBlocksByNumber", ctx, []uint64{16, 17, 18}).Return([]*Block{
+		{Number: "0x10", Hash: "0x10a", Transactions: []Transaction{outbound, inbound, unrelated, creation}},
+		nil,
+		{Number: "0x12", Hash: "0x12a", Transactions: []Transaction{later}},
+	}, []error{nil, errors.New("block 17 not found"), nil}, nil).Once()
+	mockRPCCaller.On("GetBlocksByNumber", ctx, []uint64{17}).Return([]*Block{
+		{Number: "0x11", Hash: "0x11a", Transactions: []Transaction{retried, unrelated}},
+	}, make([]error, 1), nil).Once()
+	mockRPCCaller.On("GetTransactionReceipts", ctx, []string{"0xa", "0xb"}).Return([]*Receipt{
+		{Status: "0x1", GasUsed: "0x5208"},
+		{Status: "0x0", GasUsed: "0x5208"},
+	}, nil)
+	// Processing stops at a block failing, the blocks after it are left to the next attempt
+	mockRPCCaller.On("GetTransactionReceipts", ctx, []string{"0xe"}).Return(nil, errors.New("receipt not found")).Once()
+
+	outbound.Status, outbound.GasUsed = "0x1", "0x5208"
+	inbound.Status, inbound.GasUsed = "0x0", "0x5208"
+	mockStorage.On("AddTransactionFor", "0xsender", outbound).Return(nil).Once()
+	mockStorage.On("AddTransactionFor", "0xRecipient", inbound).Return(nil).Once()
+
+	hashes, err := parser.processBlocks(ctx, 16, 18)
+	assert.ErrorContains(t, err, "receipt not found")
+	assert.Equal(t, []string{"0x10a"}, hashes)
+
+	mockRPCCaller.On("GetBlocksByNumber", ctx, []uint64{17, 18}).Return([]*Block{
+		{Number: "0x11", Hash: "0x11a", Transactions: []Transaction{retried, unrelated}},
+		{Number: "0x12", Hash: "0x12a", Transactions: []Transaction{later}},
+	}, make([]error, 2), nil).Once()
+	mockRPCCaller.On("GetTransactionReceipts", ctx, []string{"0xe"}).Return([]*Receipt{{Status: "0x1", GasUsed: "0x5208"}}, nil).Once()
+	mockRPCCaller.On("GetTransactionReceipts", ctx, []string{"0xf"}).Return([]*Receipt{{Status: "0x1", GasUsed: "0x5208"}}, nil).Once()
+
+	retried.Status, retried.GasUsed = "0x1", "0x5208"
+	later.Status, later.GasUsed = "0x1", "0x5208"
+	mockStorage.On("AddTransactionFor", "0xsender", retried).Return(nil).Once()
+	mockStorage.On("AddTransactionFor", "0xRecipient", later).Return(nil).Once()
+
+	hashes, err = parser.processBlocks(ctx, 17, 18)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0x11a", "0x12a"}, hashes)
+
+	mockRPCCaller.AssertExpectations(t)
+	mockStorage.AssertExpectations(t)
 }
 
 func TestGetTransactions(t *testing.T) {
+	ctx := context.Background()
+	mockRPCCaller := new(MockRPCCaller)
+	mockStorage := new(MockStorage)
+	parser := NewEthereumParser(mockRPCCaller, mockStorage)
+
+	// At least 5 confirmations at head 0x20 means at most block 0x1c
+	toBlock := uint64(28)
+	mockStorage.On("QueryTransactionsFor", testAddress, Query{ToBlock: &toBlock, MinConfirmations: 5, Order: OrderAsc, Limit: DefaultPageSize + 1}).Return([]Transaction{
+		{Hash: "0xHash1", BlockNumber: "0x10"},
+		{Hash: "0xHash2", BlockNumber: "0x18"},
+		{Hash: "0xHash3", BlockNumber: "0x1c"},
+	}, nil)
+	mockRPCCaller.On("BlockNumber", ctx).Return(uint64(32), nil)
+	// The tagged blocks are fetched once per head
+	mockRPCCaller.On("GetHeaderByTag", ctx, "safe").Return(&Header{Number: "0x18"}, nil).Once()
+	mockRPCCaller.On("GetHeaderByTag", ctx, "finalized").Return(&Header{Number: "0x10"}, nil).Once()
+
+	txns, next, err := parser.GetTransactions(ctx, testAddress, Query{MinConfirmations: 5})
+	assert.NoError(t, err)
+	assert.Empty(t, next)
+	assert.Equal(t, []Transaction{
+		{Hash: "0xHash1", BlockNumber: "0x10", Confirmations: 17, Finality: FinalityFinalized},
+		{Hash: "0xHash2", BlockNumber: "0x18", Confirmations: 9, Finality: FinalitySafe},
+		{Hash: "0xHash3", BlockNumber: "0x1c", Confirmations: 5, Finality: FinalityLatest},
+	}, txns)
+
+	// No block has more confirmations than the chain has blocks
+	txns, _, err = parser.GetTransactions(ctx, testAddress, Query{MinConfirmations: 34})
+	assert.NoError(t, err)
+	assert.Empty(t, txns)
+
+	mockRPCCaller.AssertExpectations(t)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestGetLogs(t *testing.T) {
+	ctx := context.Background()
+	mockRPCCaller := new(MockRPCCaller)
+	mockStorage := new(MockStorage)
+	parser := NewEthereumParser(mockRPCCaller, mockStorage)
+
+	// One more log than the limit is fetched to tell whether there is a next page
+	query := Query{Topics: [][]string{{"0xtopic"}}, Order: OrderDesc, Limit: 2}
+	storageQuery := query
+	storageQuery.Limit = 3
+	mockStorage.On("QueryLogsFor", testAddress, storageQuery).Return([]Log{
+		{Address: testAddress, LogIndex: "0x1", BlockNumber: "0x20"},
+		{Address: testAddress, LogIndex: "0x0", BlockNumber: "0x10"},
+		{Address: testAddress, LogIndex: "0x0", BlockNumber: "0x8"},
+	}, nil)
+	mockRPCCaller.On("BlockNumber", ctx).Return(uint64(32), nil)
+	// Nodes without the safe and finalized tags only report confirmations
+	mockRPCCaller.On("GetHeaderByTag", ctx, mock.Anything).Return(nil, errors.New("unknown block tag"))
+
+	logs, next, err := parser.GetLogs(ctx, testAddress, query)
+	assert.NoError(t, err)
+	assert.Equal(t, []Log{
+		{Address: testAddress, LogIndex: "0x1", BlockNumber: "0x20", Confirmations: 1, Finality: FinalityLatest},
+		{Address: testAddress, LogIndex: "0x0", BlockNumber: "0x10", Confirmations: 17, Finality: FinalityLatest},
+	}, logs)
+	assert.Equal(t, Position{Block: 16, Index: 0}.Cursor(), next)
+
+	after, err := Query{Cursor: next}.After()
+	assert.NoError(t, err)
+	assert.Equal(t, &Position{Block: 16, Index: 0}, after)
+
+	mockRPCCaller.AssertExpectations(t)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestDecodeLog(t *testing.T) {
+	transfer := Log{
+		Topics: []string{
+			erc20.TransferTopic,
+			"0x00000000000000000000000028c6c06298d514db089934071355e5743bf21d60",
+			"0x000000000000000000000000a0b86991c6218b36c1d19d4a2e9eb0ce3606eb48",
+		},
+		Data: "0x00000000000000000000000000000000000000000000000000000000000f4240",
+	}
+	decodeLog(&transfer)
+	assert.Nil(t, transfer.Approval)
+	assert.Equal(t, "0x28c6c06298d514db089934071355e5743bf21d60", transfer.Transfer.From)
+	assert.Equal(t, "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48", transfer.Transfer.To)
+	assert.Equal(t, "1000000", transfer.Transfer.Value.String())
+
+	assert.Nil(t, transfer.NFTTransfer)
+
+	// An ERC-721 transfer shares the signature but is decoded as an NFT transfer
+	tokenID := "0x0000000000000000000000000000000000000000000000000000000000000007"
+	erc721 := Log{Topics: append(slices.Clone(transfer.Topics[:3]), tokenID), Data: "0x"}
+	decodeLog(&erc721)
+	assert.Nil(t, erc721.Transfer)
+	if assert.NotNil(t, erc721.NFTTransfer) {
+		assert.Equal(t, nft.ERC721, erc721.NFTTransfer.Standard)
+		assert.Equal(t, "7", erc721.NFTTransfer.Tokens[0].ID.String())
+	}
+
+	erc1155 := Log{
+		Topics: []string{nft.TransferSingleTopic, transfer.Topics[1], transfer.Topics[1], transfer.Topics[2]},
+		Data:   "0x" + tokenID[2:] + transfer.Data[2:],
+	}
+	decodeLog(&erc1155)
+	if assert.NotNil(t, erc1155.NFTTransfer) {
+		assert.Equal(t, nft.ERC1155, erc1155.NFTTransfer.Standard)
+		assert.Equal(t, "1000000", erc1155.NFTTransfer.Tokens[0].Amount.String())
+	}
+
+	other := Log{Topics: []string{"0xtopic"}}
+	decodeLog(&other)
+	assert.Nil(t, other.Transfer)
+	assert.Nil(t, other.Approval)
+	assert.Nil(t, other.NFTTransfer)
+}
+
+func TestGetNFTHoldings(t *testing.T) {
 	mockRPCCaller := new(MockRPCCaller)
 	mockStorage := new(MockStorage)
 	parser := NewEthereumParser(mockRPCCaller, mockStorage)
 
-	expectedTxns := []Transaction{
-		{Address: "0xAddress1"},
-		{Address: "0xAddress2"},
+	const (
+		zero  = "0x0000000000000000000000000000000000000000000000000000000000000000"
+		alice = "0x00000000000000000000000028c6c06298d514db089934071355e5743bf21d60"
+		bob   = "0x000000000000000000000000a0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"
+		token = "0x0000000000000000000000000000000000000000000000000000000000000001"
+	)
+
+	// The transfer to bob was stored before the mint to alice, which was backfilled. The mint is also
+	// observed by another subscription, it is only applied once.
+	transfers := Query{Topics: [][]string{{nft.TransferTopic, nft.TransferSingleTopic, nft.TransferBatchTopic}}}
+	mint := Log{Address: testCollection, BlockHash: "0xb1", TransactionHash: "0xa", BlockNumber: "0x1", LogIndex: "0x0", Topics: []string{nft.TransferTopic, zero, alice, token}, Data: "0x"}
+	stored := []Log{
+		{Address: testCollection, BlockHash: "0xb2", TransactionHash: "0xb", BlockNumber: "0x2", LogIndex: "0x0", Topics: []string{nft.TransferTopic, alice, bob, token}, Data: "0x"},
+		mint,
+	}
+	mockStorage.On("GetActiveAddresses").Return(map[string]struct{}{testCollection: {}, testOther: {}}, nil)
+	mockStorage.On("QueryLogsFor", testCollection, transfers).Return(stored, nil)
+	mockStorage.On("QueryLogsFor", testOther, transfers).Return([]Log{mint}, nil)
+
+	holdings, err := parser.GetNFTHoldings("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48")
+	assert.NoError(t, err)
+	if assert.Len(t, holdings, 1) {
+		assert.Equal(t, testCollection, holdings[0].Contract)
+		assert.Equal(t, "1", holdings[0].TokenID.String())
 	}
-	mockStorage.On("GetTransactionsFor", "0xAddress").Return(expectedTxns, nil)
 
-	txns, err := parser.GetTransactions("0xAddress")
+	holdings, err = parser.GetNFTHoldings("0x28c6c06298d514db089934071355e5743bf21d60")
 	assert.NoError(t, err)
-	assert.Equal(t, expectedTxns, txns)
+	assert.Empty(t, holdings)
+
+	// The stored logs are left in the order they were returned in
+	assert.Equal(t, "0x2", stored[0].BlockNumber)
+
+	mockStorage.AssertExpectations(t)
+}
+
+func TestGetBalance(t *testing.T) {
+	ctx := context.Background()
+	mockRPCCaller := new(MockRPCCaller)
+	mockStorage := new(MockStorage)
+	parser := NewEthereumParser(mockRPCCaller, mockStorage)
+
+	// Without a block, every balance is read at the current one
+	mockRPCCaller.On("BlockNumber", ctx).Return(uint64(32), nil)
+	mockRPCCaller.On("GetBalance", ctx, testAddress, uint64(32)).Return(big.NewInt(1000), nil).Once()
+	mockRPCCaller.On("GetTokenBalance", ctx, testCollection, testAddress, uint64(32)).Return(big.NewInt(42), nil).Once()
+
+	balance, err := parser.GetBalance(ctx, testAddress, []string{testCollection}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, &Balance{
+		Address: testAddress,
+		Block:   32,
+		Balance: "1000",
+		Tokens:  []TokenBalance{{Token: testCollection, Balance: "42"}},
+	}, balance)
+
+	block := uint64(16)
+	mockRPCCaller.On("GetBalance", ctx, testAddress, uint64(16)).Return(big.NewInt(7), nil).Once()
+
+	balance, err = parser.GetBalance(ctx, testAddress, nil, &block)
+	assert.NoError(t, err)
+	assert.Equal(t, &Balance{Address: testAddress, Block: 16, Balance: "7"}, balance)
+
+	block = 33
+	_, err = parser.GetBalance(ctx, testAddress, nil, &block)
+	assert.ErrorIs(t, err, ErrUnknownBlock)
+
+	_, err = parser.GetBalance(ctx, testAddress, []string{"0xtoken"}, nil)
+	assert.ErrorIs(t, err, addresspkg.ErrInvalid)
+
+	mockRPCCaller.AssertExpectations(t)
+}
+
+func TestSetABI(t *testing.T) {
+	mockRPCCaller := new(MockRPCCaller)
+	mockStorage := new(MockStorage)
+	parser := NewEthereumParser(mockRPCCaller, mockStorage)
+
+	contractABI := `[{"type":"event","name":"Transfer","inputs":[
+		{"name":"from","type":"address","indexed":true},
+		{"name":"to","type":"address","indexed":true},
+		{"name":"value","type":"uint256"}
+	]}]`
+
+	mockStorage.On("GetActiveAddresses").Return(map[string]struct{}{testAddress: {}}, nil)
+	mockStorage.On("SetABI", testAddress, contractABI).Return(nil)
+
+	err := parser.SetABI(testOther, []byte(contractABI))
+	assert.ErrorIs(t, err, ErrNotSubscribed)
+
+	err = parser.SetABI(testAddress, []byte(`{"not": "an ABI"}`))
+	assert.ErrorIs(t, err, ErrInvalidABI)
+
+	err = parser.SetABI(testAddress, []byte(contractABI))
+	assert.NoError(t, err)
+
+	entry := Log{
+		Topics: []string{
+			erc20.TransferTopic,
+			"0x00000000000000000000000028c6c06298d514db089934071355e5743bf21d60",
+			"0x000000000000000000000000a0b86991c6218b36c1d19d4a2e9eb0ce3606eb48",
+		},
+		Data: "0x00000000000000000000000000000000000000000000000000000000000f4240",
+	}
+	parser.decodeEvent(testAddress, &entry)
+	if assert.NotNil(t, entry.Decoded) {
+		assert.Equal(t, "Transfer(address,address,uint256)", entry.Decoded.Signature)
+		assert.Equal(t, "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48", entry.Decoded.Args[1].Value)
+		assert.Equal(t, "1000000", fmt.Sprint(entry.Decoded.Args[2].Value))
+	}
+
+	// Logs of other addresses and of events missing from the ABI are left as is
+	other := entry
+	other.Decoded = nil
+	parser.decodeEvent(testOther, &other)
+	assert.Nil(t, other.Decoded)
+
+	unknown := Log{Topics: []string{erc20.ApprovalTopic}}
+	parser.decodeEvent(testAddress, &unknown)
+	assert.Nil(t, unknown.Decoded)
 
 	mockStorage.AssertExpectations(t)
 }
 
 func TestGetTransactions_Error(t *testing.T) {
+	ctx := context.Background()
 	mockRPCCaller := new(MockRPCCaller)
 	mockStorage := new(MockStorage)
 	parser := NewEthereumParser(mockRPCCaller, mockStorage)
 
-	mockStorage.On("GetTransactionsFor", "0xAddress").Return(nil, errors.New("storage error"))
+	_, _, err := parser.GetTransactions(ctx, testAddress, Query{Cursor: "not a cursor"})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+
+	mockRPCCaller.On("BlockNumber", ctx).Return(uint64(32), nil)
+	mockRPCCaller.On("GetHeaderByTag", ctx, mock.Anything).Return(&Header{Number: "0x10"}, nil)
+	mockStorage.On("QueryTransactionsFor", testAddress, mock.Anything).Return(nil, errors.New("storage error"))
 
-	txns, err := parser.GetTransactions("0xAddress")
+	txns, _, err := parser.GetTransactions(ctx, testAddress, Query{})
 	assert.Error(t, err)
 	assert.Nil(t, txns)
 
@@ -0,0 +1,27 @@
+package parser
+
+import "time"
+
+// ProviderHealth is the health of a JSON-RPC provider calls are routed to
+type ProviderHealth struct {
+	// Name is the scheme and host of the provider, its path and query are left out as they often hold an API key
+	Name string `json:"name"`
+	// Healthy is set when the provider did not fail its last request and is not lagging behind the others
+	Healthy bool `json:"healthy"`
+	// Streaming is set for the provider the subscriptions are streamed from
+	Streaming bool `json:"streaming"`
+	// LatencyMS is the moving average of the duration of the requests, in milliseconds
+	LatencyMS int64 `json:"latencyMs"`
+	// HeadBlock is the most recent block reported by the provider, and HeadLag the number of blocks
+	// it is behind the most advanced provider
+	HeadBlock           uint64    `json:"headBlock"`
+	HeadLag             uint64    `json:"headLag"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	LastError           string    `json:"lastError,omitempty"`
+	CheckedAt           time.Time `json:"checkedAt"`
+}
+
+// GetProviderHealth returns the health of every JSON-RPC provider, in the order calls are routed to them
+func (p *EthereumParser) GetProviderHealth() []ProviderHealth {
+	return p.rpcCaller.ProviderHealth()
+}
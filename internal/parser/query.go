@@ -0,0 +1,181 @@
+package parser
+
+import (
+	"cmp"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/eth/quantity"
+)
+
+const (
+	// DefaultPageSize is the number of records returned when a query has no limit
+	DefaultPageSize = 100
+	// MaxPageSize is the largest number of records a query can return at once
+	MaxPageSize = 1000
+	// maxTopics is the number of topic positions of a log
+	maxTopics = 4
+)
+
+// ErrInvalidCursor is returned when a page cursor was not issued by a previous query
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Order is the order records are returned in, by block then by position within the block
+type Order string
+
+const (
+	// OrderAsc returns the oldest records first
+	OrderAsc Order = "asc"
+	// OrderDesc returns the newest records first
+	OrderDesc Order = "desc"
+)
+
+// Query selects a page of the records of an address
+type Query struct {
+	// FromBlock and ToBlock bound the blocks of the records, inclusively
+	FromBlock *uint64
+	ToBlock   *uint64
+	// Topics filters logs by topic position, a log matches when the topic at every position is one of
+	// the topics given for it. An empty position matches any topic.
+	Topics [][]string
+	// MinConfirmations drops the records with fewer confirmations
+	MinConfirmations uint64
+	Order            Order
+	// Cursor continues from where the previous page ended, empty for the first page
+	Cursor string
+	// Limit is the maximum number of records returned, DefaultPageSize if zero
+	Limit int
+}
+
+// Validate checks that a query is within bounds and that its cursor, if any, was issued by a previous query
+func (q Query) Validate() error {
+	if q.Order != "" && q.Order != OrderAsc && q.Order != OrderDesc {
+		return fmt.Errorf("invalid order %q, expected %q or %q", q.Order, OrderAsc, OrderDesc)
+	}
+
+	if q.Limit < 0 || q.Limit > MaxPageSize {
+		return fmt.Errorf("invalid limit %d, expected at most %d", q.Limit, MaxPageSize)
+	}
+
+	for _, bound := range []*uint64{q.FromBlock, q.ToBlock} {
+		if bound != nil && *bound > math.MaxInt64 {
+			return fmt.Errorf("invalid block %d, expected at most %d", *bound, int64(math.MaxInt64))
+		}
+	}
+
+	if q.FromBlock != nil && q.ToBlock != nil && *q.FromBlock > *q.ToBlock {
+		return fmt.Errorf("invalid block range, from block %d is after to block %d", *q.FromBlock, *q.ToBlock)
+	}
+
+	if len(q.Topics) > maxTopics {
+		return fmt.Errorf("invalid topics, expected at most %d positions", maxTopics)
+	}
+
+	if _, err := q.After(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// After returns the position the query continues after, nil for the first page
+func (q Query) After() (*Position, error) {
+	if q.Cursor == "" {
+		return nil, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	block, index, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+
+	var position Position
+	if position.Block, err = strconv.ParseUint(block, 10, 63); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if position.Index, err = strconv.ParseUint(index, 10, 63); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &position, nil
+}
+
+// MatchesTopics checks whether the topics of a log satisfy the topic filters of the query
+func (q Query) MatchesTopics(topics []string) bool {
+	for i, allowed := range q.Topics {
+		if len(allowed) == 0 {
+			continue
+		}
+
+		if i >= len(topics) {
+			return false
+		}
+
+		matched := false
+		for _, topic := range allowed {
+			if strings.EqualFold(topic, topics[i]) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Position orders the records of an address, by block then by index within the block
+type Position struct {
+	Block uint64
+	Index uint64
+}
+
+// Cursor returns the cursor of a query continuing after the position
+func (p Position) Cursor() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", p.Block, p.Index)))
+}
+
+// Compare returns -1, 0 or 1 when the position is before, the same as or after another one
+func (p Position) Compare(other Position) int {
+	if c := cmp.Compare(p.Block, other.Block); c != 0 {
+		return c
+	}
+	return cmp.Compare(p.Index, other.Index)
+}
+
+// Position returns where the transaction is in the chain, malformed numbers count as zero
+func (t Transaction) Position() Position {
+	block, _ := quantity.ParseHex(t.BlockNumber)
+	index, _ := quantity.ParseHex(t.TransactionIndex)
+	return Position{Block: block, Index: index}
+}
+
+// Position returns where the log is in the chain, malformed numbers count as zero
+func (l Log) Position() Position {
+	block, _ := quantity.ParseHex(l.BlockNumber)
+	index, _ := quantity.ParseHex(l.LogIndex)
+	return Position{Block: block, Index: index}
+}
+
+// page returns the first limit records and the cursor of the next page, empty if there is none.
+// The records are fetched with one more than the limit to tell whether there is a next page.
+func page[T interface{ Position() Position }](records []T, limit int) ([]T, string) {
+	if len(records) <= limit {
+		return records, ""
+	}
+
+	records = records[:limit]
+	return records, records[limit-1].Position().Cursor()
+}
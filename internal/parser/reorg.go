@@ -0,0 +1,107 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/log"
+)
+
+// reorgDepth is the number of recent block hashes kept to detect chain reorganisations
+const reorgDepth = 128
+
+// canonicalChain tracks the hashes of the recently processed blocks by height
+type canonicalChain struct {
+	// head is the highest processed block
+	head   uint64
+	hashes map[uint64]string
+}
+
+// newCanonicalChain creates an empty chain
+func newCanonicalChain() *canonicalChain {
+	return &canonicalChain{
+		hashes: make(map[uint64]string),
+	}
+}
+
+// add records a processed block, its hash is empty if the block was not fetched
+func (c *canonicalChain) add(number uint64, hash string) {
+	if hash != "" {
+		c.hashes[number] = hash
+	}
+	c.head = max(c.head, number)
+
+	for height := range c.hashes {
+		if height+reorgDepth <= c.head {
+			delete(c.hashes, height)
+		}
+	}
+}
+
+// truncate forgets the blocks above the given height
+func (c *canonicalChain) truncate(number uint64) {
+	for height := range c.hashes {
+		if height > number {
+			delete(c.hashes, height)
+		}
+	}
+	c.head = min(c.head, number)
+}
+
+// rollbackReorg checks whether a new header reorganised the processed blocks. The transactions stored
+// for the orphaned blocks are removed and the chain is truncated down to the last block still canonical,
+// so the blocks after it are processed again from the new branch.
+func (p *EthereumParser) rollbackReorg(ctx context.Context, chain *canonicalChain, header Header, number uint64) error {
+	var orphaned []uint64
+	for height := chain.head; height > 0; height-- {
+		tracked, ok := chain.hashes[height]
+		if !ok {
+			break
+		}
+
+		canonical, err := p.canonicalHash(ctx, header, number, height)
+		if err != nil {
+			return fmt.Errorf("failed to get canonical hash of block %d: %w", height, err)
+		} else if canonical == tracked {
+			break
+		}
+
+		orphaned = append(orphaned, height)
+	}
+
+	if len(orphaned) == 0 {
+		return nil
+	}
+
+	forkBlock := orphaned[len(orphaned)-1] - 1
+	log.Warn("chain reorganisation detected", "forkBlock", forkBlock, "orphanedBlocks", len(orphaned))
+
+	for _, height := range orphaned {
+		if err := p.storage.RemoveTransactionsInBlock(chain.hashes[height]); err != nil {
+			return fmt.Errorf("failed to remove transactions of orphaned block %d: %w", height, err)
+		}
+	}
+
+	chain.truncate(forkBlock)
+	return nil
+}
+
+// canonicalHash returns the hash of the block at the given height on the branch of the new header
+func (p *EthereumParser) canonicalHash(ctx context.Context, header Header, number, height uint64) (string, error) {
+	switch {
+	case height > number:
+		// The new head is lower, the blocks above it are no longer part of the chain
+		return "", nil
+	case height == number:
+		return header.Hash, nil
+	case height == number-1:
+		return header.ParentHash, nil
+	}
+
+	block, err := p.rpcCaller.GetBlockByNumber(ctx, height)
+	if err != nil {
+		return "", err
+	}
+
+	return block.Hash, nil
+}
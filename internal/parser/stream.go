@@ -0,0 +1,148 @@
+package parser
+
+import (
+	"fmt"
+	"sync"
+
+	addresspkg "github.com/HomayoonAlimohammadi/blockchain-parser/pkg/eth/address"
+)
+
+const (
+	// streamBacklog is the number of recent events kept per address so listeners can resume after a disconnect
+	streamBacklog = 256
+	// listenerBuffer is how many events a listener can fall behind before it is dropped
+	listenerBuffer = 64
+)
+
+// StreamEvent is an event published to the live listeners of an address
+type StreamEvent struct {
+	// ID increases with every published event, listeners resume after the last ID they saw
+	ID uint64 `json:"id"`
+	Event
+}
+
+// streamHub fans out the events of every address to its live listeners
+type streamHub struct {
+	mu     sync.Mutex
+	lastID uint64
+	// backlogs holds the last streamBacklog events of each address, oldest first
+	backlogs  map[string][]StreamEvent
+	listeners map[string]map[*listener]struct{}
+}
+
+// listener receives the events of an address until it is closed
+type listener struct {
+	events chan StreamEvent
+	closed bool
+}
+
+// newStreamHub creates a hub without listeners
+func newStreamHub() *streamHub {
+	return &streamHub{
+		backlogs:  make(map[string][]StreamEvent),
+		listeners: make(map[string]map[*listener]struct{}),
+	}
+}
+
+// publish sends an event to the listeners of its address. A listener too slow to keep up is
+// closed rather than blocking the parser, it can reconnect and resume from its last event.
+func (h *streamHub) publish(event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastID++
+	streamEvent := StreamEvent{ID: h.lastID, Event: event}
+
+	backlog := append(h.backlogs[event.Address], streamEvent)
+	if len(backlog) > streamBacklog {
+		backlog = backlog[len(backlog)-streamBacklog:]
+	}
+	h.backlogs[event.Address] = backlog
+
+	for l := range h.listeners[event.Address] {
+		select {
+		case l.events <- streamEvent:
+		default:
+			h.removeLocked(event.Address, l)
+		}
+	}
+}
+
+// listen registers a listener of an address, the backlogged events after lastEventID are replayed first
+func (h *streamHub) listen(address string, lastEventID uint64) (<-chan StreamEvent, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var replay []StreamEvent
+	for _, event := range h.backlogs[address] {
+		if event.ID > lastEventID {
+			replay = append(replay, event)
+		}
+	}
+
+	l := &listener{events: make(chan StreamEvent, len(replay)+listenerBuffer)}
+	for _, event := range replay {
+		l.events <- event
+	}
+
+	if h.listeners[address] == nil {
+		h.listeners[address] = make(map[*listener]struct{})
+	}
+	h.listeners[address][l] = struct{}{}
+
+	return l.events, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		h.removeLocked(address, l)
+	}
+}
+
+// close closes the listeners of an address and forgets its backlog
+func (h *streamHub) close(address string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for l := range h.listeners[address] {
+		h.removeLocked(address, l)
+	}
+	delete(h.backlogs, address)
+}
+
+// removeLocked closes a listener, h.mu must be held
+func (h *streamHub) removeLocked(address string, l *listener) {
+	if l.closed {
+		return
+	}
+
+	l.closed = true
+	close(l.events)
+
+	delete(h.listeners[address], l)
+	if len(h.listeners[address]) == 0 {
+		delete(h.listeners, address)
+	}
+}
+
+// Stream returns the live events of a subscribed address, replaying the recent ones after lastEventID.
+// The channel is closed when the address is unsubscribed or the listener falls behind, the returned
+// function stops listening.
+func (p *EthereumParser) Stream(address string, lastEventID uint64) (<-chan StreamEvent, func(), error) {
+	address, err := addresspkg.Normalize(address)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The listener is registered before checking the subscription, as Unsubscribe removes the address
+	// before closing its listeners: a concurrent unsubscription either fails the check or closes the listener
+	events, stop := p.hub.listen(address, lastEventID)
+	if subscribed, err := p.isAlreadySubscribed(address); err != nil {
+		stop()
+		return nil, nil, fmt.Errorf("failed to check if address %q is subscribed: %w", address, err)
+	} else if !subscribed {
+		stop()
+		return nil, nil, fmt.Errorf("address %q: %w", address, ErrNotSubscribed)
+	}
+
+	return events, stop, nil
+}
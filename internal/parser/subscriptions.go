@@ -0,0 +1,125 @@
+package parser
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/eth/quantity"
+)
+
+// ConnectionState is the state of the live stream of a subscribed address
+type ConnectionState string
+
+const (
+	// ConnectionStateConnected means logs are being received live
+	ConnectionStateConnected ConnectionState = "connected"
+	// ConnectionStateReconnecting means the connection dropped and is being re-established
+	ConnectionStateReconnecting ConnectionState = "reconnecting"
+	// ConnectionStateDisconnected means there is no live stream for the address
+	ConnectionStateDisconnected ConnectionState = "disconnected"
+)
+
+// SubscriptionStatus describes a subscribed address and what the parser collected for it
+type SubscriptionStatus struct {
+	Address          string          `json:"address"`
+	SubscribedAt     time.Time       `json:"subscribedAt"`
+	LastEventBlock   uint64          `json:"lastEventBlock"`
+	TransactionCount int             `json:"transactionCount"`
+	LogCount         int             `json:"logCount"`
+	ConnectionState  ConnectionState `json:"connectionState"`
+	// Filter is the log filter of the address, nil if it observes every log of the address only
+	Filter *LogFilter `json:"filter,omitempty"`
+	// Backfill is the progress of the backfill of the address since it was last watched, nil if there was none
+	Backfill *BackfillProgress `json:"backfill,omitempty"`
+}
+
+// GetSubscriptions returns the status of every subscribed address, sorted by address
+func (p *EthereumParser) GetSubscriptions() ([]SubscriptionStatus, error) {
+	activeAddrs, err := p.storage.GetActiveAddresses()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get active addresses: %w", err)
+	}
+
+	statuses := make([]SubscriptionStatus, 0, len(activeAddrs))
+	for address := range activeAddrs {
+		status, err := p.subscriptionStatus(address)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get status of address %q: %w", address, err)
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	slices.SortFunc(statuses, func(a, b SubscriptionStatus) int {
+		return strings.Compare(a.Address, b.Address)
+	})
+
+	return statuses, nil
+}
+
+// subscriptionStatus returns the status of a subscribed address
+func (p *EthereumParser) subscriptionStatus(address string) (SubscriptionStatus, error) {
+	subscribedAt, err := p.storage.GetSubscribedAt(address)
+	if err != nil {
+		return SubscriptionStatus{}, fmt.Errorf("failed to get subscription time: %w", err)
+	}
+
+	txnCount, err := p.storage.CountTransactionsFor(address)
+	if err != nil {
+		return SubscriptionStatus{}, fmt.Errorf("failed to count transactions: %w", err)
+	}
+
+	logCount, err := p.storage.CountLogsFor(address)
+	if err != nil {
+		return SubscriptionStatus{}, fmt.Errorf("failed to count logs: %w", err)
+	}
+
+	// A malformed block number is reported as 0 rather than failing the whole listing
+	var lastEventBlock uint64
+	lastTxn, err := p.storage.GetLatestTransactionFor(address)
+	if err != nil {
+		return SubscriptionStatus{}, fmt.Errorf("failed to get latest transaction: %w", err)
+	} else if lastTxn != nil {
+		lastEventBlock, _ = quantity.ParseHex(lastTxn.BlockNumber)
+	}
+
+	lastLog, err := p.storage.GetLatestLogFor(address)
+	if err != nil {
+		return SubscriptionStatus{}, fmt.Errorf("failed to get latest log: %w", err)
+	} else if lastLog != nil {
+		lastLogBlock, _ := quantity.ParseHex(lastLog.BlockNumber)
+		lastEventBlock = max(lastEventBlock, lastLogBlock)
+	}
+
+	filter, err := p.storage.GetFilter(address)
+	if err != nil {
+		return SubscriptionStatus{}, fmt.Errorf("failed to get filter: %w", err)
+	}
+
+	p.mu.Lock()
+	w, watched := p.watchers[address]
+	var backfill *BackfillProgress
+	if watched && w.backfill != nil {
+		progress := *w.backfill
+		backfill = &progress
+	}
+	p.mu.Unlock()
+
+	state := ConnectionStateDisconnected
+	if watched {
+		state = p.rpcCaller.ConnectionState(address)
+	}
+
+	return SubscriptionStatus{
+		Address:          address,
+		SubscribedAt:     subscribedAt,
+		LastEventBlock:   lastEventBlock,
+		TransactionCount: txnCount,
+		LogCount:         logCount,
+		ConnectionState:  state,
+		Filter:           filter,
+		Backfill:         backfill,
+	}, nil
+}
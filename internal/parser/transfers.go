@@ -0,0 +1,202 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/eth/quantity"
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/log"
+)
+
+// maxCatchUpBlocks bounds the number of skipped blocks fetched when a header arrives past a gap
+const maxCatchUpBlocks = 128
+
+// TrackTransfers subscribes to new blocks and records every transaction sent from or to a
+// subscribed address, covering plain ETH transfers that emit no logs.
+// It returns once the subscription is made, blocks are processed in the background.
+func (p *EthereumParser) TrackTransfers(ctx context.Context) error {
+	heads, err := p.rpcCaller.SubscribeNewHeads(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to new heads: %w", err)
+	}
+
+	go p.watchHeads(context.Background(), heads)
+	return nil
+}
+
+// watchHeads processes the block of every header received, along with the blocks
+// skipped since the previous header, such as the ones missed while reconnecting
+func (p *EthereumParser) watchHeads(ctx context.Context, heads <-chan Header) {
+	log.Info("watching for new blocks...")
+
+	chain := newCanonicalChain()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("stopped watching for new blocks")
+			return
+		case header, ok := <-heads:
+			if !ok {
+				log.Info("new heads channel closed")
+				return
+			}
+
+			number, err := quantity.ParseHex(header.Number)
+			if err != nil {
+				log.Error(err, "failed to parse header block number", "header", header)
+				continue
+			}
+
+			if hash, ok := chain.hashes[number]; ok && hash == header.Hash {
+				continue
+			}
+
+			if err := p.rollbackReorg(ctx, chain, header, number); err != nil {
+				log.Error(err, "failed to check for chain reorganisation", "header", header)
+			}
+
+			fromBlock := number
+			if chain.head != 0 && number > chain.head {
+				fromBlock = chain.head + 1
+				if number-fromBlock >= maxCatchUpBlocks {
+					fromBlock = number - maxCatchUpBlocks + 1
+				}
+			}
+
+			// The head only moves past the blocks processed, the ones from the block that failed are
+			// processed again along with the next header
+			hashes, err := p.processBlocks(ctx, fromBlock, number)
+			if err != nil {
+				log.Error(err, "failed to process blocks, retrying with the next header", "fromBlock", fromBlock, "toBlock", number)
+				if chain.head == 0 && len(hashes) == 0 {
+					chain.head = fromBlock - 1
+				}
+			}
+			for i, hash := range hashes {
+				chain.add(fromBlock+uint64(i), hash)
+			}
+		}
+	}
+}
+
+// processBlocks fetches a range of blocks in batches and stores their transactions sent from or to a
+// subscribed address. It returns the hashes of the processed blocks in order, an empty one for every
+// block there was nothing to process. It stops at the first block failing to be fetched or processed,
+// the hashes then covering only the blocks before it.
+func (p *EthereumParser) processBlocks(ctx context.Context, fromBlock, toBlock uint64) ([]string, error) {
+	hashes := make([]string, toBlock-fromBlock+1)
+
+	activeAddrs, err := p.storage.GetActiveAddresses()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get active addresses: %w", err)
+	} else if len(activeAddrs) == 0 {
+		return hashes, nil
+	}
+
+	// Addresses are compared case-insensitively as nodes may return them checksummed
+	subscribed := make(map[string]string, len(activeAddrs))
+	for address := range activeAddrs {
+		subscribed[strings.ToLower(address)] = address
+	}
+
+	numbers := make([]uint64, len(hashes))
+	for i := range numbers {
+		numbers[i] = fromBlock + uint64(i)
+	}
+
+	blocks, errs, err := p.rpcCaller.GetBlocksByNumber(ctx, numbers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blocks %d to %d: %w", fromBlock, toBlock, err)
+	}
+
+	// The blocks that failed, such as the latest ones when a node lags behind the others, are fetched once more
+	var failed []int
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, i)
+		}
+	}
+	if len(failed) > 0 {
+		retryNumbers := make([]uint64, len(failed))
+		for j, i := range failed {
+			retryNumbers[j] = numbers[i]
+		}
+
+		retryBlocks, retryErrs, err := p.rpcCaller.GetBlocksByNumber(ctx, retryNumbers)
+		if err != nil {
+			log.Error(err, "failed to get blocks again", "blocks", retryNumbers)
+		} else {
+			for j, i := range failed {
+				blocks[i], errs[i] = retryBlocks[j], retryErrs[j]
+			}
+		}
+	}
+
+	for i, block := range blocks {
+		if errs[i] != nil {
+			return hashes[:i], errs[i]
+		}
+
+		if err := p.processBlock(ctx, subscribed, block); err != nil {
+			return hashes[:i], fmt.Errorf("failed to process block %d: %w", numbers[i], err)
+		}
+		hashes[i] = block.Hash
+	}
+
+	return hashes, nil
+}
+
+// processBlock stores the transactions of a block sent from or to a subscribed address,
+// subscribed mapping the lowercase addresses to the subscribed ones
+func (p *EthereumParser) processBlock(ctx context.Context, subscribed map[string]string, block *Block) error {
+	var matched []Transaction
+	for _, txn := range block.Transactions {
+		_, fromOK := subscribed[strings.ToLower(txn.From)]
+		// Contract creations have no recipient
+		_, toOK := subscribed[strings.ToLower(txn.To)]
+		if fromOK || (toOK && txn.To != "") {
+			matched = append(matched, txn)
+		}
+	}
+
+	if len(matched) == 0 {
+		return nil
+	}
+
+	hashes := make([]string, len(matched))
+	for i, txn := range matched {
+		hashes[i] = txn.Hash
+	}
+
+	receipts, err := p.rpcCaller.GetTransactionReceipts(ctx, hashes)
+	if err != nil {
+		return fmt.Errorf("failed to get receipts of block %q: %w", block.Hash, err)
+	}
+
+	for i, txn := range matched {
+		txn.Status = receipts[i].Status
+		txn.GasUsed = receipts[i].GasUsed
+
+		from, fromOK := subscribed[strings.ToLower(txn.From)]
+		to, toOK := subscribed[strings.ToLower(txn.To)]
+		toOK = toOK && txn.To != ""
+
+		if fromOK {
+			if err := p.storage.AddTransactionFor(from, txn); err != nil {
+				return fmt.Errorf("failed to add transaction for address %q: %w", from, err)
+			}
+			p.notify(Event{Address: from, Transaction: &txn})
+		}
+
+		// Self transfers are stored once
+		if toOK && !(fromOK && to == from) {
+			if err := p.storage.AddTransactionFor(to, txn); err != nil {
+				return fmt.Errorf("failed to add transaction for address %q: %w", to, err)
+			}
+			p.notify(Event{Address: to, Transaction: &txn})
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,169 @@
+package parser
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/log"
+)
+
+// SubscribeOptions are the optional settings of a subscription
+type SubscribeOptions struct {
+	// CallbackURL receives a signed POST for every new transaction and log of the address
+	CallbackURL string `json:"callbackUrl"`
+	// CallbackSecret is the HMAC key the callback payloads are signed with
+	CallbackSecret string `json:"callbackSecret"`
+	// Addresses are other contracts whose logs are observed along with the ones of the address
+	Addresses []string `json:"addresses,omitempty"`
+	// Topics only observes the logs matching the topics allowed at each position, an empty position
+	// allowing any topic
+	Topics [][]string `json:"topics,omitempty"`
+	// FromBlock backfills the logs emitted since the given block in the background before watching live,
+	// only the logs emitted after subscribing are observed if nil
+	FromBlock *uint64 `json:"fromBlock,omitempty"`
+}
+
+// Validate checks that the addresses and topic filters are well formed, and that a callback, if any,
+// has an absolute HTTP URL and a secret to sign with
+func (o SubscribeOptions) Validate() error {
+	if err := validateFilter(o.Addresses, o.Topics); err != nil {
+		return err
+	}
+
+	if o.CallbackURL == "" {
+		if o.CallbackSecret != "" {
+			return fmt.Errorf("callback secret set without a callback URL")
+		}
+		return nil
+	}
+
+	u, err := url.Parse(o.CallbackURL)
+	if err != nil {
+		return fmt.Errorf("invalid callback URL: %w", err)
+	} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid callback URL %q, expected an absolute http or https URL", o.CallbackURL)
+	}
+
+	if o.CallbackSecret == "" {
+		return fmt.Errorf("missing callback secret")
+	}
+
+	return nil
+}
+
+// Callback is where the events of a subscribed address are delivered
+type Callback struct {
+	URL    string
+	Secret string
+}
+
+// Event is the payload delivered to callbacks, holding either a transaction or a log
+type Event struct {
+	Address     string       `json:"address"`
+	Transaction *Transaction `json:"transaction,omitempty"`
+	Log         *Log         `json:"log,omitempty"`
+}
+
+// DeadLetter is an event delivery given up on after exhausting its retries
+type DeadLetter struct {
+	ID          int64     `json:"id"`
+	Address     string    `json:"address"`
+	CallbackURL string    `json:"callbackUrl"`
+	Event       Event     `json:"event"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"lastError"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Notifier delivers the events of subscribed addresses to their callbacks
+type Notifier interface {
+	// Notify queues an event for delivery to a callback
+	Notify(callback Callback, event Event) error
+	// DeadLetters returns the deliveries given up on
+	DeadLetters() ([]DeadLetter, error)
+}
+
+// SetNotifier sets where the events of the addresses subscribed with a callback are sent to
+func (p *EthereumParser) SetNotifier(notifier Notifier) {
+	p.notifier = notifier
+}
+
+// GetDeadLetters returns the event deliveries given up on after exhausting their retries
+func (p *EthereumParser) GetDeadLetters() ([]DeadLetter, error) {
+	if p.notifier == nil {
+		return nil, nil
+	}
+
+	deadLetters, err := p.notifier.DeadLetters()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dead letters: %w", err)
+	}
+
+	return deadLetters, nil
+}
+
+// setCallback sets where the new records of an address are delivered
+func (p *EthereumParser) setCallback(address string, callback Callback) error {
+	if err := p.storage.SetCallback(address, callback); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.callbacks[address] = callback
+	p.mu.Unlock()
+
+	return nil
+}
+
+// loadCallback loads the stored callback of an address so its new records are delivered
+func (p *EthereumParser) loadCallback(address string) error {
+	callback, err := p.storage.GetCallback(address)
+	if err != nil {
+		return fmt.Errorf("failed to get callback: %w", err)
+	} else if callback == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	p.callbacks[address] = *callback
+	p.mu.Unlock()
+
+	return nil
+}
+
+// removeCallback forgets the callback of an address
+func (p *EthereumParser) removeCallback(address string) error {
+	p.mu.Lock()
+	delete(p.callbacks, address)
+	p.mu.Unlock()
+
+	return p.storage.RemoveCallback(address)
+}
+
+// notify publishes a newly stored record of an address to its live listeners
+// and queues it for delivery to its callback, if it has one
+func (p *EthereumParser) notify(event Event) {
+	if event.Log != nil {
+		entry := *event.Log
+		decodeLog(&entry)
+		event.Log = &entry
+	}
+
+	p.hub.publish(event)
+
+	if p.notifier == nil {
+		return
+	}
+
+	p.mu.Lock()
+	callback, ok := p.callbacks[event.Address]
+	p.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	if err := p.notifier.Notify(callback, event); err != nil {
+		log.Error(err, "failed to queue event for callback", "address", event.Address)
+	}
+}
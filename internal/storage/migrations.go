@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// migrations holds the SQLite schema changes in the order they must be applied.
+// The schema version of a database is the number of migrations applied to it,
+// so existing entries must never be edited or reordered, only appended to.
+var migrations = []string{
+	// 1: active addresses and the transactions observed for them
+	`CREATE TABLE active_addresses (
+		address TEXT PRIMARY KEY
+	);
+	CREATE TABLE transactions (
+		id                INTEGER PRIMARY KEY AUTOINCREMENT,
+		address           TEXT NOT NULL,
+		contract_address  TEXT NOT NULL,
+		block_hash        TEXT NOT NULL,
+		block_number      TEXT NOT NULL,
+		data              TEXT NOT NULL,
+		log_index         TEXT NOT NULL,
+		topics            TEXT NOT NULL,
+		transaction_hash  TEXT NOT NULL,
+		transaction_index TEXT NOT NULL
+	);
+	CREATE INDEX transactions_address_idx ON transactions (address);`,
+	// 2: the last block processed per address, used to backfill gaps after restarts
+	`CREATE TABLE last_processed_blocks (
+		address      TEXT PRIMARY KEY,
+		block_number INTEGER NOT NULL
+	);`,
+	// 3: when each address was subscribed, unknown for the addresses subscribed before
+	`ALTER TABLE active_addresses ADD COLUMN subscribed_at INTEGER;`,
+	// 4: sender, recipient and value of the transfers recorded while tracking blocks
+	`ALTER TABLE transactions ADD COLUMN from_address TEXT NOT NULL DEFAULT '';
+	ALTER TABLE transactions ADD COLUMN to_address TEXT NOT NULL DEFAULT '';
+	ALTER TABLE transactions ADD COLUMN value TEXT NOT NULL DEFAULT '';`,
+	// 5: logs move to their own table, transactions keep the transfers only with their full fields
+	`CREATE TABLE logs (
+		id                INTEGER PRIMARY KEY AUTOINCREMENT,
+		address           TEXT NOT NULL,
+		contract_address  TEXT NOT NULL,
+		block_hash        TEXT NOT NULL,
+		block_number      TEXT NOT NULL,
+		data              TEXT NOT NULL,
+		log_index         TEXT NOT NULL,
+		topics            TEXT NOT NULL,
+		transaction_hash  TEXT NOT NULL,
+		transaction_index TEXT NOT NULL
+	);
+	INSERT INTO logs (
+		address, contract_address, block_hash, block_number, data,
+		log_index, topics, transaction_hash, transaction_index
+	) SELECT
+		address, contract_address, block_hash, block_number, data,
+		log_index, topics, transaction_hash, transaction_index
+	FROM transactions WHERE log_index != '' ORDER BY id;
+	CREATE INDEX logs_address_idx ON logs (address);
+	CREATE TABLE transfers (
+		id                INTEGER PRIMARY KEY AUTOINCREMENT,
+		address           TEXT NOT NULL,
+		hash              TEXT NOT NULL,
+		block_hash        TEXT NOT NULL,
+		block_number      TEXT NOT NULL,
+		transaction_index TEXT NOT NULL,
+		from_address      TEXT NOT NULL,
+		to_address        TEXT NOT NULL,
+		value             TEXT NOT NULL,
+		gas               TEXT NOT NULL DEFAULT '',
+		gas_price         TEXT NOT NULL DEFAULT '',
+		nonce             TEXT NOT NULL DEFAULT '',
+		input             TEXT NOT NULL,
+		status            TEXT NOT NULL DEFAULT '',
+		gas_used          TEXT NOT NULL DEFAULT ''
+	);
+	INSERT INTO transfers (
+		address, hash, block_hash, block_number, transaction_index,
+		from_address, to_address, value, input
+	) SELECT
+		address, transaction_hash, block_hash, block_number, transaction_index,
+		from_address, to_address, value, data
+	FROM transactions WHERE log_index = '' ORDER BY id;
+	DROP TABLE transactions;
+	ALTER TABLE transfers RENAME TO transactions;
+	CREATE INDEX transactions_address_idx ON transactions (address);`,
+	// 6: lookups by block hash, to roll back the blocks orphaned by chain reorganisations
+	`CREATE INDEX transactions_block_hash_idx ON transactions (block_hash);
+	CREATE INDEX logs_block_hash_idx ON logs (block_hash);`,
+	// 7: webhook callbacks per address and the durable queue of their deliveries
+	`CREATE TABLE callbacks (
+		address TEXT PRIMARY KEY,
+		url     TEXT NOT NULL,
+		secret  TEXT NOT NULL
+	);
+	CREATE TABLE webhook_deliveries (
+		id              INTEGER PRIMARY KEY AUTOINCREMENT,
+		address         TEXT NOT NULL,
+		url             TEXT NOT NULL,
+		secret          TEXT NOT NULL,
+		payload         BLOB NOT NULL,
+		attempts        INTEGER NOT NULL,
+		next_attempt_at INTEGER NOT NULL,
+		last_error      TEXT NOT NULL,
+		created_at      INTEGER NOT NULL,
+		dead            INTEGER NOT NULL
+	);
+	CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (dead, next_attempt_at);`,
+	// 8: integer block heights and positions, the hex strings sort and compare as text,
+	// so the records can be paginated and filtered by block
+	`ALTER TABLE transactions ADD COLUMN block_height INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE transactions ADD COLUMN position INTEGER NOT NULL DEFAULT 0;
+	UPDATE transactions SET
+		block_height = ` + hexToInteger("transactions.block_number") + `,
+		position = ` + hexToInteger("transactions.transaction_index") + `;
+	CREATE INDEX transactions_address_position_idx ON transactions (address, block_height, position);
+	ALTER TABLE logs ADD COLUMN block_height INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE logs ADD COLUMN position INTEGER NOT NULL DEFAULT 0;
+	UPDATE logs SET
+		block_height = ` + hexToInteger("logs.block_number") + `,
+		position = ` + hexToInteger("logs.log_index") + `;
+	CREATE INDEX logs_address_position_idx ON logs (address, block_height, position);`,
+	// 9: contract ABIs per address and the logs decoded with them, as JSON
+	`CREATE TABLE abis (
+		address TEXT PRIMARY KEY,
+		abi     TEXT NOT NULL
+	);
+	ALTER TABLE logs ADD COLUMN decoded TEXT NOT NULL DEFAULT '';`,
+	// 10: log filters of the subscriptions observing other addresses or only some topics, as JSON
+	`CREATE TABLE filters (
+		address   TEXT PRIMARY KEY,
+		addresses TEXT NOT NULL,
+		topics    TEXT NOT NULL
+	);`,
+	// 11: addresses are keyed by their lowercase form, the rows of an address subscribed
+	// in several cases keep the one already lowercase
+	`UPDATE OR IGNORE active_addresses SET address = lower(address);
+	DELETE FROM active_addresses WHERE address != lower(address);
+	UPDATE OR IGNORE last_processed_blocks SET address = lower(address);
+	DELETE FROM last_processed_blocks WHERE address != lower(address);
+	UPDATE OR IGNORE callbacks SET address = lower(address);
+	DELETE FROM callbacks WHERE address != lower(address);
+	UPDATE OR IGNORE abis SET address = lower(address);
+	DELETE FROM abis WHERE address != lower(address);
+	UPDATE OR IGNORE filters SET address = lower(address), addresses = lower(addresses);
+	DELETE FROM filters WHERE address != lower(address);
+	UPDATE transactions SET address = lower(address);
+	UPDATE logs SET address = lower(address), contract_address = lower(contract_address);
+	UPDATE webhook_deliveries SET address = lower(address);`,
+	// 12: the callback secrets are only kept with the callbacks, the deliveries are signed
+	// with the secret looked up when they are sent
+	`ALTER TABLE webhook_deliveries DROP COLUMN secret;`,
+}
+
+// hexToInteger returns an SQL expression converting a 0x prefixed hex column to an integer,
+// SQLite has no built-in function for it
+func hexToInteger(column string) string {
+	return fmt.Sprintf(`(WITH RECURSIVE digits(i, value) AS (
+		SELECT 3, 0
+		UNION ALL
+		SELECT i + 1, value * 16 + instr('0123456789abcdef', lower(substr(%[1]s, i, 1))) - 1
+		FROM digits WHERE i <= length(%[1]s)
+	) SELECT value FROM digits ORDER BY i DESC LIMIT 1)`, column)
+}
+
+// migrate brings the database schema up to date with the latest migration
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	if version > len(migrations) {
+		return fmt.Errorf("schema version %d is newer than the latest known version %d", version, len(migrations))
+	}
+
+	for i := version; i < len(migrations); i++ {
+		if err := applyMigration(db, i+1, migrations[i]); err != nil {
+			return fmt.Errorf("failed to apply migration %d: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+// applyMigration runs a single migration and bumps the schema version in one transaction
+func applyMigration(db *sql.DB, version int, stmt string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(stmt); err != nil {
+		return fmt.Errorf("failed to execute migration: %w", err)
+	}
+
+	// PRAGMA statements do not support placeholders
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version)); err != nil {
+		return fmt.Errorf("failed to set schema version: %w", err)
+	}
+
+	return tx.Commit()
+}
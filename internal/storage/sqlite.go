@@ -0,0 +1,629 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/HomayoonAlimohammadi/blockchain-parser/internal/parser"
+	"github.com/HomayoonAlimohammadi/blockchain-parser/internal/webhook"
+)
+
+// NewSQLite opens (or creates) the SQLite database at path and migrates it to the latest schema
+func NewSQLite(path string) (*sqlite, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_busy_timeout=5000&_journal_mode=WAL", path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %q: %w", path, err)
+	}
+
+	// SQLite allows a single writer, serializing access avoids "database is locked" errors
+	db.SetMaxOpenConns(1)
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate database %q: %w", path, err)
+	}
+
+	return &sqlite{db: db}, nil
+}
+
+// sqlite is a persistent storage backed by an SQLite database file
+type sqlite struct {
+	db *sql.DB
+}
+
+// Close closes the underlying database
+func (s *sqlite) Close() error {
+	return s.db.Close()
+}
+
+// AddTransactionFor adds a transaction for a given address
+func (s *sqlite) AddTransactionFor(address string, txn parser.Transaction) error {
+	position := txn.Position()
+	_, err := s.db.Exec(`INSERT INTO transactions (
+		address, hash, block_hash, block_number, transaction_index,
+		from_address, to_address, value, gas, gas_price,
+		nonce, input, status, gas_used, block_height, position
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		address, txn.Hash, txn.BlockHash, txn.BlockNumber, txn.TransactionIndex,
+		txn.From, txn.To, txn.Value, txn.Gas, txn.GasPrice,
+		txn.Nonce, txn.Input, txn.Status, txn.GasUsed, int64(position.Block), int64(position.Index),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert transaction for address %q: %w", address, err)
+	}
+
+	return nil
+}
+
+// RemoveTransactionsFor removes all the transactions of a given address
+func (s *sqlite) RemoveTransactionsFor(address string) error {
+	if _, err := s.db.Exec("DELETE FROM transactions WHERE address = ?", address); err != nil {
+		return fmt.Errorf("failed to delete transactions for address %q: %w", address, err)
+	}
+
+	return nil
+}
+
+// CountTransactionsFor returns the number of transactions stored for a given address
+func (s *sqlite) CountTransactionsFor(address string) (int, error) {
+	var count int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM transactions WHERE address = ?", address).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count transactions for address %q: %w", address, err)
+	}
+
+	return count, nil
+}
+
+// GetLatestTransactionFor returns the transaction of a given address at the highest block and position
+func (s *sqlite) GetLatestTransactionFor(address string) (*parser.Transaction, error) {
+	txns, err := s.queryTransactions("WHERE address = ? ORDER BY block_height DESC, position DESC, id DESC LIMIT 1", address)
+	if err != nil {
+		return nil, err
+	} else if len(txns) == 0 {
+		return nil, nil
+	}
+
+	return &txns[0], nil
+}
+
+// GetTransactionsFor returns the transactions for a given address
+func (s *sqlite) GetTransactionsFor(address string) ([]parser.Transaction, error) {
+	return s.queryTransactions("WHERE address = ? ORDER BY id", address)
+}
+
+// QueryTransactionsFor returns the transactions for a given address selected by the query, in its order
+func (s *sqlite) QueryTransactionsFor(address string, query parser.Query) ([]parser.Transaction, error) {
+	clauses, args, err := queryClauses(address, parser.Query{
+		FromBlock: query.FromBlock,
+		ToBlock:   query.ToBlock,
+		Order:     query.Order,
+		Cursor:    query.Cursor,
+		Limit:     query.Limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.queryTransactions(clauses, args...)
+}
+
+// queryTransactions returns the transactions selected by the given clauses
+func (s *sqlite) queryTransactions(clauses string, args ...any) ([]parser.Transaction, error) {
+	rows, err := s.db.Query(`SELECT
+		hash, block_hash, block_number, transaction_index,
+		from_address, to_address, value, gas, gas_price,
+		nonce, input, status, gas_used
+	FROM transactions `+clauses, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transactions: %w", err)
+	}
+	defer rows.Close()
+
+	var txns []parser.Transaction
+	for rows.Next() {
+		var txn parser.Transaction
+		if err := rows.Scan(
+			&txn.Hash, &txn.BlockHash, &txn.BlockNumber, &txn.TransactionIndex,
+			&txn.From, &txn.To, &txn.Value, &txn.Gas, &txn.GasPrice,
+			&txn.Nonce, &txn.Input, &txn.Status, &txn.GasUsed,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan transaction: %w", err)
+		}
+
+		txns = append(txns, txn)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate transactions: %w", err)
+	}
+
+	return txns, nil
+}
+
+// AddLogFor adds a log for a given address
+func (s *sqlite) AddLogFor(address string, entry parser.Log) error {
+	topics, err := json.Marshal(entry.Topics)
+	if err != nil {
+		return fmt.Errorf("failed to marshal topics: %w", err)
+	}
+
+	var decoded []byte
+	if entry.Decoded != nil {
+		if decoded, err = json.Marshal(entry.Decoded); err != nil {
+			return fmt.Errorf("failed to marshal decoded log: %w", err)
+		}
+	}
+
+	position := entry.Position()
+	_, err = s.db.Exec(`INSERT INTO logs (
+		address, contract_address, block_hash, block_number, data,
+		log_index, topics, transaction_hash, transaction_index, block_height,
+		position, decoded
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		address, entry.Address, entry.BlockHash, entry.BlockNumber, entry.Data,
+		entry.LogIndex, string(topics), entry.TransactionHash, entry.TransactionIndex, int64(position.Block),
+		int64(position.Index), string(decoded),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert log for address %q: %w", address, err)
+	}
+
+	return nil
+}
+
+// RemoveLogsFor removes all the logs of a given address
+func (s *sqlite) RemoveLogsFor(address string) error {
+	if _, err := s.db.Exec("DELETE FROM logs WHERE address = ?", address); err != nil {
+		return fmt.Errorf("failed to delete logs for address %q: %w", address, err)
+	}
+
+	return nil
+}
+
+// CountLogsFor returns the number of logs stored for a given address
+func (s *sqlite) CountLogsFor(address string) (int, error) {
+	var count int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM logs WHERE address = ?", address).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count logs for address %q: %w", address, err)
+	}
+
+	return count, nil
+}
+
+// GetLatestLogFor returns the log of a given address at the highest block and position
+func (s *sqlite) GetLatestLogFor(address string) (*parser.Log, error) {
+	logs, err := s.queryLogs("WHERE address = ? ORDER BY block_height DESC, position DESC, id DESC LIMIT 1", address)
+	if err != nil {
+		return nil, err
+	} else if len(logs) == 0 {
+		return nil, nil
+	}
+
+	return &logs[0], nil
+}
+
+// GetLogsFor returns the logs for a given address
+func (s *sqlite) GetLogsFor(address string) ([]parser.Log, error) {
+	return s.queryLogs("WHERE address = ? ORDER BY id", address)
+}
+
+// QueryLogsFor returns the logs for a given address selected by the query, in its order
+func (s *sqlite) QueryLogsFor(address string, query parser.Query) ([]parser.Log, error) {
+	clauses, args, err := queryClauses(address, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.queryLogs(clauses, args...)
+}
+
+// queryClauses returns the clauses selecting the records of an address matching a query,
+// in the query order, by the block_height and position columns the records are indexed by
+func queryClauses(address string, query parser.Query) (string, []any, error) {
+	after, err := query.After()
+	if err != nil {
+		return "", nil, err
+	}
+
+	conditions := []string{"address = ?"}
+	args := []any{address}
+
+	if query.FromBlock != nil {
+		conditions = append(conditions, "block_height >= ?")
+		args = append(args, int64(*query.FromBlock))
+	}
+	if query.ToBlock != nil {
+		conditions = append(conditions, "block_height <= ?")
+		args = append(args, int64(*query.ToBlock))
+	}
+
+	direction, comparison := "ASC", ">"
+	if query.Order == parser.OrderDesc {
+		direction, comparison = "DESC", "<"
+	}
+	if after != nil {
+		conditions = append(conditions, "(block_height, position) "+comparison+" (?, ?)")
+		args = append(args, int64(after.Block), int64(after.Index))
+	}
+
+	for i, topics := range query.Topics {
+		if len(topics) == 0 {
+			continue
+		}
+
+		placeholders := make([]string, len(topics))
+		for j, topic := range topics {
+			placeholders[j] = "?"
+			args = append(args, strings.ToLower(topic))
+		}
+		conditions = append(conditions, fmt.Sprintf("lower(json_extract(topics, '$[%d]')) IN (%s)", i, strings.Join(placeholders, ", ")))
+	}
+
+	clauses := "WHERE " + strings.Join(conditions, " AND ") +
+		fmt.Sprintf(" ORDER BY block_height %[1]s, position %[1]s, id %[1]s", direction)
+	if query.Limit > 0 {
+		clauses += " LIMIT ?"
+		args = append(args, query.Limit)
+	}
+
+	return clauses, args, nil
+}
+
+// queryLogs returns the logs selected by the given clauses
+func (s *sqlite) queryLogs(clauses string, args ...any) ([]parser.Log, error) {
+	rows, err := s.db.Query(`SELECT
+		contract_address, block_hash, block_number, data,
+		log_index, topics, transaction_hash, transaction_index, decoded
+	FROM logs `+clauses, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query logs: %w", err)
+	}
+	defer rows.Close()
+
+	var logs []parser.Log
+	for rows.Next() {
+		var (
+			entry   parser.Log
+			topics  string
+			decoded string
+		)
+		if err := rows.Scan(
+			&entry.Address, &entry.BlockHash, &entry.BlockNumber, &entry.Data,
+			&entry.LogIndex, &topics, &entry.TransactionHash, &entry.TransactionIndex, &decoded,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan log: %w", err)
+		}
+
+		if err := json.Unmarshal([]byte(topics), &entry.Topics); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal topics: %w", err)
+		}
+
+		if decoded != "" {
+			if err := json.Unmarshal([]byte(decoded), &entry.Decoded); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal decoded log: %w", err)
+			}
+		}
+
+		logs = append(logs, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate logs: %w", err)
+	}
+
+	return logs, nil
+}
+
+// RemoveTransactionsInBlock removes the transactions of every address included in the given block
+func (s *sqlite) RemoveTransactionsInBlock(blockHash string) error {
+	if _, err := s.db.Exec("DELETE FROM transactions WHERE block_hash = ?", blockHash); err != nil {
+		return fmt.Errorf("failed to delete transactions in block %q: %w", blockHash, err)
+	}
+
+	return nil
+}
+
+// RemoveLogsInBlock removes the logs of every address emitted in the given block
+func (s *sqlite) RemoveLogsInBlock(blockHash string) error {
+	if _, err := s.db.Exec("DELETE FROM logs WHERE block_hash = ?", blockHash); err != nil {
+		return fmt.Errorf("failed to delete logs in block %q: %w", blockHash, err)
+	}
+
+	return nil
+}
+
+// AddActiveAddress adds an address to the active list
+func (s *sqlite) AddActiveAddress(address string) error {
+	// Re-adding an active address keeps its original subscription time
+	_, err := s.db.Exec("INSERT OR IGNORE INTO active_addresses (address, subscribed_at) VALUES (?, ?)", address, time.Now().UnixNano())
+	if err != nil {
+		return fmt.Errorf("failed to insert active address %q: %w", address, err)
+	}
+
+	return nil
+}
+
+// GetActiveAddresses returns the set of active addresses
+func (s *sqlite) GetActiveAddresses() (map[string]struct{}, error) {
+	rows, err := s.db.Query("SELECT address FROM active_addresses")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query active addresses: %w", err)
+	}
+	defer rows.Close()
+
+	addrs := make(map[string]struct{})
+	for rows.Next() {
+		var address string
+		if err := rows.Scan(&address); err != nil {
+			return nil, fmt.Errorf("failed to scan active address: %w", err)
+		}
+
+		addrs[address] = struct{}{}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate active addresses: %w", err)
+	}
+
+	return addrs, nil
+}
+
+// GetSubscribedAt returns when an address was added to the active list
+func (s *sqlite) GetSubscribedAt(address string) (time.Time, error) {
+	var subscribedAt sql.NullInt64
+	err := s.db.QueryRow("SELECT subscribed_at FROM active_addresses WHERE address = ?", address).Scan(&subscribedAt)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !subscribedAt.Valid) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, fmt.Errorf("failed to query subscription time of address %q: %w", address, err)
+	}
+
+	return time.Unix(0, subscribedAt.Int64), nil
+}
+
+// RemoveActiveAddress removes an address from the active list
+func (s *sqlite) RemoveActiveAddress(address string) error {
+	if _, err := s.db.Exec("DELETE FROM active_addresses WHERE address = ?", address); err != nil {
+		return fmt.Errorf("failed to delete active address %q: %w", address, err)
+	}
+
+	return nil
+}
+
+// GetLastProcessedBlock returns the last block processed for a given address
+func (s *sqlite) GetLastProcessedBlock(address string) (uint64, error) {
+	var block uint64
+	err := s.db.QueryRow("SELECT block_number FROM last_processed_blocks WHERE address = ?", address).Scan(&block)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to query last processed block for address %q: %w", address, err)
+	}
+
+	return block, nil
+}
+
+// SetLastProcessedBlock records the last block processed for a given address
+func (s *sqlite) SetLastProcessedBlock(address string, block uint64) error {
+	_, err := s.db.Exec(`INSERT INTO last_processed_blocks (address, block_number) VALUES (?, ?)
+		ON CONFLICT (address) DO UPDATE SET block_number = excluded.block_number`, address, block)
+	if err != nil {
+		return fmt.Errorf("failed to set last processed block for address %q: %w", address, err)
+	}
+
+	return nil
+}
+
+// SetCallback sets the callback of a given address
+func (s *sqlite) SetCallback(address string, callback parser.Callback) error {
+	_, err := s.db.Exec(`INSERT INTO callbacks (address, url, secret) VALUES (?, ?, ?)
+		ON CONFLICT (address) DO UPDATE SET url = excluded.url, secret = excluded.secret`, address, callback.URL, callback.Secret)
+	if err != nil {
+		return fmt.Errorf("failed to set callback for address %q: %w", address, err)
+	}
+
+	return nil
+}
+
+// GetCallback returns the callback of a given address
+func (s *sqlite) GetCallback(address string) (*parser.Callback, error) {
+	var callback parser.Callback
+	err := s.db.QueryRow("SELECT url, secret FROM callbacks WHERE address = ?", address).Scan(&callback.URL, &callback.Secret)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to query callback for address %q: %w", address, err)
+	}
+
+	return &callback, nil
+}
+
+// RemoveCallback removes the callback of a given address
+func (s *sqlite) RemoveCallback(address string) error {
+	if _, err := s.db.Exec("DELETE FROM callbacks WHERE address = ?", address); err != nil {
+		return fmt.Errorf("failed to delete callback for address %q: %w", address, err)
+	}
+
+	return nil
+}
+
+// SetFilter sets the log filter of a given address
+func (s *sqlite) SetFilter(address string, filter parser.LogFilter) error {
+	addresses, err := json.Marshal(filter.Addresses)
+	if err != nil {
+		return fmt.Errorf("failed to marshal addresses: %w", err)
+	}
+
+	topics, err := json.Marshal(filter.Topics)
+	if err != nil {
+		return fmt.Errorf("failed to marshal topics: %w", err)
+	}
+
+	_, err = s.db.Exec(`INSERT INTO filters (address, addresses, topics) VALUES (?, ?, ?)
+		ON CONFLICT (address) DO UPDATE SET addresses = excluded.addresses, topics = excluded.topics`, address, string(addresses), string(topics))
+	if err != nil {
+		return fmt.Errorf("failed to set filter for address %q: %w", address, err)
+	}
+
+	return nil
+}
+
+// GetFilter returns the log filter of a given address, or nil if it has none
+func (s *sqlite) GetFilter(address string) (*parser.LogFilter, error) {
+	var addresses, topics string
+	err := s.db.QueryRow("SELECT addresses, topics FROM filters WHERE address = ?", address).Scan(&addresses, &topics)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to query filter for address %q: %w", address, err)
+	}
+
+	var filter parser.LogFilter
+	if err := json.Unmarshal([]byte(addresses), &filter.Addresses); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal addresses: %w", err)
+	}
+	if err := json.Unmarshal([]byte(topics), &filter.Topics); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal topics: %w", err)
+	}
+
+	return &filter, nil
+}
+
+// RemoveFilter removes the log filter of a given address
+func (s *sqlite) RemoveFilter(address string) error {
+	if _, err := s.db.Exec("DELETE FROM filters WHERE address = ?", address); err != nil {
+		return fmt.Errorf("failed to delete filter for address %q: %w", address, err)
+	}
+
+	return nil
+}
+
+// SetABI sets the contract ABI JSON of a given address
+func (s *sqlite) SetABI(address string, abi string) error {
+	_, err := s.db.Exec(`INSERT INTO abis (address, abi) VALUES (?, ?)
+		ON CONFLICT (address) DO UPDATE SET abi = excluded.abi`, address, abi)
+	if err != nil {
+		return fmt.Errorf("failed to set ABI for address %q: %w", address, err)
+	}
+
+	return nil
+}
+
+// GetABI returns the contract ABI JSON of a given address, or an empty string if it has none
+func (s *sqlite) GetABI(address string) (string, error) {
+	var abi string
+	err := s.db.QueryRow("SELECT abi FROM abis WHERE address = ?", address).Scan(&abi)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to query ABI for address %q: %w", address, err)
+	}
+
+	return abi, nil
+}
+
+// RemoveABI removes the contract ABI of a given address
+func (s *sqlite) RemoveABI(address string) error {
+	if _, err := s.db.Exec("DELETE FROM abis WHERE address = ?", address); err != nil {
+		return fmt.Errorf("failed to delete ABI for address %q: %w", address, err)
+	}
+
+	return nil
+}
+
+// AddDelivery queues a new webhook delivery
+func (s *sqlite) AddDelivery(delivery webhook.Delivery) error {
+	_, err := s.db.Exec(`INSERT INTO webhook_deliveries (
+		address, url, payload, attempts,
+		next_attempt_at, last_error, created_at, dead
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		delivery.Address, delivery.URL, delivery.Payload, delivery.Attempts,
+		delivery.NextAttemptAt.UnixNano(), delivery.LastError, delivery.CreatedAt.UnixNano(), delivery.Dead,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert delivery for address %q: %w", delivery.Address, err)
+	}
+
+	return nil
+}
+
+// GetDueDeliveries returns up to limit live deliveries due at now, the earliest first
+func (s *sqlite) GetDueDeliveries(now time.Time, limit int) ([]webhook.Delivery, error) {
+	return s.queryDeliveries("WHERE dead = 0 AND next_attempt_at <= ? ORDER BY next_attempt_at, id LIMIT ?", now.UnixNano(), limit)
+}
+
+// UpdateDelivery records the outcome of a failed webhook delivery attempt
+func (s *sqlite) UpdateDelivery(delivery webhook.Delivery) error {
+	res, err := s.db.Exec(`UPDATE webhook_deliveries SET
+		attempts = ?, next_attempt_at = ?, last_error = ?, dead = ?
+	WHERE id = ?`,
+		delivery.Attempts, delivery.NextAttemptAt.UnixNano(), delivery.LastError, delivery.Dead, delivery.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update delivery %d: %w", delivery.ID, err)
+	}
+
+	if updated, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to update delivery %d: %w", delivery.ID, err)
+	} else if updated == 0 {
+		return fmt.Errorf("delivery %d not found", delivery.ID)
+	}
+
+	return nil
+}
+
+// RemoveDelivery removes a webhook delivery
+func (s *sqlite) RemoveDelivery(id int64) error {
+	if _, err := s.db.Exec("DELETE FROM webhook_deliveries WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete delivery %d: %w", id, err)
+	}
+
+	return nil
+}
+
+// GetDeadDeliveries returns the webhook deliveries that exhausted their attempts, the oldest first
+func (s *sqlite) GetDeadDeliveries() ([]webhook.Delivery, error) {
+	return s.queryDeliveries("WHERE dead = 1 ORDER BY id")
+}
+
+// queryDeliveries returns the webhook deliveries selected by the given clauses
+func (s *sqlite) queryDeliveries(clauses string, args ...any) ([]webhook.Delivery, error) {
+	rows, err := s.db.Query(`SELECT
+		id, address, url, payload, attempts,
+		next_attempt_at, last_error, created_at, dead
+	FROM webhook_deliveries `+clauses, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []webhook.Delivery
+	for rows.Next() {
+		var (
+			delivery                 webhook.Delivery
+			nextAttemptAt, createdAt int64
+		)
+		if err := rows.Scan(
+			&delivery.ID, &delivery.Address, &delivery.URL, &delivery.Payload, &delivery.Attempts,
+			&nextAttemptAt, &delivery.LastError, &createdAt, &delivery.Dead,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan delivery: %w", err)
+		}
+
+		delivery.NextAttemptAt = time.Unix(0, nextAttemptAt)
+		delivery.CreatedAt = time.Unix(0, createdAt)
+		deliveries = append(deliveries, delivery)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate deliveries: %w", err)
+	}
+
+	return deliveries, nil
+}
@@ -1,17 +1,35 @@
 package storage
 
 import (
+	"cmp"
 	"fmt"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/HomayoonAlimohammadi/blockchain-parser/internal/parser"
+	"github.com/HomayoonAlimohammadi/blockchain-parser/internal/webhook"
 )
 
+// Storage is where the parser stores its records and the webhook dispatcher queues its deliveries,
+// implemented by both the in-memory and the SQLite storage
+type Storage interface {
+	parser.Storage
+	webhook.Store
+}
+
 // NewInMemory creates a new in-memory storage
 func NewInMemory() *inMemory {
 	return &inMemory{
 		mu:            &sync.RWMutex{},
 		addressToTxns: make(map[string][]parser.Transaction),
+		addressToLogs: make(map[string][]parser.Log),
+		activeAddrs:   make(map[string]time.Time),
+		lastBlocks:    make(map[string]uint64),
+		callbacks:     make(map[string]parser.Callback),
+		abis:          make(map[string]string),
+		filters:       make(map[string]parser.LogFilter),
+		deliveries:    make(map[int64]webhook.Delivery),
 	}
 }
 
@@ -19,7 +37,15 @@ func NewInMemory() *inMemory {
 type inMemory struct {
 	mu            *sync.RWMutex
 	addressToTxns map[string][]parser.Transaction
-	activeAddrs   map[string]struct{}
+	addressToLogs map[string][]parser.Log
+	activeAddrs   map[string]time.Time
+	lastBlocks    map[string]uint64
+	callbacks     map[string]parser.Callback
+	abis          map[string]string
+	filters       map[string]parser.LogFilter
+	deliveries    map[int64]webhook.Delivery
+	// lastDeliveryID is the ID of the last queued delivery
+	lastDeliveryID int64
 }
 
 // AddTransactionFor adds a transaction for a given address
@@ -31,15 +57,35 @@ func (s *inMemory) AddTransactionFor(address string, txn parser.Transaction) err
 		s.addressToTxns = make(map[string][]parser.Transaction)
 	}
 
-	if err := s.AddActiveAddress(address); err != nil {
-		return fmt.Errorf("failed to add active address %q: %w", address, err)
-	}
-
 	s.addressToTxns[address] = append(s.addressToTxns[address], txn)
 
 	return nil
 }
 
+// RemoveTransactionsFor removes all the transactions of a given address
+func (s *inMemory) RemoveTransactionsFor(address string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.addressToTxns, address)
+	return nil
+}
+
+// CountTransactionsFor returns the number of transactions stored for a given address
+func (s *inMemory) CountTransactionsFor(address string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.addressToTxns[address]), nil
+}
+
+// GetLatestTransactionFor returns the transaction of a given address at the highest block and position
+func (s *inMemory) GetLatestTransactionFor(address string) (*parser.Transaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return latestRecord(s.addressToTxns[address]), nil
+}
+
 // GetTransactionsFor returns the transactions for a given address
 func (s *inMemory) GetTransactionsFor(address string) ([]parser.Transaction, error) {
 	s.mu.RLock()
@@ -47,23 +93,393 @@ func (s *inMemory) GetTransactionsFor(address string) ([]parser.Transaction, err
 	return s.addressToTxns[address], nil
 }
 
+// QueryTransactionsFor returns the transactions for a given address selected by the query, in its order
+func (s *inMemory) QueryTransactionsFor(address string, query parser.Query) ([]parser.Transaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return queryRecords(s.addressToTxns[address], query, func(parser.Transaction) bool { return true })
+}
+
+// AddLogFor adds a log for a given address
+func (s *inMemory) AddLogFor(address string, entry parser.Log) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.addressToLogs == nil {
+		s.addressToLogs = make(map[string][]parser.Log)
+	}
+
+	s.addressToLogs[address] = append(s.addressToLogs[address], entry)
+
+	return nil
+}
+
+// RemoveLogsFor removes all the logs of a given address
+func (s *inMemory) RemoveLogsFor(address string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.addressToLogs, address)
+	return nil
+}
+
+// CountLogsFor returns the number of logs stored for a given address
+func (s *inMemory) CountLogsFor(address string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.addressToLogs[address]), nil
+}
+
+// GetLatestLogFor returns the log of a given address at the highest block and position
+func (s *inMemory) GetLatestLogFor(address string) (*parser.Log, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return latestRecord(s.addressToLogs[address]), nil
+}
+
+// GetLogsFor returns the logs for a given address
+func (s *inMemory) GetLogsFor(address string) ([]parser.Log, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.addressToLogs[address], nil
+}
+
+// QueryLogsFor returns the logs for a given address selected by the query, in its order
+func (s *inMemory) QueryLogsFor(address string, query parser.Query) ([]parser.Log, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return queryRecords(s.addressToLogs[address], query, func(entry parser.Log) bool {
+		return query.MatchesTopics(entry.Topics)
+	})
+}
+
+// latestRecord returns the record at the highest position, the one stored last among equals, or nil if there is none
+func latestRecord[T interface{ Position() parser.Position }](records []T) *T {
+	var latest *T
+	for i := range records {
+		if latest == nil || records[i].Position().Compare((*latest).Position()) >= 0 {
+			latest = &records[i]
+		}
+	}
+	if latest == nil {
+		return nil
+	}
+
+	record := *latest
+	return &record
+}
+
+// queryRecords returns the records within the block range of the query, after its cursor and matching
+// the given filter, sorted in the query order and limited
+func queryRecords[T interface{ Position() parser.Position }](records []T, query parser.Query, match func(T) bool) ([]T, error) {
+	after, err := query.After()
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []T
+	for _, record := range records {
+		position := record.Position()
+		switch {
+		case query.FromBlock != nil && position.Block < *query.FromBlock,
+			query.ToBlock != nil && position.Block > *query.ToBlock,
+			after != nil && query.Order == parser.OrderDesc && position.Compare(*after) >= 0,
+			after != nil && query.Order != parser.OrderDesc && position.Compare(*after) <= 0,
+			!match(record):
+			continue
+		}
+
+		selected = append(selected, record)
+	}
+
+	slices.SortStableFunc(selected, func(a, b T) int {
+		if query.Order == parser.OrderDesc {
+			return b.Position().Compare(a.Position())
+		}
+		return a.Position().Compare(b.Position())
+	})
+
+	if query.Limit > 0 && len(selected) > query.Limit {
+		selected = selected[:query.Limit]
+	}
+
+	return selected, nil
+}
+
+// RemoveTransactionsInBlock removes the transactions of every address included in the given block
+func (s *inMemory) RemoveTransactionsInBlock(blockHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for address, txns := range s.addressToTxns {
+		s.addressToTxns[address] = slices.DeleteFunc(slices.Clone(txns), func(txn parser.Transaction) bool {
+			return txn.BlockHash == blockHash
+		})
+	}
+
+	return nil
+}
+
+// RemoveLogsInBlock removes the logs of every address emitted in the given block
+func (s *inMemory) RemoveLogsInBlock(blockHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for address, logs := range s.addressToLogs {
+		s.addressToLogs[address] = slices.DeleteFunc(slices.Clone(logs), func(entry parser.Log) bool {
+			return entry.BlockHash == blockHash
+		})
+	}
+
+	return nil
+}
+
 // AddActiveAddress adds an address to the active list
 func (s *inMemory) AddActiveAddress(address string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	if s.activeAddrs == nil {
-		s.activeAddrs = make(map[string]struct{})
+		s.activeAddrs = make(map[string]time.Time)
 	}
 
-	s.activeAddrs[address] = struct{}{}
+	// Re-adding an active address keeps its original subscription time
+	if _, ok := s.activeAddrs[address]; !ok {
+		s.activeAddrs[address] = time.Now()
+	}
 	return nil
 }
 
-// GetActiveAddresses returns the set of active addresses
+// GetActiveAddresses returns a copy of the set of active addresses
 func (s *inMemory) GetActiveAddresses() (map[string]struct{}, error) {
-	return s.activeAddrs, nil
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	addrs := make(map[string]struct{}, len(s.activeAddrs))
+	for address := range s.activeAddrs {
+		addrs[address] = struct{}{}
+	}
+
+	return addrs, nil
+}
+
+// GetSubscribedAt returns when an address was added to the active list
+func (s *inMemory) GetSubscribedAt(address string) (time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.activeAddrs[address], nil
 }
 
 // RemoveActiveAddress removes an address from the active list
 func (s *inMemory) RemoveActiveAddress(address string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	delete(s.activeAddrs, address)
 	return nil
 }
+
+// GetLastProcessedBlock returns the last block processed for a given address
+func (s *inMemory) GetLastProcessedBlock(address string) (uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastBlocks[address], nil
+}
+
+// SetLastProcessedBlock records the last block processed for a given address
+func (s *inMemory) SetLastProcessedBlock(address string, block uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lastBlocks == nil {
+		s.lastBlocks = make(map[string]uint64)
+	}
+
+	s.lastBlocks[address] = block
+	return nil
+}
+
+// SetCallback sets the callback of a given address
+func (s *inMemory) SetCallback(address string, callback parser.Callback) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.callbacks == nil {
+		s.callbacks = make(map[string]parser.Callback)
+	}
+
+	s.callbacks[address] = callback
+	return nil
+}
+
+// GetCallback returns the callback of a given address
+func (s *inMemory) GetCallback(address string) (*parser.Callback, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	callback, ok := s.callbacks[address]
+	if !ok {
+		return nil, nil
+	}
+
+	return &callback, nil
+}
+
+// RemoveCallback removes the callback of a given address
+func (s *inMemory) RemoveCallback(address string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.callbacks, address)
+	return nil
+}
+
+// SetFilter sets the log filter of a given address
+func (s *inMemory) SetFilter(address string, filter parser.LogFilter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.filters == nil {
+		s.filters = make(map[string]parser.LogFilter)
+	}
+
+	s.filters[address] = filter
+	return nil
+}
+
+// GetFilter returns the log filter of a given address, or nil if it has none
+func (s *inMemory) GetFilter(address string) (*parser.LogFilter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	filter, ok := s.filters[address]
+	if !ok {
+		return nil, nil
+	}
+
+	return &filter, nil
+}
+
+// RemoveFilter removes the log filter of a given address
+func (s *inMemory) RemoveFilter(address string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.filters, address)
+	return nil
+}
+
+// SetABI sets the contract ABI JSON of a given address
+func (s *inMemory) SetABI(address string, abi string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.abis == nil {
+		s.abis = make(map[string]string)
+	}
+
+	s.abis[address] = abi
+	return nil
+}
+
+// GetABI returns the contract ABI JSON of a given address, or an empty string if it has none
+func (s *inMemory) GetABI(address string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.abis[address], nil
+}
+
+// RemoveABI removes the contract ABI of a given address
+func (s *inMemory) RemoveABI(address string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.abis, address)
+	return nil
+}
+
+// AddDelivery queues a new webhook delivery
+func (s *inMemory) AddDelivery(delivery webhook.Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.deliveries == nil {
+		s.deliveries = make(map[int64]webhook.Delivery)
+	}
+
+	s.lastDeliveryID++
+	delivery.ID = s.lastDeliveryID
+	s.deliveries[delivery.ID] = delivery
+	return nil
+}
+
+// GetDueDeliveries returns up to limit live deliveries due at now, the earliest first
+func (s *inMemory) GetDueDeliveries(now time.Time, limit int) ([]webhook.Delivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var due []webhook.Delivery
+	for _, delivery := range s.deliveries {
+		if !delivery.Dead && !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+
+	slices.SortFunc(due, func(a, b webhook.Delivery) int {
+		if c := a.NextAttemptAt.Compare(b.NextAttemptAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	return due, nil
+}
+
+// UpdateDelivery records the outcome of a failed webhook delivery attempt
+func (s *inMemory) UpdateDelivery(delivery webhook.Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.deliveries[delivery.ID]; !ok {
+		return fmt.Errorf("delivery %d not found", delivery.ID)
+	}
+
+	s.deliveries[delivery.ID] = delivery
+	return nil
+}
+
+// RemoveDelivery removes a webhook delivery
+func (s *inMemory) RemoveDelivery(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.deliveries, id)
+	return nil
+}
+
+// GetDeadDeliveries returns the webhook deliveries that exhausted their attempts, the oldest first
+func (s *inMemory) GetDeadDeliveries() ([]webhook.Delivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var dead []webhook.Delivery
+	for _, delivery := range s.deliveries {
+		if delivery.Dead {
+			dead = append(dead, delivery)
+		}
+	}
+
+	slices.SortFunc(dead, func(a, b webhook.Delivery) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	return dead, nil
+}
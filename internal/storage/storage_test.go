@@ -1,68 +1,846 @@
 package storage
 
 import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/HomayoonAlimohammadi/blockchain-parser/internal/parser"
+	"github.com/HomayoonAlimohammadi/blockchain-parser/internal/webhook"
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/abi"
 )
 
+// store is what every storage implementation provides
+type store interface {
+	parser.Storage
+	webhook.Store
+}
+
+// newStores returns every storage implementation so each test covers all of them
+func newStores(t *testing.T) map[string]store {
+	t.Helper()
+
+	sqliteStore, err := NewSQLite(filepath.Join(t.TempDir(), "parser.db"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	t.Cleanup(func() { sqliteStore.Close() })
+
+	return map[string]store{
+		"InMemory": NewInMemory(),
+		"SQLite":   sqliteStore,
+	}
+}
+
 func TestAddTransactionFor(t *testing.T) {
-	store := NewInMemory()
-	address := "test_address"
-	txn := parser.Transaction{Data: "txn1"}
+	for name, store := range newStores(t) {
+		t.Run(name, func(t *testing.T) {
+			address := "test_address"
+			txn := parser.Transaction{Hash: "txn1"}
+
+			err := store.AddTransactionFor(address, txn)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			transactions, err := store.GetTransactionsFor(address)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if len(transactions) != 1 {
+				t.Fatalf("expected 1 transaction, got %d", len(transactions))
+			}
+
+			if transactions[0].Hash != txn.Hash {
+				t.Fatalf("expected transaction ID %s, got %s", txn.Hash, transactions[0].Hash)
+			}
+		})
+	}
+}
+
+func TestGetTransactionsFor(t *testing.T) {
+	for name, store := range newStores(t) {
+		t.Run(name, func(t *testing.T) {
+			address := "test_address"
+			txn1 := parser.Transaction{Hash: "txn1"}
+			txn2 := parser.Transaction{Hash: "txn2", From: "0xfrom", To: "0xto", Value: "0x1"}
+
+			store.AddTransactionFor(address, txn1)
+			store.AddTransactionFor(address, txn2)
+
+			transactions, err := store.GetTransactionsFor(address)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if len(transactions) != 2 {
+				t.Fatalf("expected 2 transactions, got %d", len(transactions))
+			}
+
+			if transactions[0].Hash != txn1.Hash || transactions[1].Hash != txn2.Hash {
+				t.Fatalf("expected transaction IDs %s and %s, got %s and %s", txn1.Hash, txn2.Hash, transactions[0].Hash, transactions[1].Hash)
+			}
+
+			if transactions[1].From != txn2.From || transactions[1].To != txn2.To || transactions[1].Value != txn2.Value {
+				t.Fatalf("expected transfer %s -> %s of %s, got %s -> %s of %s", txn2.From, txn2.To, txn2.Value, transactions[1].From, transactions[1].To, transactions[1].Value)
+			}
+		})
+	}
+}
+
+func TestGetTransactionsForEmptyAddress(t *testing.T) {
+	for name, store := range newStores(t) {
+		t.Run(name, func(t *testing.T) {
+			address := "non_existent_address"
+
+			transactions, err := store.GetTransactionsFor(address)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if len(transactions) != 0 {
+				t.Fatalf("expected 0 transactions, got %d", len(transactions))
+			}
+		})
+	}
+}
+
+func TestRemoveTransactionsFor(t *testing.T) {
+	for name, store := range newStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store.AddTransactionFor("addr1", parser.Transaction{Hash: "txn1"})
+			store.AddTransactionFor("addr2", parser.Transaction{Hash: "txn2"})
+
+			if err := store.RemoveTransactionsFor("addr1"); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			transactions, _ := store.GetTransactionsFor("addr1")
+			if len(transactions) != 0 {
+				t.Fatalf("expected 0 transactions, got %d", len(transactions))
+			}
+
+			transactions, _ = store.GetTransactionsFor("addr2")
+			if len(transactions) != 1 {
+				t.Fatalf("expected 1 transaction for other address, got %d", len(transactions))
+			}
+		})
+	}
+}
+
+func TestTransactionSummary(t *testing.T) {
+	for name, store := range newStores(t) {
+		t.Run(name, func(t *testing.T) {
+			last, err := store.GetLatestTransactionFor("test_address")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if last != nil {
+				t.Fatalf("expected no last transaction, got %v", last)
+			}
+
+			// A backfill stores transactions of older blocks after the live ones
+			store.AddTransactionFor("test_address", parser.Transaction{Hash: "txn1", BlockNumber: "0x10", TransactionIndex: "0x0"})
+			store.AddTransactionFor("test_address", parser.Transaction{Hash: "txn2", BlockNumber: "0x10", TransactionIndex: "0x1"})
+			store.AddTransactionFor("test_address", parser.Transaction{Hash: "txn3", BlockNumber: "0xf", TransactionIndex: "0x2"})
+
+			count, err := store.CountTransactionsFor("test_address")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if count != 3 {
+				t.Fatalf("expected 3 transactions, got %d", count)
+			}
+
+			last, err = store.GetLatestTransactionFor("test_address")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if last == nil || last.Hash != "txn2" {
+				t.Fatalf("expected latest transaction txn2, got %v", last)
+			}
+		})
+	}
+}
+
+func TestLogs(t *testing.T) {
+	for name, store := range newStores(t) {
+		t.Run(name, func(t *testing.T) {
+			log1 := parser.Log{Data: "log1", LogIndex: "0x0", Topics: []string{"0xtopic"}}
+			log2 := parser.Log{Data: "log2", LogIndex: "0x1"}
+
+			// The latest log is stored first, as when a backfill completes the live stream
+			store.AddLogFor("test_address", log2)
+			store.AddLogFor("test_address", log1)
+			store.AddLogFor("other_address", log1)
+			store.AddTransactionFor("test_address", parser.Transaction{Hash: "txn1"})
+
+			logs, err := store.GetLogsFor("test_address")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if len(logs) != 2 || logs[0].Data != log2.Data || logs[1].Data != log1.Data || logs[1].Topics[0] != "0xtopic" {
+				t.Fatalf("expected logs log1 and log2, got %v", logs)
+			}
+
+			count, err := store.CountLogsFor("test_address")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if count != 2 {
+				t.Fatalf("expected 2 logs, got %d", count)
+			}
+
+			last, err := store.GetLatestLogFor("test_address")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if last == nil || last.Data != "log2" {
+				t.Fatalf("expected latest log log2, got %v", last)
+			}
+
+			if err := store.RemoveLogsFor("test_address"); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			logs, _ = store.GetLogsFor("test_address")
+			if len(logs) != 0 {
+				t.Fatalf("expected 0 logs, got %d", len(logs))
+			}
+
+			logs, _ = store.GetLogsFor("other_address")
+			if len(logs) != 1 {
+				t.Fatalf("expected 1 log for other address, got %d", len(logs))
+			}
 
-	err := store.AddTransactionFor(address, txn)
+			// Logs and transactions are stored apart
+			transactions, _ := store.GetTransactionsFor("test_address")
+			if len(transactions) != 1 {
+				t.Fatalf("expected 1 transaction left, got %d", len(transactions))
+			}
+		})
+	}
+}
+
+func TestQuery(t *testing.T) {
+	transfer := "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+	approval := "0x8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925"
+
+	for name, store := range newStores(t) {
+		t.Run(name, func(t *testing.T) {
+			// Stored out of order, as a backfill can complete after newer records arrived
+			for _, txn := range []parser.Transaction{
+				{Hash: "0xc", BlockNumber: "0x20", TransactionIndex: "0x0"},
+				{Hash: "0xa", BlockNumber: "0x9", TransactionIndex: "0x1"},
+				{Hash: "0xb", BlockNumber: "0x10", TransactionIndex: "0x2"},
+				{Hash: "0xd", BlockNumber: "0x20", TransactionIndex: "0x3"},
+			} {
+				store.AddTransactionFor("test_address", txn)
+			}
+			store.AddTransactionFor("other_address", parser.Transaction{Hash: "0xe", BlockNumber: "0x10"})
+
+			hashes := func(query parser.Query) []string {
+				t.Helper()
+
+				txns, err := store.QueryTransactionsFor("test_address", query)
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+
+				var hashes []string
+				for _, txn := range txns {
+					hashes = append(hashes, txn.Hash)
+				}
+				return hashes
+			}
+
+			if got := hashes(parser.Query{Order: parser.OrderAsc}); !slices.Equal(got, []string{"0xa", "0xb", "0xc", "0xd"}) {
+				t.Fatalf("expected the transactions by block and index, got %v", got)
+			}
+			if got := hashes(parser.Query{Order: parser.OrderDesc, Limit: 2}); !slices.Equal(got, []string{"0xd", "0xc"}) {
+				t.Fatalf("expected the newest transactions first, got %v", got)
+			}
+
+			fromBlock, toBlock := uint64(16), uint64(31)
+			if got := hashes(parser.Query{FromBlock: &fromBlock, ToBlock: &toBlock, Order: parser.OrderAsc}); !slices.Equal(got, []string{"0xb"}) {
+				t.Fatalf("expected the transactions within the block range, got %v", got)
+			}
+
+			cursor := parser.Position{Block: 32, Index: 0}.Cursor()
+			if got := hashes(parser.Query{Cursor: cursor, Order: parser.OrderAsc}); !slices.Equal(got, []string{"0xd"}) {
+				t.Fatalf("expected the transactions after the cursor, got %v", got)
+			}
+			if got := hashes(parser.Query{Cursor: cursor, Order: parser.OrderDesc}); !slices.Equal(got, []string{"0xb", "0xa"}) {
+				t.Fatalf("expected the transactions before the cursor, got %v", got)
+			}
+
+			store.AddLogFor("test_address", parser.Log{TransactionHash: "0xa", BlockNumber: "0x9", LogIndex: "0x0", Topics: []string{transfer, "0xfrom", "0xto"}})
+			store.AddLogFor("test_address", parser.Log{TransactionHash: "0xb", BlockNumber: "0x9", LogIndex: "0x1", Topics: []string{approval, "0xowner", "0xspender"}})
+			store.AddLogFor("test_address", parser.Log{TransactionHash: "0xc", BlockNumber: "0x10", LogIndex: "0x0", Topics: []string{transfer, "0xother", "0xto"}})
+
+			for _, tc := range []struct {
+				topics   [][]string
+				expected []string
+			}{
+				{topics: nil, expected: []string{"0xa", "0xb", "0xc"}},
+				{topics: [][]string{{"0x" + strings.ToUpper(transfer[2:])}}, expected: []string{"0xa", "0xc"}},
+				{topics: [][]string{{transfer}}, expected: []string{"0xa", "0xc"}},
+				{topics: [][]string{nil, {"0xFROM", "0xowner"}}, expected: []string{"0xa", "0xb"}},
+				{topics: [][]string{{transfer}, nil, {"0xto"}}, expected: []string{"0xa", "0xc"}},
+				{topics: [][]string{nil, nil, nil, {"0xextra"}}, expected: nil},
+			} {
+				logs, err := store.QueryLogsFor("test_address", parser.Query{Topics: tc.topics, Order: parser.OrderAsc})
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+
+				var got []string
+				for _, entry := range logs {
+					got = append(got, entry.TransactionHash)
+				}
+				if !slices.Equal(got, tc.expected) {
+					t.Fatalf("expected logs %v for topics %v, got %v", tc.expected, tc.topics, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRemoveInBlock(t *testing.T) {
+	for name, store := range newStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store.AddTransactionFor("addr1", parser.Transaction{Hash: "txn1", BlockHash: "0xorphaned"})
+			store.AddTransactionFor("addr2", parser.Transaction{Hash: "txn2", BlockHash: "0xorphaned"})
+			store.AddTransactionFor("addr1", parser.Transaction{Hash: "txn3", BlockHash: "0xcanonical"})
+			store.AddLogFor("addr1", parser.Log{Data: "log1", BlockHash: "0xorphaned"})
+			store.AddLogFor("addr1", parser.Log{Data: "log2", BlockHash: "0xcanonical"})
+
+			if err := store.RemoveTransactionsInBlock("0xorphaned"); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if err := store.RemoveLogsInBlock("0xorphaned"); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			transactions, _ := store.GetTransactionsFor("addr1")
+			if len(transactions) != 1 || transactions[0].Hash != "txn3" {
+				t.Fatalf("expected only txn3 left, got %v", transactions)
+			}
+
+			transactions, _ = store.GetTransactionsFor("addr2")
+			if len(transactions) != 0 {
+				t.Fatalf("expected 0 transactions for other address, got %d", len(transactions))
+			}
+
+			logs, _ := store.GetLogsFor("addr1")
+			if len(logs) != 1 || logs[0].Data != "log2" {
+				t.Fatalf("expected only log2 left, got %v", logs)
+			}
+		})
+	}
+}
+
+func TestSubscribedAt(t *testing.T) {
+	for name, store := range newStores(t) {
+		t.Run(name, func(t *testing.T) {
+			before := time.Now()
+			store.AddActiveAddress("test_address")
+
+			subscribedAt, err := store.GetSubscribedAt("test_address")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if subscribedAt.Before(before) || subscribedAt.After(time.Now()) {
+				t.Fatalf("expected subscription time around now, got %v", subscribedAt)
+			}
+
+			// Re-adding an active address keeps its original subscription time
+			store.AddActiveAddress("test_address")
+			again, _ := store.GetSubscribedAt("test_address")
+			if !again.Equal(subscribedAt) {
+				t.Fatalf("expected subscription time %v to be kept, got %v", subscribedAt, again)
+			}
+		})
+	}
+}
+
+func TestActiveAddresses(t *testing.T) {
+	for name, store := range newStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := store.AddActiveAddress("addr1"); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if err := store.AddActiveAddress("addr2"); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if err := store.RemoveActiveAddress("addr1"); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			addrs, err := store.GetActiveAddresses()
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if _, ok := addrs["addr1"]; ok {
+				t.Fatalf("expected addr1 to be removed, got %v", addrs)
+			}
+			if _, ok := addrs["addr2"]; !ok {
+				t.Fatalf("expected addr2 to be active, got %v", addrs)
+			}
+		})
+	}
+}
+
+func TestLastProcessedBlock(t *testing.T) {
+	for name, store := range newStores(t) {
+		t.Run(name, func(t *testing.T) {
+			block, err := store.GetLastProcessedBlock("test_address")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if block != 0 {
+				t.Fatalf("expected block 0 for unknown address, got %d", block)
+			}
+
+			store.SetLastProcessedBlock("test_address", 10)
+			store.SetLastProcessedBlock("test_address", 20)
+
+			block, err = store.GetLastProcessedBlock("test_address")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if block != 20 {
+				t.Fatalf("expected block 20, got %d", block)
+			}
+		})
+	}
+}
+
+func TestCallbacks(t *testing.T) {
+	for name, store := range newStores(t) {
+		t.Run(name, func(t *testing.T) {
+			callback, err := store.GetCallback("test_address")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if callback != nil {
+				t.Fatalf("expected no callback for unknown address, got %+v", callback)
+			}
+
+			store.SetCallback("test_address", parser.Callback{URL: "http://old", Secret: "old"})
+			store.SetCallback("test_address", parser.Callback{URL: "http://new", Secret: "new"})
+
+			callback, err = store.GetCallback("test_address")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if callback == nil || *callback != (parser.Callback{URL: "http://new", Secret: "new"}) {
+				t.Fatalf("expected the latest callback, got %+v", callback)
+			}
+
+			if err := store.RemoveCallback("test_address"); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			callback, err = store.GetCallback("test_address")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if callback != nil {
+				t.Fatalf("expected no callback after removal, got %+v", callback)
+			}
+		})
+	}
+}
+
+func TestFilters(t *testing.T) {
+	for name, store := range newStores(t) {
+		t.Run(name, func(t *testing.T) {
+			filter, err := store.GetFilter("test_address")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if filter != nil {
+				t.Fatalf("expected no filter for unknown address, got %+v", filter)
+			}
+
+			store.SetFilter("test_address", parser.LogFilter{Addresses: []string{"test_address"}})
+			expected := parser.LogFilter{Addresses: []string{"test_address", "other"}, Topics: [][]string{{"0xt0"}, nil, {"0xt2a", "0xt2b"}}}
+			store.SetFilter("test_address", expected)
+
+			filter, err = store.GetFilter("test_address")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if filter == nil || !slices.Equal(filter.Addresses, expected.Addresses) || len(filter.Topics) != 3 ||
+				!slices.Equal(filter.Topics[0], expected.Topics[0]) || len(filter.Topics[1]) != 0 || !slices.Equal(filter.Topics[2], expected.Topics[2]) {
+				t.Fatalf("expected the latest filter %+v, got %+v", expected, filter)
+			}
+
+			if err := store.RemoveFilter("test_address"); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			filter, err = store.GetFilter("test_address")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if filter != nil {
+				t.Fatalf("expected no filter after removal, got %+v", filter)
+			}
+		})
+	}
+}
+
+func TestABIs(t *testing.T) {
+	contractABI, err := abi.Parse([]byte(`[{"type":"event","name":"Named","inputs":[
+		{"name":"id","type":"uint256","indexed":true},
+		{"name":"name","type":"string"}
+	]}]`))
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
 
-	transactions, err := store.GetTransactionsFor(address)
+	entry := parser.Log{
+		BlockNumber: "0x1",
+		LogIndex:    "0x0",
+		Topics: []string{
+			contractABI.Events()[0].Topic(),
+			"0x000000000000000000000000000000000000000000000000000000000000002a",
+		},
+		Data: "0x" +
+			"0000000000000000000000000000000000000000000000000000000000000020" +
+			"0000000000000000000000000000000000000000000000000000000000000003" +
+			"666f6f0000000000000000000000000000000000000000000000000000000000",
+	}
+	if entry.Decoded, err = contractABI.DecodeLog(entry.Topics, entry.Data); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for name, store := range newStores(t) {
+		t.Run(name, func(t *testing.T) {
+			data, err := store.GetABI("test_address")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if data != "" {
+				t.Fatalf("expected no ABI for unknown address, got %q", data)
+			}
+
+			store.SetABI("test_address", "[]")
+			store.SetABI("test_address", `[{"type":"event"}]`)
+
+			data, err = store.GetABI("test_address")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if data != `[{"type":"event"}]` {
+				t.Fatalf("expected the latest ABI, got %q", data)
+			}
+
+			if err := store.RemoveABI("test_address"); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			data, err = store.GetABI("test_address")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if data != "" {
+				t.Fatalf("expected no ABI after removal, got %q", data)
+			}
+
+			// The decoded log is stored along with the raw one
+			if err := store.AddLogFor("test_address", entry); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			logs, err := store.GetLogsFor("test_address")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if len(logs) != 1 || logs[0].Decoded == nil {
+				t.Fatalf("expected a decoded log, got %+v", logs)
+			}
+
+			decoded := logs[0].Decoded
+			if decoded.Signature != "Named(uint256,string)" || len(decoded.Args) != 2 {
+				t.Fatalf("expected the decoded event, got %+v", decoded)
+			}
+			if id := fmt.Sprint(decoded.Args[0].Value); id != "42" || decoded.Args[1].Value != "foo" {
+				t.Fatalf("expected id 42 and name foo, got %v and %v", id, decoded.Args[1].Value)
+			}
+		})
+	}
+}
+
+func TestDeliveries(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	for name, store := range newStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store.AddDelivery(webhook.Delivery{Address: "later", URL: "http://b", Payload: []byte(`{}`), NextAttemptAt: now.Add(time.Minute), CreatedAt: now})
+			store.AddDelivery(webhook.Delivery{Address: "due", URL: "http://a", Payload: []byte(`{"address":"due"}`), NextAttemptAt: now, CreatedAt: now})
+			store.AddDelivery(webhook.Delivery{Address: "earlier", URL: "http://c", Payload: []byte(`{}`), NextAttemptAt: now.Add(-time.Minute), CreatedAt: now})
+
+			due, err := store.GetDueDeliveries(now, 10)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if len(due) != 2 || due[0].Address != "earlier" || due[1].Address != "due" {
+				t.Fatalf("expected the due deliveries earliest first, got %+v", due)
+			}
+			if due[1].URL != "http://a" || string(due[1].Payload) != `{"address":"due"}` || !due[1].CreatedAt.Equal(now) {
+				t.Fatalf("unexpected delivery %+v", due[1])
+			}
+
+			if due, _ := store.GetDueDeliveries(now, 1); len(due) != 1 || due[0].Address != "earlier" {
+				t.Fatalf("expected the limit to apply, got %+v", due)
+			}
+
+			failed := due[0]
+			failed.Attempts = 3
+			failed.LastError = "unexpected status 500"
+			failed.Dead = true
+			if err := store.UpdateDelivery(failed); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if err := store.RemoveDelivery(due[1].ID); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if due, _ := store.GetDueDeliveries(now.Add(time.Hour), 10); len(due) != 1 || due[0].Address != "later" {
+				t.Fatalf("expected only the live delivery left, got %+v", due)
+			}
+
+			dead, err := store.GetDeadDeliveries()
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if len(dead) != 1 || dead[0].ID != failed.ID || dead[0].Attempts != 3 || dead[0].LastError != "unexpected status 500" {
+				t.Fatalf("expected the failed delivery to be dead, got %+v", dead)
+			}
+
+			if err := store.UpdateDelivery(webhook.Delivery{ID: 1000}); err == nil {
+				t.Fatalf("expected an error updating an unknown delivery")
+			}
+		})
+	}
+}
+
+func TestSQLitePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "parser.db")
+	entry := parser.Log{Data: "log1", Topics: []string{"0xtopic"}}
+
+	store, err := NewSQLite(path)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
+	store.AddActiveAddress("test_address")
+	store.AddLogFor("test_address", entry)
+	store.Close()
 
-	if len(transactions) != 1 {
-		t.Fatalf("expected 1 transaction, got %d", len(transactions))
+	store, err = NewSQLite(path)
+	if err != nil {
+		t.Fatalf("expected no error reopening database, got %v", err)
 	}
+	defer store.Close()
 
-	if transactions[0].Data != txn.Data {
-		t.Fatalf("expected transaction ID %s, got %s", txn.Data, transactions[0].Data)
+	addrs, err := store.GetActiveAddresses()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, ok := addrs["test_address"]; !ok {
+		t.Fatalf("expected test_address to survive reopening, got %v", addrs)
+	}
+
+	logs, err := store.GetLogsFor("test_address")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(logs) != 1 || logs[0].Topics[0] != "0xtopic" {
+		t.Fatalf("expected stored log to survive reopening, got %v", logs)
 	}
 }
 
-func TestGetTransactionsFor(t *testing.T) {
-	store := NewInMemory()
-	address := "test_address"
-	txn1 := parser.Transaction{Data: "txn1"}
-	txn2 := parser.Transaction{Data: "txn2"}
+func TestSQLiteMigrateSplitsLogs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "parser.db")
+
+	// A database left at the schema where logs and transfers shared the transactions table
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for i, stmt := range migrations[:4] {
+		if err := applyMigration(db, i+1, stmt); err != nil {
+			t.Fatalf("expected no error applying migration %d, got %v", i+1, err)
+		}
+	}
+	_, err = db.Exec(`INSERT INTO transactions (
+		address, contract_address, block_hash, block_number, data,
+		log_index, topics, transaction_hash, transaction_index,
+		from_address, to_address, value
+	) VALUES
+		('test_address', 'test_address', '0xblock', '0x10', '0xdata', '0x0', '["0xtopic"]', '0xlog', '0x0', '', '', ''),
+		('test_address', '', '0xblock', '0x10', '0xinput', '', 'null', '0xtransfer', '0x1', '0xfrom', 'test_address', '0x1')`)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	db.Close()
+
+	store, err := NewSQLite(path)
+	if err != nil {
+		t.Fatalf("expected no error migrating, got %v", err)
+	}
+	defer store.Close()
+
+	logs, err := store.GetLogsFor("test_address")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(logs) != 1 || logs[0].TransactionHash != "0xlog" || logs[0].Topics[0] != "0xtopic" {
+		t.Fatalf("expected the log to be moved to the logs table, got %v", logs)
+	}
 
-	store.AddTransactionFor(address, txn1)
-	store.AddTransactionFor(address, txn2)
+	transactions, err := store.GetTransactionsFor("test_address")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(transactions) != 1 || transactions[0].Hash != "0xtransfer" || transactions[0].From != "0xfrom" || transactions[0].Input != "0xinput" {
+		t.Fatalf("expected the transfer to be kept as a transaction, got %v", transactions)
+	}
+}
+
+func TestSQLiteMigrateBlockHeights(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "parser.db")
 
-	transactions, err := store.GetTransactionsFor(address)
+	// A database left at the schema where block numbers were only stored as hex
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for i, stmt := range migrations[:7] {
+		if err := applyMigration(db, i+1, stmt); err != nil {
+			t.Fatalf("expected no error applying migration %d, got %v", i+1, err)
+		}
+	}
+	_, err = db.Exec(`INSERT INTO logs (
+		address, contract_address, block_hash, block_number, data,
+		log_index, topics, transaction_hash, transaction_index
+	) VALUES
+		('test_address', 'test_address', '0xblock', '0x1A', '0x', '0x2', '[]', '0xa', '0x0'),
+		('test_address', 'test_address', '0xblock', '0x9', '0x', '0x10', '[]', '0xb', '0x0')`)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
+	db.Close()
 
-	if len(transactions) != 2 {
-		t.Fatalf("expected 2 transactions, got %d", len(transactions))
+	store, err := NewSQLite(path)
+	if err != nil {
+		t.Fatalf("expected no error migrating, got %v", err)
+	}
+	defer store.Close()
+
+	fromBlock := uint64(10)
+	logs, err := store.QueryLogsFor("test_address", parser.Query{FromBlock: &fromBlock, Order: parser.OrderAsc})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(logs) != 1 || logs[0].TransactionHash != "0xa" {
+		t.Fatalf("expected only the log of block 26, got %v", logs)
 	}
 
-	if transactions[0].Data != txn1.Data || transactions[1].Data != txn2.Data {
-		t.Fatalf("expected transaction IDs %s and %s, got %s and %s", txn1.Data, txn2.Data, transactions[0].Data, transactions[1].Data)
+	logs, err = store.QueryLogsFor("test_address", parser.Query{Cursor: parser.Position{Block: 9, Index: 16}.Cursor(), Order: parser.OrderAsc})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(logs) != 1 || logs[0].TransactionHash != "0xa" {
+		t.Fatalf("expected only the log after block 9 index 16, got %v", logs)
 	}
 }
 
-func TestGetTransactionsForEmptyAddress(t *testing.T) {
-	store := NewInMemory()
-	address := "non_existent_address"
+func TestSQLiteMigrateLowercaseAddresses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "parser.db")
+
+	const (
+		checksummed = "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+		lowercase   = "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"
+		other       = "0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359"
+	)
+
+	// A database left at the schema where addresses were stored as given,
+	// with an address subscribed both checksummed and lowercase
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for i, stmt := range migrations[:10] {
+		if err := applyMigration(db, i+1, stmt); err != nil {
+			t.Fatalf("expected no error applying migration %d, got %v", i+1, err)
+		}
+	}
+	_, err = db.Exec(`INSERT INTO active_addresses (address) VALUES (?), (?), (?);
+		INSERT INTO last_processed_blocks (address, block_number) VALUES (?, 10), (?, 20);
+		INSERT INTO filters (address, addresses, topics) VALUES (?, ?, '[]');
+		INSERT INTO logs (
+			address, contract_address, block_hash, block_number, data,
+			log_index, topics, transaction_hash, transaction_index
+		) VALUES (?, ?, '0xblock', '0x10', '0x', '0x0', '[]', '0xa', '0x0')`,
+		checksummed, lowercase, other,
+		checksummed, lowercase,
+		other, `["`+other+`"]`,
+		checksummed, checksummed)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	db.Close()
 
-	transactions, err := store.GetTransactionsFor(address)
+	store, err := NewSQLite(path)
+	if err != nil {
+		t.Fatalf("expected no error migrating, got %v", err)
+	}
+	defer store.Close()
+
+	addresses, err := store.GetActiveAddresses()
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
+	if len(addresses) != 2 {
+		t.Fatalf("expected the duplicated address to be merged, got %v", addresses)
+	}
+	for address := range addresses {
+		if address != strings.ToLower(address) {
+			t.Fatalf("expected lowercase addresses, got %v", addresses)
+		}
+	}
 
-	if len(transactions) != 0 {
-		t.Fatalf("expected 0 transactions, got %d", len(transactions))
+	block, err := store.GetLastProcessedBlock(lowercase)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if block != 20 {
+		t.Fatalf("expected the block of the lowercase address to be kept, got %d", block)
+	}
+
+	filter, err := store.GetFilter(strings.ToLower(other))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if filter == nil || filter.Addresses[0] != strings.ToLower(other) {
+		t.Fatalf("expected the filter to be keyed and hold lowercase addresses, got %v", filter)
+	}
+
+	logs, err := store.GetLogsFor(lowercase)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(logs) != 1 || logs[0].Address != lowercase {
+		t.Fatalf("expected the log to be keyed by the lowercase address, got %v", logs)
 	}
 }
@@ -0,0 +1,291 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/HomayoonAlimohammadi/blockchain-parser/internal/parser"
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/log"
+)
+
+const (
+	// SignatureHeader holds "sha256=" followed by the hex encoded HMAC-SHA256 of the payload, keyed with the callback secret
+	SignatureHeader = "X-Webhook-Signature"
+	// DeliveryHeader holds the ID of the delivery, retries of the same delivery share it
+	DeliveryHeader = "X-Webhook-Delivery"
+
+	// maxAttempts is the number of delivery attempts before a delivery is dead-lettered
+	maxAttempts = 10
+	// minRetryDelay and maxRetryDelay bound the exponential backoff between attempts
+	minRetryDelay = time.Second
+	maxRetryDelay = time.Hour
+	// pollInterval is how often the queue is checked for deliveries due for a retry
+	pollInterval = time.Second
+	// batchSize is the maximum number of deliveries attempted per poll
+	batchSize = 100
+	// requestTimeout bounds a single delivery attempt
+	requestTimeout = 10 * time.Second
+	// maxConcurrentCallbacks is the maximum number of callbacks deliveries are sent to at once
+	maxConcurrentCallbacks = 10
+)
+
+// Delivery is a queued event delivery to a callback, signed with the secret of the callback when sent
+type Delivery struct {
+	ID            int64
+	Address       string
+	URL           string
+	Payload       []byte
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	CreatedAt     time.Time
+	// Dead is set once the delivery exhausted its attempts, it is no longer retried
+	Dead bool
+}
+
+// Store is the durable queue of deliveries, so they survive restarts
+type Store interface {
+	// GetCallback returns the callback of a given address, or nil if it has none
+	GetCallback(address string) (*parser.Callback, error)
+	// AddDelivery queues a new delivery
+	AddDelivery(delivery Delivery) error
+	// GetDueDeliveries returns up to limit live deliveries due at now, the earliest first
+	GetDueDeliveries(now time.Time, limit int) ([]Delivery, error)
+	// UpdateDelivery records the outcome of a failed attempt
+	UpdateDelivery(delivery Delivery) error
+	// RemoveDelivery removes a delivery once it succeeded
+	RemoveDelivery(id int64) error
+	// GetDeadDeliveries returns the deliveries that exhausted their attempts
+	GetDeadDeliveries() ([]Delivery, error)
+}
+
+// Dispatcher delivers the queued events to their callbacks, retrying failed deliveries with exponential backoff
+type Dispatcher struct {
+	client *http.Client
+	store  Store
+	wake   chan struct{}
+
+	maxAttempts   int
+	minRetryDelay time.Duration
+	maxRetryDelay time.Duration
+}
+
+// NewDispatcher creates a new dispatcher, deliveries are only sent once Run is called
+func NewDispatcher(client *http.Client, store Store) *Dispatcher {
+	return &Dispatcher{
+		client:        client,
+		store:         store,
+		wake:          make(chan struct{}, 1),
+		maxAttempts:   maxAttempts,
+		minRetryDelay: minRetryDelay,
+		maxRetryDelay: maxRetryDelay,
+	}
+}
+
+// Notify queues an event for delivery to a callback
+func (d *Dispatcher) Notify(callback parser.Callback, event parser.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	now := time.Now()
+	delivery := Delivery{
+		Address:       event.Address,
+		URL:           callback.URL,
+		Payload:       payload,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+	if err := d.store.AddDelivery(delivery); err != nil {
+		return fmt.Errorf("failed to queue delivery: %w", err)
+	}
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// DeadLetters returns the deliveries given up on after exhausting their attempts
+func (d *Dispatcher) DeadLetters() ([]parser.DeadLetter, error) {
+	deliveries, err := d.store.GetDeadDeliveries()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dead deliveries: %w", err)
+	}
+
+	deadLetters := make([]parser.DeadLetter, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		var event parser.Event
+		if err := json.Unmarshal(delivery.Payload, &event); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal payload of delivery %d: %w", delivery.ID, err)
+		}
+
+		deadLetters = append(deadLetters, parser.DeadLetter{
+			ID:          delivery.ID,
+			Address:     delivery.Address,
+			CallbackURL: delivery.URL,
+			Event:       event,
+			Attempts:    delivery.Attempts,
+			LastError:   delivery.LastError,
+			CreatedAt:   delivery.CreatedAt,
+		})
+	}
+
+	return deadLetters, nil
+}
+
+// Run sends the due deliveries until ctx is done, new deliveries are sent right away
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		d.dispatchDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// dispatchDue attempts the deliveries that are due. They are sent to several callbacks at once, and
+// in order to each callback, so a slow or failing callback only holds up its own deliveries.
+func (d *Dispatcher) dispatchDue(ctx context.Context) {
+	deliveries, err := d.store.GetDueDeliveries(time.Now(), batchSize)
+	if err != nil {
+		log.Error(err, "failed to get due deliveries")
+		return
+	}
+
+	var urls []string
+	byURL := make(map[string][]Delivery)
+	for _, delivery := range deliveries {
+		if _, ok := byURL[delivery.URL]; !ok {
+			urls = append(urls, delivery.URL)
+		}
+		byURL[delivery.URL] = append(byURL[delivery.URL], delivery)
+	}
+
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, maxConcurrentCallbacks)
+	for _, url := range urls {
+		workers <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-workers }()
+
+			d.dispatchTo(ctx, byURL[url])
+		}()
+	}
+	wg.Wait()
+}
+
+// dispatchTo attempts the deliveries to a single callback in order. Once one fails, the others are
+// left due for the next poll, so a callback that is down costs a single attempt per poll.
+func (d *Dispatcher) dispatchTo(ctx context.Context, deliveries []Delivery) {
+	for _, delivery := range deliveries {
+		if ctx.Err() != nil {
+			return
+		}
+
+		// The secret is only stored with the callback, the deliveries of a removed or replaced one are dropped
+		callback, err := d.store.GetCallback(delivery.Address)
+		if err != nil {
+			log.Error(err, "failed to get callback of delivery", "id", delivery.ID)
+			continue
+		} else if callback == nil || callback.URL != delivery.URL {
+			log.Warn("dropping delivery to a removed callback", "id", delivery.ID, "address", delivery.Address)
+			if err := d.store.RemoveDelivery(delivery.ID); err != nil {
+				log.Error(err, "failed to remove dropped delivery", "id", delivery.ID)
+			}
+			continue
+		}
+
+		err = d.send(ctx, delivery, callback.Secret)
+		if err == nil {
+			if err := d.store.RemoveDelivery(delivery.ID); err != nil {
+				log.Error(err, "failed to remove sent delivery", "id", delivery.ID)
+			}
+			continue
+		}
+
+		delivery.Attempts++
+		delivery.LastError = err.Error()
+		if delivery.Attempts >= d.maxAttempts {
+			delivery.Dead = true
+			log.Warn("giving up on delivery", "id", delivery.ID, "address", delivery.Address, "error", delivery.LastError)
+		} else {
+			delivery.NextAttemptAt = time.Now().Add(d.retryDelay(delivery.Attempts))
+		}
+
+		if err := d.store.UpdateDelivery(delivery); err != nil {
+			log.Error(err, "failed to update failed delivery", "id", delivery.ID)
+		}
+		return
+	}
+}
+
+// send POSTs the payload of a delivery to its callback signed with its secret, any non 2xx status is a failure
+func (d *Dispatcher) send(ctx context.Context, delivery Delivery, secret string) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(secret, delivery.Payload))
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// retryDelay returns the delay before the next attempt of a delivery that failed the given number of times.
+// The delay doubles on every failure up to maxRetryDelay, with jitter so failed deliveries are spread out.
+func (d *Dispatcher) retryDelay(attempts int) time.Duration {
+	delay := d.maxRetryDelay
+	if attempts <= 32 {
+		delay = min(d.minRetryDelay<<(attempts-1), d.maxRetryDelay)
+	}
+
+	half := delay / 2
+	return half + rand.N(half+1)
+}
+
+// Sign returns the signature of a payload as sent in SignatureHeader, receivers verify it
+// by computing the same HMAC-SHA256 of the request body with their secret
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,285 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HomayoonAlimohammadi/blockchain-parser/internal/parser"
+)
+
+// memoryStore is a minimal Store keeping the deliveries in insertion order
+type memoryStore struct {
+	mu         sync.Mutex
+	callbacks  map[string]parser.Callback
+	lastID     int64
+	deliveries []Delivery
+}
+
+// newMemoryStore creates a store holding a callback for the given address
+func newMemoryStore(address string, callback parser.Callback) *memoryStore {
+	return &memoryStore{callbacks: map[string]parser.Callback{address: callback}}
+}
+
+func (s *memoryStore) GetCallback(address string) (*parser.Callback, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	callback, ok := s.callbacks[address]
+	if !ok {
+		return nil, nil
+	}
+	return &callback, nil
+}
+
+func (s *memoryStore) removeCallback(address string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.callbacks, address)
+}
+
+func (s *memoryStore) AddDelivery(delivery Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+	delivery.ID = s.lastID
+	s.deliveries = append(s.deliveries, delivery)
+	return nil
+}
+
+func (s *memoryStore) GetDueDeliveries(now time.Time, limit int) ([]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []Delivery
+	for _, delivery := range s.deliveries {
+		if !delivery.Dead && !delivery.NextAttemptAt.After(now) && len(due) < limit {
+			due = append(due, delivery)
+		}
+	}
+	return due, nil
+}
+
+func (s *memoryStore) UpdateDelivery(delivery Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.deliveries {
+		if s.deliveries[i].ID == delivery.ID {
+			s.deliveries[i] = delivery
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) RemoveDelivery(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.deliveries {
+		if s.deliveries[i].ID == id {
+			s.deliveries = append(s.deliveries[:i], s.deliveries[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) GetDeadDeliveries() ([]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var dead []Delivery
+	for _, delivery := range s.deliveries {
+		if delivery.Dead {
+			dead = append(dead, delivery)
+		}
+	}
+	return dead, nil
+}
+
+func (s *memoryStore) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.deliveries)
+}
+
+func TestDispatcher_Signs(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		body      []byte
+		signature string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		body, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get(SignatureHeader)
+	}))
+	defer server.Close()
+
+	// The delivery is signed with the secret stored with the callback
+	callback := parser.Callback{URL: server.URL, Secret: "secret"}
+	store := newMemoryStore("0xAddress", callback)
+	dispatcher := NewDispatcher(server.Client(), store)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go dispatcher.Run(ctx)
+
+	event := parser.Event{Address: "0xAddress", Log: &parser.Log{TransactionHash: "0xa"}}
+	if err := dispatcher.Notify(callback, event); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for store.len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the delivery to be sent and removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if signature != Sign("secret", body) {
+		t.Fatalf("expected signature %q, got %q", Sign("secret", body), signature)
+	}
+}
+
+func TestDispatcher_DeadLetters(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	callback := parser.Callback{URL: server.URL, Secret: "secret"}
+	store := newMemoryStore("0xAddress", callback)
+	dispatcher := NewDispatcher(server.Client(), store)
+	dispatcher.maxAttempts = 3
+	dispatcher.minRetryDelay = 0
+
+	event := parser.Event{Address: "0xAddress", Transaction: &parser.Transaction{Hash: "0xa"}}
+	dispatcher.Notify(callback, event)
+
+	for range 5 {
+		dispatcher.dispatchDue(context.Background())
+	}
+
+	if attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts)
+	}
+
+	deadLetters, err := dispatcher.DeadLetters()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(deadLetters) != 1 {
+		t.Fatalf("expected 1 dead letter, got %d", len(deadLetters))
+	}
+	if deadLetters[0].Attempts != 3 || deadLetters[0].LastError != "unexpected status 500" || deadLetters[0].Event.Transaction.Hash != "0xa" {
+		t.Fatalf("unexpected dead letter %+v", deadLetters[0])
+	}
+}
+
+func TestDispatcher_RemovedCallback(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+	}))
+	defer server.Close()
+
+	callback := parser.Callback{URL: server.URL, Secret: "secret"}
+	store := newMemoryStore("0xAddress", callback)
+	dispatcher := NewDispatcher(server.Client(), store)
+
+	event := parser.Event{Address: "0xAddress", Transaction: &parser.Transaction{Hash: "0xa"}}
+	dispatcher.Notify(callback, event)
+
+	// The callback is removed by unsubscribing before the delivery is sent
+	store.removeCallback("0xAddress")
+	dispatcher.dispatchDue(context.Background())
+
+	if attempts != 0 {
+		t.Fatalf("expected no attempt, got %d", attempts)
+	}
+	if store.len() != 0 {
+		t.Fatalf("expected the delivery to be dropped, got %d queued", store.len())
+	}
+}
+
+func TestDispatcher_SlowCallback(t *testing.T) {
+	release := make(chan struct{})
+	slowAttempts := 0
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slowAttempts++
+		<-release
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer slow.Close()
+
+	sent := make(chan struct{}, 1)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent <- struct{}{}
+	}))
+	defer fast.Close()
+
+	slowCallback := parser.Callback{URL: slow.URL, Secret: "secret"}
+	fastCallback := parser.Callback{URL: fast.URL, Secret: "secret"}
+	store := newMemoryStore("0xSlow", slowCallback)
+	store.callbacks["0xFast"] = fastCallback
+	dispatcher := NewDispatcher(http.DefaultClient, store)
+
+	dispatcher.Notify(slowCallback, parser.Event{Address: "0xSlow", Transaction: &parser.Transaction{Hash: "0xa"}})
+	dispatcher.Notify(slowCallback, parser.Event{Address: "0xSlow", Transaction: &parser.Transaction{Hash: "0xb"}})
+	dispatcher.Notify(fastCallback, parser.Event{Address: "0xFast", Transaction: &parser.Transaction{Hash: "0xc"}})
+
+	done := make(chan struct{})
+	go func() {
+		dispatcher.dispatchDue(context.Background())
+		close(done)
+	}()
+
+	// The fast callback is not held up by the slow one
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the delivery to the fast callback to be sent while the slow one is pending")
+	}
+	close(release)
+	<-done
+
+	// The slow callback failing, its second delivery is left for the next poll
+	if slowAttempts != 1 {
+		t.Fatalf("expected 1 attempt to the slow callback, got %d", slowAttempts)
+	}
+	if store.len() != 2 {
+		t.Fatalf("expected the 2 deliveries to the slow callback to be queued, got %d", store.len())
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	dispatcher := NewDispatcher(http.DefaultClient, &memoryStore{})
+
+	for attempts, expected := range map[int]time.Duration{
+		1:   time.Second,
+		4:   8 * time.Second,
+		20:  time.Hour,
+		100: time.Hour,
+	} {
+		delay := dispatcher.retryDelay(attempts)
+		if delay < expected/2 || delay > expected {
+			t.Fatalf("expected a delay between %v and %v after %d attempts, got %v", expected/2, expected, attempts, delay)
+		}
+	}
+}
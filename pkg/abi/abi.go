@@ -0,0 +1,131 @@
+package abi
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// ErrUnknownEvent is returned when decoding a log emitted by none of the events of an ABI
+var ErrUnknownEvent = errors.New("unknown event")
+
+// Argument is a named argument of an event, or a named field of a tuple
+type Argument struct {
+	Name    string
+	Type    Type
+	Indexed bool
+}
+
+// Event is an event of a contract ABI
+type Event struct {
+	Name      string
+	Inputs    []Argument
+	Anonymous bool
+}
+
+// Signature returns the canonical signature of the event, e.g. Transfer(address,address,uint256)
+func (e Event) Signature() string {
+	types := make([]string, len(e.Inputs))
+	for i, input := range e.Inputs {
+		types[i] = input.Type.String()
+	}
+	return e.Name + "(" + strings.Join(types, ",") + ")"
+}
+
+// Topic returns the first topic of the logs emitting the event, the keccak256 hash of its signature
+func (e Event) Topic() string {
+	return "0x" + hex.EncodeToString(Keccak256([]byte(e.Signature())))
+}
+
+// ABI holds the events of a contract ABI, by topic
+type ABI struct {
+	events map[string]Event
+}
+
+// jsonArgument is an argument as written in ABI JSON
+type jsonArgument struct {
+	Name       string         `json:"name"`
+	Type       string         `json:"type"`
+	Indexed    bool           `json:"indexed"`
+	Components []jsonArgument `json:"components"`
+}
+
+// Parse parses a contract ABI JSON, only its events are kept. Anonymous events have no signature
+// topic to be recognised by, so they are skipped.
+func Parse(data []byte) (*ABI, error) {
+	var entries []struct {
+		Type      string         `json:"type"`
+		Name      string         `json:"name"`
+		Inputs    []jsonArgument `json:"inputs"`
+		Anonymous bool           `json:"anonymous"`
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal ABI: %w", err)
+	}
+
+	abi := &ABI{events: make(map[string]Event)}
+	for _, entry := range entries {
+		if entry.Type != "event" || entry.Anonymous {
+			continue
+		}
+
+		inputs, err := newArguments(entry.Inputs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid event %q: %w", entry.Name, err)
+		}
+
+		event := Event{Name: entry.Name, Inputs: inputs}
+		abi.events[event.Topic()] = event
+	}
+
+	return abi, nil
+}
+
+// newArguments converts arguments as written in ABI JSON
+func newArguments(args []jsonArgument) ([]Argument, error) {
+	arguments := make([]Argument, len(args))
+	for i, arg := range args {
+		var components []Argument
+		if len(arg.Components) > 0 {
+			var err error
+			if components, err = newArguments(arg.Components); err != nil {
+				return nil, err
+			}
+		}
+
+		typ, err := NewType(arg.Type, components)
+		if err != nil {
+			return nil, err
+		}
+
+		arguments[i] = Argument{Name: arg.Name, Type: typ, Indexed: arg.Indexed}
+	}
+
+	return arguments, nil
+}
+
+// Events returns the events of the ABI
+func (a *ABI) Events() []Event {
+	events := make([]Event, 0, len(a.events))
+	for _, event := range a.events {
+		events = append(events, event)
+	}
+	return events
+}
+
+// EventByTopic returns the event emitting logs with the given first topic
+func (a *ABI) EventByTopic(topic string) (Event, bool) {
+	event, ok := a.events[strings.ToLower(topic)]
+	return event, ok
+}
+
+// Keccak256 returns the legacy Keccak-256 hash used by Ethereum, which differs from SHA3-256 in its padding
+func Keccak256(data []byte) []byte {
+	hash := sha3.NewLegacyKeccak256()
+	hash.Write(data)
+	return hash.Sum(nil)
+}
@@ -0,0 +1,187 @@
+package abi
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const complexABI = `[
+	{"type": "function", "name": "transfer", "inputs": [{"name": "to", "type": "address"}, {"name": "value", "type": "uint256"}]},
+	{"type": "event", "name": "Ping", "anonymous": true, "inputs": []},
+	{"type": "event", "name": "Complex", "inputs": [
+		{"name": "sender", "type": "address", "indexed": true},
+		{"name": "tag", "type": "string", "indexed": true},
+		{"name": "amounts", "type": "uint256[]"},
+		{"name": "info", "type": "tuple", "components": [
+			{"name": "kind", "type": "uint8"},
+			{"name": "note", "type": "string"}
+		]},
+		{"name": "delta", "type": "int16"},
+		{"name": "payload", "type": "bytes"},
+		{"name": "codes", "type": "bytes2[2]"}
+	]}
+]`
+
+// words joins 32 bytes words into the hex data of a log
+func words(words ...string) string {
+	return "0x" + strings.Join(words, "")
+}
+
+func TestParse(t *testing.T) {
+	abi, err := Parse([]byte(complexABI))
+	assert.NoError(t, err)
+
+	// Functions and anonymous events are skipped
+	events := abi.Events()
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "Complex(address,string,uint256[],(uint8,string),int16,bytes,bytes2[2])", events[0].Signature())
+	}
+
+	transfer := Event{Name: "Transfer", Inputs: []Argument{
+		{Type: Type{Kind: KindAddress, name: "address"}},
+		{Type: Type{Kind: KindAddress, name: "address"}},
+		{Type: Type{Kind: KindUint, Size: 256, name: "uint256"}},
+	}}
+	assert.Equal(t, "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef", transfer.Topic())
+
+	_, err = Parse([]byte(`[{"type": "event", "name": "Bad", "inputs": [{"name": "x", "type": "uint7"}]}]`))
+	assert.ErrorContains(t, err, "uint7")
+}
+
+func TestNewType(t *testing.T) {
+	for typ, expected := range map[string]string{
+		"uint":                      "uint256",
+		"bytes32[3][]":              "bytes32[3][]",
+		"(uint256,(bool,string)[])": "(uint256,(bool,string)[])",
+		"(address,bytes)[2]":        "(address,bytes)[2]",
+		"function":                  "function",
+	} {
+		parsed, err := NewType(typ, nil)
+		assert.NoError(t, err, typ)
+		assert.Equal(t, expected, parsed.String())
+	}
+
+	// Arrays too large for any log are rejected, nesting them does not overflow their size
+	for _, typ := range []string{"uint257", "bytes33", "fixed128x18", "uint256[0]", "(uint256", "tuple", "uint256[4000000000]", "uint256[65536][65536][65536][65536]", "(uint256[32768],uint256[32768])"} {
+		_, err := NewType(typ, nil)
+		assert.Error(t, err, typ)
+	}
+}
+
+func TestDecodeLog(t *testing.T) {
+	abi, err := Parse([]byte(complexABI))
+	assert.NoError(t, err)
+
+	topics := []string{
+		abi.Events()[0].Topic(),
+		"0x00000000000000000000000028c6c06298d514db089934071355e5743bf21d60",
+		"0x1111111111111111111111111111111111111111111111111111111111111111",
+	}
+	data := words(
+		// Heads: amounts, info and payload offsets, delta and the two codes inline, then
+		// a padding word so the tails are only found through their offsets
+		"00000000000000000000000000000000000000000000000000000000000000e0",
+		"0000000000000000000000000000000000000000000000000000000000000140",
+		"fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffe",
+		"00000000000000000000000000000000000000000000000000000000000001c0",
+		"1234000000000000000000000000000000000000000000000000000000000000",
+		"5678000000000000000000000000000000000000000000000000000000000000",
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		// amounts: [1, 2^64]
+		"0000000000000000000000000000000000000000000000000000000000000002",
+		"0000000000000000000000000000000000000000000000000000000000000001",
+		"0000000000000000000000000000000000000000000000010000000000000000",
+		// info: (3, "hello"), the string offset is relative to the tuple
+		"0000000000000000000000000000000000000000000000000000000000000003",
+		"0000000000000000000000000000000000000000000000000000000000000040",
+		"0000000000000000000000000000000000000000000000000000000000000005",
+		"68656c6c6f000000000000000000000000000000000000000000000000000000",
+		// payload: 0xabcdef
+		"0000000000000000000000000000000000000000000000000000000000000003",
+		"abcdef0000000000000000000000000000000000000000000000000000000000",
+	)
+
+	decoded, err := abi.DecodeLog(topics, data)
+	assert.NoError(t, err)
+	assert.Equal(t, "Complex", decoded.Name)
+
+	args := map[string]Arg{}
+	for _, arg := range decoded.Args {
+		args[arg.Name] = arg
+	}
+
+	assert.Equal(t, "0x28c6c06298d514db089934071355e5743bf21d60", args["sender"].Value)
+	assert.True(t, args["tag"].Hashed)
+	assert.Equal(t, topics[2], args["tag"].Value)
+	assert.Equal(t, []any{big.NewInt(1), new(big.Int).Lsh(big.NewInt(1), 64)}, args["amounts"].Value)
+	assert.Equal(t, big.NewInt(-2), args["delta"].Value)
+	assert.Equal(t, []byte{0xab, 0xcd, 0xef}, args["payload"].Value)
+	assert.Equal(t, []any{[]byte{0x12, 0x34}, []byte{0x56, 0x78}}, args["codes"].Value)
+
+	info, ok := args["info"].Value.([]Arg)
+	if assert.True(t, ok) && assert.Len(t, info, 2) {
+		assert.Equal(t, "kind", info[0].Name)
+		assert.Equal(t, big.NewInt(3), info[0].Value)
+		assert.Equal(t, "hello", info[1].Value)
+	}
+
+	encoded, err := json.Marshal(decoded)
+	assert.NoError(t, err)
+	assert.Contains(t, string(encoded), `{"name":"amounts","type":"uint256[]","value":["1","18446744073709551616"]}`)
+	assert.Contains(t, string(encoded), `{"name":"info","type":"(uint8,string)","value":[{"name":"kind","type":"uint8","value":"3"},{"name":"note","type":"string","value":"hello"}]}`)
+
+	// Decoding the JSON restores the Go types
+	var roundTrip DecodedEvent
+	assert.NoError(t, json.Unmarshal(encoded, &roundTrip))
+	reencoded, err := json.Marshal(roundTrip)
+	assert.NoError(t, err)
+	assert.JSONEq(t, string(encoded), string(reencoded))
+	assert.IsType(t, &big.Int{}, roundTrip.Args[4].Value)
+
+	_, err = abi.DecodeLog([]string{"0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"}, "0x")
+	assert.ErrorIs(t, err, ErrUnknownEvent)
+
+	// Truncated data and offsets pointing outside of it are rejected
+	_, err = abi.DecodeLog(topics, data[:len(data)-64])
+	assert.Error(t, err)
+	_, err = abi.DecodeLog(topics, strings.Replace(data, "00000000000000000000000000000000000000000000000000000000000000e0", "0000000000000000000000000000000000000000000000000000000000010000", 1))
+	assert.Error(t, err)
+}
+
+func TestDecodeLog_ArrayOutOfBounds(t *testing.T) {
+	abi, err := Parse([]byte(`[
+		{"type": "event", "name": "Static", "inputs": [{"name": "values", "type": "uint256[30000]"}]},
+		{"type": "event", "name": "Dynamic", "inputs": [{"name": "values", "type": "string[30000]"}]}
+	]`))
+	assert.NoError(t, err)
+
+	// The array lengths are checked against the data before allocating their elements
+	for _, event := range abi.Events() {
+		_, err := event.Decode(nil, "0x")
+		assert.ErrorContains(t, err, "out of bounds", event.Name)
+
+		_, err = event.Decode(nil, words("0000000000000000000000000000000000000000000000000000000000000020"))
+		assert.ErrorContains(t, err, "out of bounds", event.Name)
+	}
+}
+
+func TestDecodeLog_AliasedOffsets(t *testing.T) {
+	const depth = 40
+	abi, err := Parse([]byte(`[{"type": "event", "name": "Nested", "inputs": [{"name": "values", "type": "uint256` + strings.Repeat("[]", depth) + `"}]}]`))
+	assert.NoError(t, err)
+
+	// Both elements of every slice point at the same next slice, which would decode 2^depth values
+	encoded := []string{fmt.Sprintf("%064x", wordSize)}
+	for range depth - 1 {
+		encoded = append(encoded, fmt.Sprintf("%064x", 2), fmt.Sprintf("%064x", 2*wordSize), fmt.Sprintf("%064x", 2*wordSize))
+	}
+	encoded = append(encoded, fmt.Sprintf("%064x", 2), fmt.Sprintf("%064x", 1), fmt.Sprintf("%064x", 1))
+
+	_, err = abi.Events()[0].Decode(nil, words(encoded...))
+	assert.ErrorContains(t, err, "more values than its")
+}
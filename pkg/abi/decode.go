@@ -0,0 +1,432 @@
+package abi
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/eth/quantity"
+)
+
+// DecodedEvent is a log decoded with the event that emitted it
+type DecodedEvent struct {
+	Name      string `json:"name"`
+	Signature string `json:"signature"`
+	Args      []Arg  `json:"args"`
+}
+
+// Arg is a decoded argument of an event, or a decoded field of a tuple. Its value is:
+//   - *big.Int for integers
+//   - string for addresses, as lowercase hex, and strings
+//   - bool for booleans
+//   - []byte for fixed and dynamic bytes
+//   - []any for arrays and slices, holding values of their element type
+//   - []Arg for tuples
+//
+// Indexed arguments of other types than the elementary ones are stored as the keccak256 hash
+// of their encoding, their value is then the hash as hex and Hashed is set.
+type Arg struct {
+	Name    string
+	Type    Type
+	Indexed bool
+	Hashed  bool
+	Value   any
+}
+
+// DecodeLog decodes a log emitted by one of the events of the ABI
+func (a *ABI) DecodeLog(topics []string, data string) (*DecodedEvent, error) {
+	if len(topics) == 0 {
+		return nil, ErrUnknownEvent
+	}
+
+	event, ok := a.EventByTopic(topics[0])
+	if !ok {
+		return nil, ErrUnknownEvent
+	}
+
+	args, err := event.Decode(topics[1:], data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode event %s: %w", event.Signature(), err)
+	}
+
+	return &DecodedEvent{Name: event.Name, Signature: event.Signature(), Args: args}, nil
+}
+
+// Decode decodes the arguments of an event from the indexed topics, without the signature topic,
+// and the data of a log
+func (e Event) Decode(topics []string, data string) ([]Arg, error) {
+	raw, err := decodeHex(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data: %w", err)
+	}
+
+	var nonIndexed []Type
+	for _, input := range e.Inputs {
+		if !input.Indexed {
+			nonIndexed = append(nonIndexed, input.Type)
+		}
+	}
+
+	values, err := newDecoder(raw).decodeSequence(nonIndexed, raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data: %w", err)
+	}
+
+	args := make([]Arg, 0, len(e.Inputs))
+	for _, input := range e.Inputs {
+		arg := Arg{Name: input.Name, Type: input.Type, Indexed: input.Indexed}
+
+		if !input.Indexed {
+			arg.Value, values = values[0], values[1:]
+			args = append(args, arg)
+			continue
+		}
+
+		if len(topics) == 0 {
+			return nil, fmt.Errorf("missing topic of indexed argument %q", input.Name)
+		}
+		topic := topics[0]
+		topics = topics[1:]
+
+		word, err := decodeHex(topic)
+		if err != nil || len(word) != wordSize {
+			return nil, fmt.Errorf("invalid topic %q of indexed argument %q", topic, input.Name)
+		}
+
+		if input.Type.IsValue() {
+			if arg.Value, err = newDecoder(word).decodeValue(input.Type, word, 0); err != nil {
+				return nil, fmt.Errorf("invalid topic %q of indexed argument %q: %w", topic, input.Name, err)
+			}
+		} else {
+			arg.Hashed = true
+			arg.Value = "0x" + hex.EncodeToString(word)
+		}
+
+		args = append(args, arg)
+	}
+
+	if len(topics) != 0 {
+		return nil, fmt.Errorf("unexpected %d extra topics", len(topics))
+	}
+
+	return args, nil
+}
+
+// decoder decodes the values encoded in some data, within a budget of words. Every value takes at least
+// a word of a valid encoding, so decoding more values than the data has words means the offsets point
+// at the same parts several times, which nested slices would make take exponential time.
+type decoder struct {
+	remaining int
+	words     int
+}
+
+// newDecoder returns a decoder of the values encoded in the given data
+func newDecoder(data []byte) *decoder {
+	return &decoder{remaining: len(data) / wordSize, words: len(data) / wordSize}
+}
+
+// consume takes a word of the budget, failing once it is exhausted
+func (d *decoder) consume() error {
+	if d.remaining == 0 {
+		return fmt.Errorf("data decodes to more values than its %d words", d.words)
+	}
+
+	d.remaining--
+	return nil
+}
+
+// decodeSequence decodes the values of consecutive types, whose dynamic parts are referenced by offsets
+// relative to the start of the block
+func (d *decoder) decodeSequence(types []Type, block []byte) ([]any, error) {
+	values := make([]any, len(types))
+	position := 0
+	for i, typ := range types {
+		value, err := d.decodeValue(typ, block, position)
+		if err != nil {
+			return nil, err
+		}
+
+		values[i] = value
+		position += typ.headSize()
+	}
+
+	return values, nil
+}
+
+// decodeValue decodes a value whose head is at the given position of a block
+func (d *decoder) decodeValue(typ Type, block []byte, position int) (any, error) {
+	if typ.IsDynamic() {
+		offset, err := readLength(block, position)
+		if err != nil {
+			return nil, err
+		} else if offset > len(block) {
+			return nil, fmt.Errorf("offset %d out of bounds", offset)
+		}
+
+		return d.decodeTail(typ, block[offset:])
+	}
+
+	switch typ.Kind {
+	case KindArray:
+		// Every element takes at least a word, so the length is bounded before allocating
+		block = block[min(position, len(block)):]
+		if typ.Length > len(block)/wordSize {
+			return nil, fmt.Errorf("array length %d out of bounds", typ.Length)
+		}
+
+		elems := make([]Type, typ.Length)
+		for i := range elems {
+			elems[i] = *typ.Elem
+		}
+		return d.decodeSequence(elems, block)
+	case KindTuple:
+		return d.decodeTuple(typ, block[min(position, len(block)):])
+	}
+
+	word, err := readWord(block, position)
+	if err != nil {
+		return nil, err
+	} else if err := d.consume(); err != nil {
+		return nil, err
+	}
+
+	switch typ.Kind {
+	case KindUint:
+		return new(big.Int).SetBytes(word), nil
+	case KindInt:
+		value := new(big.Int).SetBytes(word)
+		if word[0]&0x80 != 0 {
+			value.Sub(value, new(big.Int).Lsh(big.NewInt(1), 8*wordSize))
+		}
+		return value, nil
+	case KindAddress:
+		return "0x" + hex.EncodeToString(word[wordSize-20:]), nil
+	case KindBool:
+		return word[wordSize-1] != 0, nil
+	case KindFixedBytes:
+		return word[:typ.Size], nil
+	}
+
+	return nil, fmt.Errorf("unsupported type %s", typ)
+}
+
+// decodeTail decodes the dynamic part of a value
+func (d *decoder) decodeTail(typ Type, tail []byte) (any, error) {
+	if typ.Kind == KindBytes || typ.Kind == KindString || typ.Kind == KindSlice {
+		// The length word
+		if err := d.consume(); err != nil {
+			return nil, err
+		}
+	}
+
+	switch typ.Kind {
+	case KindBytes, KindString:
+		length, err := readLength(tail, 0)
+		if err != nil {
+			return nil, err
+		} else if length > len(tail)-wordSize {
+			return nil, fmt.Errorf("length %d out of bounds", length)
+		}
+
+		content := tail[wordSize : wordSize+length]
+		if typ.Kind == KindString {
+			return string(content), nil
+		}
+		return content, nil
+	case KindSlice:
+		length, err := readLength(tail, 0)
+		if err != nil {
+			return nil, err
+		} else if length > (len(tail)-wordSize)/wordSize {
+			// Every element takes at least a word, so the length is bounded before allocating
+			return nil, fmt.Errorf("length %d out of bounds", length)
+		}
+
+		elems := make([]Type, length)
+		for i := range elems {
+			elems[i] = *typ.Elem
+		}
+		return d.decodeSequence(elems, tail[wordSize:])
+	case KindArray:
+		if typ.Length > len(tail)/wordSize {
+			return nil, fmt.Errorf("array length %d out of bounds", typ.Length)
+		}
+
+		elems := make([]Type, typ.Length)
+		for i := range elems {
+			elems[i] = *typ.Elem
+		}
+		return d.decodeSequence(elems, tail)
+	case KindTuple:
+		return d.decodeTuple(typ, tail)
+	}
+
+	return nil, fmt.Errorf("unsupported dynamic type %s", typ)
+}
+
+// decodeTuple decodes the fields of a tuple encoded at the start of a block
+func (d *decoder) decodeTuple(typ Type, block []byte) ([]Arg, error) {
+	types := make([]Type, len(typ.Components))
+	for i, component := range typ.Components {
+		types[i] = component.Type
+	}
+
+	values, err := d.decodeSequence(types, block)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make([]Arg, len(values))
+	for i, value := range values {
+		fields[i] = Arg{Name: typ.Components[i].Name, Type: types[i], Value: value}
+	}
+
+	return fields, nil
+}
+
+// readWord returns the word at the given position of a block
+func readWord(block []byte, position int) ([]byte, error) {
+	if position < 0 || position+wordSize > len(block) {
+		return nil, fmt.Errorf("word at %d out of bounds of %d bytes", position, len(block))
+	}
+	return block[position : position+wordSize], nil
+}
+
+// readLength returns the word at the given position of a block as an offset or a length
+func readLength(block []byte, position int) (int, error) {
+	word, err := readWord(block, position)
+	if err != nil {
+		return 0, err
+	}
+
+	length := new(big.Int).SetBytes(word)
+	if !length.IsInt64() || length.Int64() > int64(len(block)) {
+		return 0, fmt.Errorf("length %s out of bounds of %d bytes", length, len(block))
+	}
+
+	return int(length.Int64()), nil
+}
+
+// decodeHex decodes a 0x prefixed hex string
+func decodeHex(s string) ([]byte, error) {
+	raw, ok := strings.CutPrefix(s, "0x")
+	if !ok {
+		return nil, fmt.Errorf("missing 0x prefix in %q", s)
+	}
+	return hex.DecodeString(raw)
+}
+
+// argJSON is an argument as encoded in JSON, with its type as its canonical name
+type argJSON struct {
+	Name    string          `json:"name"`
+	Type    string          `json:"type"`
+	Indexed bool            `json:"indexed,omitempty"`
+	Hashed  bool            `json:"hashed,omitempty"`
+	Value   json.RawMessage `json:"value"`
+}
+
+// MarshalJSON encodes the argument, integers as decimal strings so they keep their precision
+// and bytes as 0x prefixed hex
+func (a Arg) MarshalJSON() ([]byte, error) {
+	var value any = a.Value
+	if !a.Hashed {
+		value = encodeValue(a.Value)
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(argJSON{Name: a.Name, Type: a.Type.String(), Indexed: a.Indexed, Hashed: a.Hashed, Value: raw})
+}
+
+// UnmarshalJSON decodes an argument encoded by MarshalJSON, restoring the Go types of its value
+func (a *Arg) UnmarshalJSON(data []byte) error {
+	var raw argJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	typ, err := NewType(raw.Type, nil)
+	if err != nil {
+		return err
+	}
+
+	*a = Arg{Name: raw.Name, Type: typ, Indexed: raw.Indexed, Hashed: raw.Hashed}
+	if raw.Hashed {
+		return json.Unmarshal(raw.Value, &a.Value)
+	}
+
+	a.Value, err = unmarshalValue(typ, raw.Value)
+	return err
+}
+
+// encodeValue converts a decoded value to its JSON representation
+func encodeValue(value any) any {
+	switch value := value.(type) {
+	case *big.Int:
+		return quantity.Decimal(value)
+	case []byte:
+		return "0x" + hex.EncodeToString(value)
+	case []any:
+		encoded := make([]any, len(value))
+		for i, elem := range value {
+			encoded[i] = encodeValue(elem)
+		}
+		return encoded
+	default:
+		// Strings, booleans and tuples, whose fields encode themselves
+		return value
+	}
+}
+
+// unmarshalValue converts the JSON representation of a value of the given type back to its Go type
+func unmarshalValue(typ Type, raw json.RawMessage) (any, error) {
+	switch typ.Kind {
+	case KindUint, KindInt:
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, err
+		}
+
+		return quantity.ParseDecimal(s)
+	case KindAddress, KindString:
+		var s string
+		err := json.Unmarshal(raw, &s)
+		return s, err
+	case KindBool:
+		var b bool
+		err := json.Unmarshal(raw, &b)
+		return b, err
+	case KindFixedBytes, KindBytes:
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, err
+		}
+		return decodeHex(s)
+	case KindArray, KindSlice:
+		var elems []json.RawMessage
+		if err := json.Unmarshal(raw, &elems); err != nil {
+			return nil, err
+		}
+
+		values := make([]any, len(elems))
+		for i, elem := range elems {
+			value, err := unmarshalValue(*typ.Elem, elem)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = value
+		}
+		return values, nil
+	case KindTuple:
+		var fields []Arg
+		err := json.Unmarshal(raw, &fields)
+		return fields, err
+	}
+
+	return nil, fmt.Errorf("unsupported type %s", typ)
+}
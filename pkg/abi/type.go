@@ -0,0 +1,252 @@
+package abi
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Kind is the kind of an ABI type
+type Kind int
+
+const (
+	KindUint Kind = iota
+	KindInt
+	KindAddress
+	KindBool
+	// KindFixedBytes is bytes1 to bytes32, and function which is encoded as bytes24
+	KindFixedBytes
+	KindBytes
+	KindString
+	// KindSlice is a dynamically sized array, T[]
+	KindSlice
+	// KindArray is a fixed size array, T[k]
+	KindArray
+	KindTuple
+)
+
+const (
+	// wordSize is the size of an ABI encoded word
+	wordSize = 32
+	// maxHeadSize bounds the size a type takes in the head of an encoding, so the lengths of arrays
+	// too large for any log are rejected when parsing rather than overflowing the head offsets
+	maxHeadSize = 1 << 20
+)
+
+// Type is an ABI type
+type Type struct {
+	Kind Kind
+	// Size is the number of bits of integers and the number of bytes of fixed bytes
+	Size int
+	// Elem is the element type of arrays and slices
+	Elem *Type
+	// Length is the number of elements of arrays
+	Length int
+	// Components are the field types of tuples, along with their names
+	Components []Argument
+
+	// name is the canonical name of elementary types, e.g. uint256 for uint
+	name string
+}
+
+// NewType parses a type as written in ABI JSON, the components are the fields of tuples
+func NewType(typ string, components []Argument) (Type, error) {
+	if strings.HasSuffix(typ, "]") {
+		open := strings.LastIndex(typ, "[")
+		if open < 0 {
+			return Type{}, fmt.Errorf("invalid type %q, unbalanced brackets", typ)
+		}
+
+		elem, err := NewType(typ[:open], components)
+		if err != nil {
+			return Type{}, err
+		}
+
+		if size := typ[open+1 : len(typ)-1]; size != "" {
+			length, err := strconv.Atoi(size)
+			if err != nil || length <= 0 {
+				return Type{}, fmt.Errorf("invalid type %q, invalid array length %q", typ, size)
+			} else if length > maxHeadSize/elem.headSize() {
+				return Type{}, fmt.Errorf("invalid type %q, array length %d too large", typ, length)
+			}
+			return Type{Kind: KindArray, Elem: &elem, Length: length}, nil
+		}
+
+		return Type{Kind: KindSlice, Elem: &elem}, nil
+	}
+
+	if typ == "tuple" {
+		if len(components) == 0 {
+			return Type{}, fmt.Errorf("invalid type %q, tuples need components", typ)
+		}
+		return newTuple(typ, components)
+	}
+
+	if strings.HasPrefix(typ, "(") && strings.HasSuffix(typ, ")") {
+		return parseTuple(typ)
+	}
+
+	return parseElementary(typ)
+}
+
+// parseTuple parses a tuple written as its canonical type, e.g. (uint256,address[]), its fields are unnamed
+func parseTuple(typ string) (Type, error) {
+	inner := typ[1 : len(typ)-1]
+	if inner == "" {
+		return Type{}, fmt.Errorf("invalid type %q, tuples need components", typ)
+	}
+
+	var (
+		components []Argument
+		depth      int
+		start      int
+	)
+	for i := 0; i <= len(inner); i++ {
+		if i < len(inner) {
+			switch inner[i] {
+			case '(':
+				depth++
+				continue
+			case ')':
+				depth--
+				continue
+			case ',':
+				if depth > 0 {
+					continue
+				}
+			default:
+				continue
+			}
+		}
+
+		component, err := NewType(inner[start:i], nil)
+		if err != nil {
+			return Type{}, err
+		}
+		components = append(components, Argument{Type: component})
+		start = i + 1
+	}
+
+	if depth != 0 {
+		return Type{}, fmt.Errorf("invalid type %q, unbalanced parentheses", typ)
+	}
+
+	return newTuple(typ, components)
+}
+
+// newTuple returns a tuple of the given components, checking the size of its head. Every component
+// is at most maxHeadSize, and there are too few of them for the sum to overflow.
+func newTuple(typ string, components []Argument) (Type, error) {
+	tuple := Type{Kind: KindTuple, Components: components}
+	if tuple.headSize() > maxHeadSize {
+		return Type{}, fmt.Errorf("invalid type %q, tuple too large", typ)
+	}
+
+	return tuple, nil
+}
+
+// parseElementary parses a type that is neither an array nor a tuple
+func parseElementary(typ string) (Type, error) {
+	switch typ {
+	case "address":
+		return Type{Kind: KindAddress, name: typ}, nil
+	case "bool":
+		return Type{Kind: KindBool, name: typ}, nil
+	case "bytes":
+		return Type{Kind: KindBytes, name: typ}, nil
+	case "string":
+		return Type{Kind: KindString, name: typ}, nil
+	case "function":
+		return Type{Kind: KindFixedBytes, Size: 24, name: typ}, nil
+	case "uint", "int":
+		return parseElementary(typ + "256")
+	}
+
+	for prefix, kind := range map[string]Kind{"uint": KindUint, "int": KindInt, "bytes": KindFixedBytes} {
+		size, ok := strings.CutPrefix(typ, prefix)
+		if !ok {
+			continue
+		}
+
+		n, err := strconv.Atoi(size)
+		if err != nil {
+			break
+		}
+
+		if kind == KindFixedBytes {
+			if n < 1 || n > wordSize {
+				return Type{}, fmt.Errorf("invalid type %q, expected 1 to 32 bytes", typ)
+			}
+		} else if n < 8 || n > 256 || n%8 != 0 {
+			return Type{}, fmt.Errorf("invalid type %q, expected a multiple of 8 bits up to 256", typ)
+		}
+
+		return Type{Kind: kind, Size: n, name: typ}, nil
+	}
+
+	return Type{}, fmt.Errorf("unsupported type %q", typ)
+}
+
+// String returns the canonical name of the type, as used in event signatures
+func (t Type) String() string {
+	switch t.Kind {
+	case KindSlice:
+		return t.Elem.String() + "[]"
+	case KindArray:
+		return t.Elem.String() + "[" + strconv.Itoa(t.Length) + "]"
+	case KindTuple:
+		names := make([]string, len(t.Components))
+		for i, component := range t.Components {
+			names[i] = component.Type.String()
+		}
+		return "(" + strings.Join(names, ",") + ")"
+	default:
+		return t.name
+	}
+}
+
+// IsDynamic checks whether the encoding of the type is referenced by an offset rather than inlined
+func (t Type) IsDynamic() bool {
+	switch t.Kind {
+	case KindBytes, KindString, KindSlice:
+		return true
+	case KindArray:
+		return t.Elem.IsDynamic()
+	case KindTuple:
+		for _, component := range t.Components {
+			if component.Type.IsDynamic() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsValue checks whether the type is an elementary value type, the only ones not hashed when indexed
+func (t Type) IsValue() bool {
+	switch t.Kind {
+	case KindUint, KindInt, KindAddress, KindBool, KindFixedBytes:
+		return true
+	}
+	return false
+}
+
+// headSize returns the size the type takes in the head of an encoding
+func (t Type) headSize() int {
+	if t.IsDynamic() {
+		return wordSize
+	}
+
+	switch t.Kind {
+	case KindArray:
+		return t.Length * t.Elem.headSize()
+	case KindTuple:
+		size := 0
+		for _, component := range t.Components {
+			size += component.Type.headSize()
+		}
+		return size
+	default:
+		return wordSize
+	}
+}
@@ -0,0 +1,40 @@
+package erc20
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// BalanceOfSelector is the first 4 bytes of the keccak256 hash of balanceOf(address), the eth_call data selecting it
+const BalanceOfSelector = "0x70a08231"
+
+// EncodeBalanceOf returns the eth_call data of balanceOf(owner): the selector followed by the owner left padded to 32 bytes
+func EncodeBalanceOf(owner string) (string, error) {
+	digits, ok := strings.CutPrefix(strings.ToLower(owner), "0x")
+	if !ok || len(digits) != 40 || strings.Trim(digits, "0123456789abcdef") != "" {
+		return "", fmt.Errorf("invalid owner %q, expected a 0x prefixed address", owner)
+	}
+
+	return BalanceOfSelector + strings.Repeat("0", wordLength-40) + digits, nil
+}
+
+// DecodeBalance decodes the uint256 returned by a balanceOf call. Calling an account that is not a contract
+// returns no data at all.
+func DecodeBalance(result string) (*big.Int, error) {
+	word, ok := strings.CutPrefix(result, "0x")
+	if !ok {
+		return nil, fmt.Errorf("invalid result %q, missing 0x prefix", result)
+	} else if word == "" {
+		return nil, fmt.Errorf("empty result, the token is not a contract")
+	} else if len(word) != wordLength {
+		return nil, fmt.Errorf("invalid result %q, expected a single 32 bytes word", result)
+	}
+
+	balance, ok := new(big.Int).SetString(word, 16)
+	if !ok {
+		return nil, fmt.Errorf("invalid result %q, expected hex", result)
+	}
+
+	return balance, nil
+}
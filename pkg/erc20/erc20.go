@@ -0,0 +1,162 @@
+package erc20
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/eth/quantity"
+)
+
+const (
+	// TransferTopic is the keccak256 hash of Transfer(address,address,uint256)
+	TransferTopic = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+	// ApprovalTopic is the keccak256 hash of Approval(address,address,uint256)
+	ApprovalTopic = "0x8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925"
+
+	// wordLength is the length of a hex encoded 32 bytes ABI word, without the 0x prefix
+	wordLength = 64
+)
+
+// ErrNotERC20 is returned when a log is not the expected ERC-20 event. ERC-721 transfers share the
+// signature of ERC-20 ones, but their token ID is indexed, so they have four topics and no data.
+var ErrNotERC20 = errors.New("not an ERC-20 event")
+
+// Transfer is a decoded Transfer(address indexed from, address indexed to, uint256 value) event
+type Transfer struct {
+	From  string
+	To    string
+	Value *big.Int
+}
+
+// Approval is a decoded Approval(address indexed owner, address indexed spender, uint256 value) event
+type Approval struct {
+	Owner   string
+	Spender string
+	Value   *big.Int
+}
+
+// DecodeTransfer decodes the topics and data of a log emitting an ERC-20 Transfer event
+func DecodeTransfer(topics []string, data string) (*Transfer, error) {
+	from, to, value, err := decode(TransferTopic, topics, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Transfer{From: from, To: to, Value: value}, nil
+}
+
+// DecodeApproval decodes the topics and data of a log emitting an ERC-20 Approval event
+func DecodeApproval(topics []string, data string) (*Approval, error) {
+	owner, spender, value, err := decode(ApprovalTopic, topics, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Approval{Owner: owner, Spender: spender, Value: value}, nil
+}
+
+// decode decodes an event with the given signature, two indexed addresses and an uint256 as data
+func decode(signature string, topics []string, data string) (string, string, *big.Int, error) {
+	if len(topics) != 3 || !strings.EqualFold(topics[0], signature) {
+		return "", "", nil, ErrNotERC20
+	}
+
+	first, err := decodeAddress(topics[1])
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to decode topic 1: %w", err)
+	}
+
+	second, err := decodeAddress(topics[2])
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to decode topic 2: %w", err)
+	}
+
+	word, ok := strings.CutPrefix(data, "0x")
+	if !ok || len(word) != wordLength {
+		return "", "", nil, fmt.Errorf("invalid data %q, expected a single 32 bytes word", data)
+	}
+
+	value, ok := new(big.Int).SetString(word, 16)
+	if !ok {
+		return "", "", nil, fmt.Errorf("invalid data %q, expected hex", data)
+	}
+
+	return first, second, value, nil
+}
+
+// decodeAddress decodes an address left padded to 32 bytes in a topic
+func decodeAddress(topic string) (string, error) {
+	word, ok := strings.CutPrefix(topic, "0x")
+	if !ok || len(word) != wordLength {
+		return "", fmt.Errorf("invalid topic %q, expected a 32 bytes word", topic)
+	}
+
+	if strings.Trim(word[:wordLength-40], "0") != "" {
+		return "", fmt.Errorf("invalid topic %q, expected a left padded address", topic)
+	}
+
+	address := strings.ToLower(word[wordLength-40:])
+	if strings.Trim(address, "0123456789abcdef") != "" {
+		return "", fmt.Errorf("invalid topic %q, expected hex", topic)
+	}
+
+	return "0x" + address, nil
+}
+
+// transferJSON and approvalJSON hold the values as decimal strings, see quantity.Decimal
+type transferJSON struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Value string `json:"value"`
+}
+
+type approvalJSON struct {
+	Owner   string `json:"owner"`
+	Spender string `json:"spender"`
+	Value   string `json:"value"`
+}
+
+// MarshalJSON encodes the transfer with its value as a decimal string
+func (t Transfer) MarshalJSON() ([]byte, error) {
+	return json.Marshal(transferJSON{From: t.From, To: t.To, Value: quantity.Decimal(t.Value)})
+}
+
+// UnmarshalJSON decodes a transfer encoded by MarshalJSON
+func (t *Transfer) UnmarshalJSON(data []byte) error {
+	var raw transferJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	value, err := quantity.ParseDecimal(raw.Value)
+	if err != nil {
+		return err
+	}
+
+	*t = Transfer{From: raw.From, To: raw.To, Value: value}
+	return nil
+}
+
+// MarshalJSON encodes the approval with its value as a decimal string
+func (a Approval) MarshalJSON() ([]byte, error) {
+	return json.Marshal(approvalJSON{Owner: a.Owner, Spender: a.Spender, Value: quantity.Decimal(a.Value)})
+}
+
+// UnmarshalJSON decodes an approval encoded by MarshalJSON
+func (a *Approval) UnmarshalJSON(data []byte) error {
+	var raw approvalJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	value, err := quantity.ParseDecimal(raw.Value)
+	if err != nil {
+		return err
+	}
+
+	*a = Approval{Owner: raw.Owner, Spender: raw.Spender, Value: value}
+	return nil
+}
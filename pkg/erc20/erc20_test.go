@@ -0,0 +1,88 @@
+package erc20
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	fromTopic = "0x00000000000000000000000028c6c06298d514db089934071355e5743bf21d60"
+	toTopic   = "0x000000000000000000000000A0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+)
+
+func TestDecodeTransfer(t *testing.T) {
+	// 2^64 + 1 does not fit in an uint64
+	data := "0x0000000000000000000000000000000000000000000000010000000000000001"
+
+	transfer, err := DecodeTransfer([]string{TransferTopic, fromTopic, toTopic}, data)
+	assert.NoError(t, err)
+	assert.Equal(t, "0x28c6c06298d514db089934071355e5743bf21d60", transfer.From)
+	assert.Equal(t, "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48", transfer.To)
+	assert.Equal(t, "18446744073709551617", transfer.Value.String())
+
+	encoded, err := json.Marshal(transfer)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"from":"0x28c6c06298d514db089934071355e5743bf21d60","to":"0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48","value":"18446744073709551617"}`, string(encoded))
+
+	var decoded Transfer
+	assert.NoError(t, json.Unmarshal(encoded, &decoded))
+	assert.Equal(t, *transfer, decoded)
+}
+
+func TestDecodeApproval(t *testing.T) {
+	data := "0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"
+
+	approval, err := DecodeApproval([]string{ApprovalTopic, fromTopic, toTopic}, data)
+	assert.NoError(t, err)
+	assert.Equal(t, "0x28c6c06298d514db089934071355e5743bf21d60", approval.Owner)
+	assert.Equal(t, "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48", approval.Spender)
+
+	maxUint256 := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+	assert.Equal(t, maxUint256, approval.Value)
+
+	encoded, err := json.Marshal(approval)
+	assert.NoError(t, err)
+	assert.Contains(t, string(encoded), `"value":"`+maxUint256.String()+`"`)
+}
+
+func TestDecode_Invalid(t *testing.T) {
+	data := "0x0000000000000000000000000000000000000000000000000000000000000001"
+
+	// An ERC-721 transfer indexes its token ID
+	_, err := DecodeTransfer([]string{TransferTopic, fromTopic, toTopic, "0x01"}, "0x")
+	assert.ErrorIs(t, err, ErrNotERC20)
+
+	_, err = DecodeTransfer([]string{ApprovalTopic, fromTopic, toTopic}, data)
+	assert.ErrorIs(t, err, ErrNotERC20)
+
+	_, err = DecodeTransfer([]string{TransferTopic, fromTopic, toTopic}, "0x01")
+	assert.ErrorContains(t, err, "invalid data")
+
+	_, err = DecodeTransfer([]string{TransferTopic, "0x1111111111111111111111111111111111111111111111111111111111111111", toTopic}, data)
+	assert.ErrorContains(t, err, "left padded address")
+}
+
+func TestEncodeBalanceOf(t *testing.T) {
+	data, err := EncodeBalanceOf("0x28C6c06298d514Db089934071355E5743bf21d60")
+	assert.NoError(t, err)
+	assert.Equal(t, "0x70a08231"+fromTopic[2:], data)
+
+	_, err = EncodeBalanceOf("0x28c6")
+	assert.Error(t, err)
+}
+
+func TestDecodeBalance(t *testing.T) {
+	balance, err := DecodeBalance("0x0000000000000000000000000000000000000000000000010000000000000001")
+	assert.NoError(t, err)
+	assert.Equal(t, "18446744073709551617", balance.String())
+
+	// Not a contract
+	_, err = DecodeBalance("0x")
+	assert.ErrorContains(t, err, "not a contract")
+
+	_, err = DecodeBalance("0x01")
+	assert.Error(t, err)
+}
@@ -0,0 +1,100 @@
+package address
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// Length is the number of bytes of an address
+const Length = 20
+
+// ErrInvalid is returned when parsing a string that is not an address, or whose EIP-55 checksum does not match
+var ErrInvalid = errors.New("invalid address")
+
+// Address is an Ethereum account or contract address. Its canonical form, used as storage key,
+// is its lowercase 0x prefixed hex, the EIP-55 checksummed form is for display only.
+type Address [Length]byte
+
+// Parse parses a 0x prefixed hex address. All lowercase and all uppercase addresses carry no checksum,
+// mixed case ones must match their EIP-55 checksum so typos are caught.
+func Parse(s string) (Address, error) {
+	digits, ok := strings.CutPrefix(s, "0x")
+	if !ok {
+		return Address{}, fmt.Errorf("%w %q, missing 0x prefix", ErrInvalid, s)
+	} else if len(digits) != 2*Length {
+		return Address{}, fmt.Errorf("%w %q, expected %d hex digits", ErrInvalid, s, 2*Length)
+	}
+
+	var a Address
+	if _, err := hex.Decode(a[:], []byte(digits)); err != nil {
+		return Address{}, fmt.Errorf("%w %q, expected hex", ErrInvalid, s)
+	}
+
+	if digits != strings.ToLower(digits) && digits != strings.ToUpper(digits) && s != a.Checksum() {
+		return Address{}, fmt.Errorf("%w %q, checksum mismatch, expected %s", ErrInvalid, s, a.Checksum())
+	}
+
+	return a, nil
+}
+
+// Normalize parses an address and returns its canonical form
+func Normalize(s string) (string, error) {
+	a, err := Parse(s)
+	if err != nil {
+		return "", err
+	}
+	return a.String(), nil
+}
+
+// String returns the canonical form of the address, its lowercase 0x prefixed hex
+func (a Address) String() string {
+	return "0x" + hex.EncodeToString(a[:])
+}
+
+// Checksum returns the EIP-55 checksummed form of the address: the hex letters are uppercased
+// where the matching nibble of the keccak256 hash of the lowercase hex is 8 or more
+func (a Address) Checksum() string {
+	digits := []byte(hex.EncodeToString(a[:]))
+
+	hash := sha3.NewLegacyKeccak256()
+	hash.Write(digits)
+	sum := hash.Sum(nil)
+
+	for i, digit := range digits {
+		nibble := sum[i/2] >> 4
+		if i%2 == 1 {
+			nibble = sum[i/2] & 0x0f
+		}
+
+		if digit >= 'a' && nibble >= 8 {
+			digits[i] = digit - 'a' + 'A'
+		}
+	}
+
+	return "0x" + string(digits)
+}
+
+// IsZero checks whether the address is the zero address
+func (a Address) IsZero() bool {
+	return a == Address{}
+}
+
+// MarshalText encodes the address in its canonical form
+func (a Address) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText parses an address, see Parse
+func (a *Address) UnmarshalText(text []byte) error {
+	parsed, err := Parse(string(text))
+	if err != nil {
+		return err
+	}
+
+	*a = parsed
+	return nil
+}
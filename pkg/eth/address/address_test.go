@@ -0,0 +1,73 @@
+package address
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	// The test vectors of EIP-55
+	for _, checksummed := range []string{
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		"0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359",
+		"0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB",
+		"0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb",
+	} {
+		a, err := Parse(checksummed)
+		assert.NoError(t, err)
+		assert.Equal(t, strings.ToLower(checksummed), a.String())
+		assert.Equal(t, checksummed, a.Checksum())
+
+		// Single case addresses carry no checksum
+		lower, err := Parse(strings.ToLower(checksummed))
+		assert.NoError(t, err)
+		assert.Equal(t, a, lower)
+
+		upper, err := Parse("0x" + strings.ToUpper(checksummed[2:]))
+		assert.NoError(t, err)
+		assert.Equal(t, a, upper)
+	}
+
+	for _, invalid := range []string{
+		"",
+		"0x",
+		"5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		"0X5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeA",
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAedaa",
+		"0xzzAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		// A single letter with the wrong case
+		"0x5AAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+	} {
+		_, err := Parse(invalid)
+		assert.ErrorIs(t, err, ErrInvalid, invalid)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	normalized, err := Normalize("0x28C6c06298d514Db089934071355E5743bf21d60")
+	assert.NoError(t, err)
+	assert.Equal(t, "0x28c6c06298d514db089934071355e5743bf21d60", normalized)
+
+	_, err = Normalize("test-address")
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestAddress_JSON(t *testing.T) {
+	var decoded struct {
+		Address Address `json:"address"`
+	}
+	err := json.Unmarshal([]byte(`{"address": "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"}`), &decoded)
+	assert.NoError(t, err)
+	assert.False(t, decoded.Address.IsZero())
+
+	encoded, err := json.Marshal(decoded)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"address": "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"}`, string(encoded))
+
+	err = json.Unmarshal([]byte(`{"address": "0x5AAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"}`), &decoded)
+	assert.ErrorIs(t, err, ErrInvalid)
+}
@@ -0,0 +1,724 @@
+package eth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/HomayoonAlimohammadi/blockchain-parser/internal/parser"
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/eth/quantity"
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/log"
+	wspkg "github.com/HomayoonAlimohammadi/blockchain-parser/pkg/websocket"
+)
+
+// errNotConnected is returned for requests made while the websocket connection is down
+var errNotConnected = errors.New("websocket not connected")
+
+// errBufferFull is returned when the logs of a subscription do not fit in its channel, the consumer lagging behind
+var errBufferFull = errors.New("subscription buffer full")
+
+// wsMessage is any message received on the websocket: either a reply to one of our
+// requests (ID set) or an eth_subscription notification (Method set)
+type wsMessage struct {
+	ID     int                      `json:"id"`
+	Method string                   `json:"method"`
+	Result json.RawMessage          `json:"result"`
+	Error  *RPCError                `json:"error"`
+	Params subscriptionNotification `json:"params"`
+}
+
+// subscriptionNotification holds the params of the eth_subscription message pushed for every new subscription result
+type subscriptionNotification struct {
+	Subscription string          `json:"subscription"`
+	Result       json.RawMessage `json:"result"`
+}
+
+// pendingRequest is a request waiting for its reply
+type pendingRequest struct {
+	replyChan chan wsMessage
+	// sub is set for eth_subscribe requests, the read loop registers it under the returned
+	// subscription ID before handling the next message so no notification is missed
+	sub *subscription
+}
+
+// subscription is a subscription multiplexed over the shared connection
+type subscription struct {
+	// key identifies the subscription: the subscribed address for logs subscriptions,
+	// newHeadsSubscription for the new heads one
+	key string
+	// params are the eth_subscribe params, sent again on every reconnection
+	params []any
+	// filter selects the logs of logs subscriptions, the missed ones are fetched with it after reconnecting
+	filter parser.LogFilter
+	// id is the subscription ID assigned by the node, it changes on every reconnection
+	id string
+
+	// logs receives the results of logs subscriptions
+	logs   chan parser.Log
+	cursor logCursor
+	// fromBlock is the chain head when subscribing, the logs missed are fetched from it until one is delivered
+	fromBlock uint64
+	// heads receives the results of newHeads subscriptions
+	heads chan parser.Header
+
+	// resyncing is set from a disconnection, or from the channel overflowing, until the logs missed in between
+	// are delivered, live notifications received meanwhile are held in backlog to keep the delivery in order.
+	// dropped is set when the backlog overflows too, the logs dropped are fetched again.
+	resyncing bool
+	backlog   []parser.Log
+	dropped   bool
+}
+
+// newLogsSubscription creates a subscription to the logs matching the filter of an address, missed
+// logs being fetched from fromBlock until one is delivered
+func newLogsSubscription(address string, filter parser.LogFilter, fromBlock uint64) *subscription {
+	return &subscription{
+		key: address,
+		params: []any{
+			logsSubscription,
+			filterParams(filter),
+		},
+		filter:    filter,
+		fromBlock: fromBlock,
+		logs:      make(chan parser.Log, subscriptionBufferSize),
+	}
+}
+
+// newHeadsSubscriptionOf creates a subscription to the headers of new blocks
+func newHeadsSubscriptionOf() *subscription {
+	return &subscription{
+		key:    newHeadsSubscription,
+		params: []any{newHeadsSubscription},
+		heads:  make(chan parser.Header, subscriptionBufferSize),
+	}
+}
+
+// notify delivers the result of a notification, the connManager lock must be held. It reports whether
+// the channel of a logs subscription overflowed, in which case the subscription must catch up.
+func (s *subscription) notify(result json.RawMessage) bool {
+	if s.heads != nil {
+		var header parser.Header
+		if err := json.Unmarshal(result, &header); err != nil {
+			log.Error(err, "failed to unmarshal header")
+			return false
+		}
+
+		select {
+		case s.heads <- header:
+		default:
+			log.Warn("header missed", "header", header)
+		}
+		return false
+	}
+
+	var entry parser.Log
+	if err := json.Unmarshal(result, &entry); err != nil {
+		log.Error(err, "failed to unmarshal log")
+		return false
+	}
+
+	if s.resyncing {
+		if len(s.backlog) < cap(s.logs) {
+			s.backlog = append(s.backlog, entry)
+		} else {
+			s.dropped = true
+		}
+		return false
+	}
+
+	if !deliver(entry, &s.cursor, s.logs) {
+		s.resyncing = true
+		return true
+	}
+
+	return false
+}
+
+// close closes the channel of the subscription
+func (s *subscription) close() {
+	if s.heads != nil {
+		close(s.heads)
+	} else {
+		close(s.logs)
+	}
+}
+
+// connManager multiplexes every log subscription over a single websocket connection,
+// routing eth_subscription notifications to the right channel by subscription ID and
+// transparently reconnecting and resubscribing when the connection fails
+type connManager struct {
+	caller *rpcCaller
+
+	// writeMu serializes writes as the websocket connection supports a single concurrent writer
+	writeMu sync.Mutex
+
+	mu   sync.Mutex
+	conn *websocket.Conn
+	// provider is the provider the connection is made to
+	provider     *provider
+	reconnecting bool
+	pending      map[int]pendingRequest
+	subsByID     map[string]*subscription
+	subsByKey    map[string]*subscription
+}
+
+// newConnManager creates a connection manager dialing through the given caller
+func newConnManager(caller *rpcCaller) *connManager {
+	return &connManager{
+		caller:    caller,
+		pending:   make(map[int]pendingRequest),
+		subsByID:  make(map[string]*subscription),
+		subsByKey: make(map[string]*subscription),
+	}
+}
+
+// subscribe issues a subscription over the shared connection
+func (m *connManager) subscribe(ctx context.Context, sub *subscription) error {
+	if err := m.ensureConnected(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	if _, ok := m.subsByKey[sub.key]; ok {
+		m.mu.Unlock()
+		return fmt.Errorf("%q already subscribed", sub.key)
+	}
+	m.subsByKey[sub.key] = sub
+	m.mu.Unlock()
+
+	if err := m.issueSubscribe(ctx, sub); err != nil {
+		m.mu.Lock()
+		delete(m.subsByKey, sub.key)
+		m.mu.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+// unsubscribe cancels the subscription with the given key and closes its channel
+func (m *connManager) unsubscribe(ctx context.Context, key string) error {
+	m.mu.Lock()
+	sub, ok := m.subsByKey[key]
+	if !ok {
+		m.mu.Unlock()
+		return fmt.Errorf("%q not subscribed", key)
+	}
+
+	delete(m.subsByKey, key)
+	delete(m.subsByID, sub.id)
+	sub.close()
+	subID, connected := sub.id, m.conn != nil
+	m.mu.Unlock()
+
+	// The node drops the subscriptions of a failed connection on its own
+	if !connected || subID == "" {
+		return nil
+	}
+
+	var unsubscribed bool
+	if err := m.request(ctx, unsubscribeMethod, []any{subID}, nil, &unsubscribed); err != nil {
+		return fmt.Errorf("failed to unsubscribe %q: %w", subID, err)
+	}
+
+	return nil
+}
+
+// connectionState returns the state of the subscription with the given key
+func (m *connManager) connectionState(key string) parser.ConnectionState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sub, ok := m.subsByKey[key]
+	if !ok {
+		return parser.ConnectionStateDisconnected
+	} else if sub.resyncing || sub.id == "" {
+		return parser.ConnectionStateReconnecting
+	}
+
+	return parser.ConnectionStateConnected
+}
+
+// issueSubscribe sends eth_subscribe for a subscription and waits for its ID
+func (m *connManager) issueSubscribe(ctx context.Context, sub *subscription) error {
+	var subID string
+	if err := m.request(ctx, subscribeMethod, sub.params, sub, &subID); err != nil {
+		return fmt.Errorf("failed to subscribe to %q: %w", sub.key, err)
+	}
+
+	return nil
+}
+
+// request sends a JSON-RPC request over the connection and decodes its result into result
+func (m *connManager) request(ctx context.Context, method string, params []any, sub *subscription, result any) error {
+	m.mu.Lock()
+	conn := m.conn
+	if conn == nil {
+		m.mu.Unlock()
+		return errNotConnected
+	}
+
+	id := m.caller.newID()
+	replyChan := make(chan wsMessage, 1)
+	m.pending[id] = pendingRequest{replyChan: replyChan, sub: sub}
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		delete(m.pending, id)
+		m.mu.Unlock()
+	}()
+
+	req := RPCRequest{
+		Jsonrpc: rpcVersion,
+		Method:  method,
+		Params:  params,
+		ID:      id,
+	}
+
+	m.writeMu.Lock()
+	err := conn.WriteJSON(req)
+	m.writeMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to send %s request: %w", method, err)
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case reply, ok := <-replyChan:
+		if !ok {
+			return errNotConnected
+		}
+
+		return decodeResponse(method, id, RPCResponse{ID: reply.ID, Result: reply.Result, Error: reply.Error}, result)
+	}
+}
+
+// ensureConnected dials the connection unless it is already up
+func (m *connManager) ensureConnected() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.conn != nil {
+		return nil
+	} else if m.reconnecting {
+		return fmt.Errorf("reconnecting: %w", errNotConnected)
+	}
+
+	return m.connectLocked()
+}
+
+// connectLocked dials the websocket endpoint of the healthiest provider, failing over to the next ones,
+// and starts reading from it, m.mu must be held
+func (m *connManager) connectLocked() error {
+	providers := m.caller.rankedProviders()
+
+	errs := make([]error, 0, len(providers))
+	for _, p := range providers {
+		conn, _, err := m.caller.wsDialer.Dial(p.endpoint.WSURL, p.endpoint.Header)
+		if err != nil {
+			err = redactURL(err)
+			p.recordFailure(err)
+			if len(providers) > 1 {
+				err = fmt.Errorf("%s: %w", p.name, err)
+			}
+			errs = append(errs, err)
+			continue
+		}
+
+		m.conn = conn
+		m.provider = p
+		go m.readLoop(conn)
+
+		return nil
+	}
+
+	return fmt.Errorf("failed to dial websocket: %w", errors.Join(errs...))
+}
+
+// streamingProvider returns the provider the connection is made to, nil if it is down
+func (m *connManager) streamingProvider() *provider {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.conn == nil {
+		return nil
+	}
+	return m.provider
+}
+
+// readLoop dispatches the messages received on a connection until reading from it fails
+func (m *connManager) readLoop(conn *websocket.Conn) {
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			if wspkg.IsCloseError(err) {
+				log.Error(err, "connection closed")
+			} else {
+				log.Error(err, "failed to read message")
+			}
+
+			m.handleDisconnect(conn)
+			return
+		}
+
+		var msg wsMessage
+		if err := json.Unmarshal(message, &msg); err != nil {
+			log.Error(err, "failed to unmarshal message")
+			continue
+		}
+
+		m.dispatch(msg)
+	}
+}
+
+// dispatch routes a reply to its pending request and a notification to its subscription
+func (m *connManager) dispatch(msg wsMessage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if msg.Method == subscriptionMethod {
+		sub, ok := m.subsByID[msg.Params.Subscription]
+		if !ok {
+			log.Warn("notification for unknown subscription", "subscription", msg.Params.Subscription)
+			return
+		}
+
+		if sub.notify(msg.Params.Result) {
+			log.Warn("subscription fell behind, catching up", "key", sub.key)
+			go m.retryCatchUp(context.Background(), sub)
+		}
+		return
+	}
+
+	req, ok := m.pending[msg.ID]
+	if !ok {
+		log.Warn("reply for unknown request", "id", msg.ID)
+		return
+	}
+
+	if req.sub != nil && msg.Error == nil {
+		var subID string
+		if err := json.Unmarshal(msg.Result, &subID); err == nil {
+			// The subscription might have been cancelled while waiting for the reply
+			if current, ok := m.subsByKey[req.sub.key]; ok && current == req.sub {
+				req.sub.id = subID
+				m.subsByID[subID] = req.sub
+			}
+		}
+	}
+
+	req.replyChan <- msg
+}
+
+// handleDisconnect fails the requests pending on a dropped connection and starts reconnecting
+func (m *connManager) handleDisconnect(conn *websocket.Conn) {
+	conn.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.conn != conn {
+		return
+	}
+	m.conn = nil
+
+	for id, req := range m.pending {
+		close(req.replyChan)
+		delete(m.pending, id)
+	}
+
+	// Subscription IDs are bound to the connection, notifications are held until resubscribed
+	m.subsByID = make(map[string]*subscription)
+	for _, sub := range m.subsByKey {
+		sub.id = ""
+		sub.resyncing = true
+	}
+
+	if !m.reconnecting {
+		m.reconnecting = true
+		go m.reconnect(context.Background())
+	}
+}
+
+// reconnect redials the connection, backing off exponentially with jitter between attempts,
+// then resubscribes every address and delivers the logs missed while disconnected
+func (m *connManager) reconnect(ctx context.Context) {
+	for attempt := 0; ; attempt++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(m.caller.reconnectDelay(attempt)):
+		}
+
+		m.mu.Lock()
+		err := m.connectLocked()
+		subs := make([]*subscription, 0, len(m.subsByKey))
+		for _, sub := range m.subsByKey {
+			subs = append(subs, sub)
+		}
+		m.mu.Unlock()
+
+		if err != nil {
+			log.Error(err, "failed to reconnect, retrying", "attempt", attempt+1)
+			continue
+		}
+
+		if err := m.resubscribe(ctx, subs); err != nil {
+			log.Error(err, "failed to resubscribe, retrying", "attempt", attempt+1)
+			continue
+		}
+
+		m.mu.Lock()
+		// The new connection might have dropped already, in which case its disconnection
+		// was not handled as we were still reconnecting
+		if m.conn == nil {
+			m.mu.Unlock()
+			continue
+		}
+		m.reconnecting = false
+		m.mu.Unlock()
+
+		log.Info("reconnected", "subscriptions", len(subs), "attempt", attempt+1)
+		return
+	}
+}
+
+// resubscribe resyncs the given subscriptions on the current connection, the ones the node refuses are
+// retried in the background. Missed headers are not fetched, consumers catch up from the next header on their own.
+func (m *connManager) resubscribe(ctx context.Context, subs []*subscription) error {
+	for _, sub := range subs {
+		err := m.resync(ctx, sub)
+		if errors.Is(err, errNotConnected) {
+			return err
+		} else if err != nil {
+			log.Error(err, "failed to resubscribe, retrying", "key", sub.key)
+			go m.retryResync(ctx, sub)
+		}
+	}
+
+	return nil
+}
+
+// resync re-issues a subscription on the current connection and, for a logs subscription, delivers
+// the logs missed while disconnected followed by the ones received while resyncing. The logs failing
+// to be delivered are retried in the background, the live ones being held until then.
+func (m *connManager) resync(ctx context.Context, sub *subscription) error {
+	reqCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+	err := m.issueSubscribe(reqCtx, sub)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	if sub.logs == nil {
+		m.mu.Lock()
+		sub.resyncing = false
+		m.mu.Unlock()
+		return nil
+	}
+
+	if err := m.catchUp(ctx, sub); err != nil {
+		log.Error(err, "failed to deliver logs missed while reconnecting, retrying", "address", sub.key)
+		go m.retryCatchUp(ctx, sub)
+	}
+
+	return nil
+}
+
+// catchUp delivers the logs missed by a logs subscription since its cursor, fetched with eth_getLogs,
+// followed by the ones held while it was resyncing, then resumes the live delivery. It fails when the
+// missed logs cannot be fetched or do not fit in the channel, the live logs still being held.
+func (m *connManager) catchUp(ctx context.Context, sub *subscription) error {
+	m.mu.Lock()
+	lastBlock := sub.cursor.block
+	if lastBlock == 0 {
+		lastBlock = sub.fromBlock
+	}
+	// The logs dropped so far are fetched now along with the missed ones
+	sub.dropped = false
+	m.mu.Unlock()
+
+	missed, err := m.caller.missedLogs(ctx, sub.filter, lastBlock)
+	if err != nil {
+		return fmt.Errorf("failed to fetch missed logs: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Skip subscriptions cancelled in the meantime, their channel is closed
+	if current, ok := m.subsByKey[sub.key]; !ok || current != sub {
+		return nil
+	}
+
+	entries := append(missed, sub.backlog...)
+	sub.backlog = nil
+	for _, entry := range entries {
+		// The logs left are fetched again from the cursor on the next attempt
+		if !deliver(entry, &sub.cursor, sub.logs) {
+			return errBufferFull
+		}
+	}
+	if sub.dropped {
+		return errBufferFull
+	}
+	sub.resyncing = false
+
+	return nil
+}
+
+// retryCatchUp retries to catch a logs subscription up, backing off as between reconnection attempts,
+// until it succeeds or the subscription is cancelled. It gives up once the connection drops, the
+// reconnection resyncs every subscription.
+func (m *connManager) retryCatchUp(ctx context.Context, sub *subscription) {
+	m.mu.Lock()
+	conn := m.conn
+	m.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(m.caller.reconnectDelay(attempt)):
+		}
+
+		m.mu.Lock()
+		current, ok := m.subsByKey[sub.key]
+		stale := !ok || current != sub || m.conn != conn || !sub.resyncing
+		m.mu.Unlock()
+		if stale {
+			return
+		}
+
+		err := m.catchUp(ctx, sub)
+		if err == nil {
+			log.Info("caught up", "key", sub.key, "attempt", attempt+1)
+			return
+		}
+
+		log.Error(err, "failed to catch up, retrying", "key", sub.key, "attempt", attempt+1)
+	}
+}
+
+// retryResync retries to resync a subscription the node refused, backing off as between reconnection
+// attempts, until it succeeds or the subscription is cancelled. It gives up once the connection drops,
+// the reconnection resyncs every subscription.
+func (m *connManager) retryResync(ctx context.Context, sub *subscription) {
+	m.mu.Lock()
+	conn := m.conn
+	m.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(m.caller.reconnectDelay(attempt)):
+		}
+
+		m.mu.Lock()
+		current, ok := m.subsByKey[sub.key]
+		stale := !ok || current != sub || m.conn != conn
+		m.mu.Unlock()
+		if stale {
+			return
+		}
+
+		err := m.resync(ctx, sub)
+		if err == nil {
+			log.Info("resubscribed", "key", sub.key, "attempt", attempt+1)
+			return
+		} else if errors.Is(err, errNotConnected) {
+			return
+		}
+
+		log.Error(err, "failed to resubscribe, retrying", "key", sub.key, "attempt", attempt+1)
+	}
+}
+
+// deliver sends a log to the channel unless it was already delivered. It reports false when the channel
+// is full, the cursor is left before the log so it is fetched again.
+func deliver(entry parser.Log, cursor *logCursor, resChan chan<- parser.Log) bool {
+	// Logs removed by a reorganisation are always passed on, and the ones replacing them must not be
+	// mistaken for already delivered
+	if entry.Removed {
+		cursor.rewind(entry)
+		select {
+		case resChan <- entry:
+			return true
+		default:
+			log.Warn("removed log missed", "log", entry)
+			return false
+		}
+	}
+
+	if cursor.seen(entry) {
+		return true
+	}
+
+	select {
+	case resChan <- entry:
+		cursor.advance(entry)
+		return true
+	default:
+		return false
+	}
+}
+
+// logCursor tracks the position of the last log delivered on a subscription
+type logCursor struct {
+	// block is the number of the latest block a log was delivered for
+	block uint64
+	// delivered holds the block hash, transaction hash and log index of the logs delivered for block
+	delivered map[[3]string]struct{}
+}
+
+// keyOf returns the key of a log in the delivered set
+func keyOf(entry parser.Log) [3]string {
+	return [3]string{entry.BlockHash, entry.TransactionHash, entry.LogIndex}
+}
+
+// seen reports whether a log was already delivered
+func (c *logCursor) seen(entry parser.Log) bool {
+	blockNumber, err := quantity.ParseHex(entry.BlockNumber)
+	if err != nil || blockNumber > c.block {
+		return false
+	} else if blockNumber < c.block {
+		return true
+	}
+
+	_, ok := c.delivered[keyOf(entry)]
+	return ok
+}
+
+// advance moves the cursor past a delivered log
+func (c *logCursor) advance(entry parser.Log) {
+	blockNumber, err := quantity.ParseHex(entry.BlockNumber)
+	if err != nil {
+		return
+	}
+
+	if blockNumber > c.block || c.delivered == nil {
+		c.block = blockNumber
+		c.delivered = make(map[[3]string]struct{})
+	}
+	c.delivered[keyOf(entry)] = struct{}{}
+}
+
+// rewind moves the cursor back to the block of a removed log, so the logs of the branch replacing it
+// are delivered. Every log delivered for that block or above was orphaned along with it.
+func (c *logCursor) rewind(entry parser.Log) {
+	blockNumber, err := quantity.ParseHex(entry.BlockNumber)
+	if err != nil || blockNumber >= c.block {
+		return
+	}
+
+	c.block = blockNumber
+	c.delivered = make(map[[3]string]struct{})
+}
@@ -1,12 +1,55 @@
 package eth
 
+import "time"
+
 const (
+	httpScheme      = "https"
 	webSocketScheme = "wss"
 
 	rpcHost = "ethereum-rpc.publicnode.com"
 
 	rpcVersion = "2.0"
 
-	blockNumberMethod = "eth_blockNumber"
-	subscribeMethod   = "eth_subscribe"
+	blockNumberMethod           = "eth_blockNumber"
+	subscribeMethod             = "eth_subscribe"
+	unsubscribeMethod           = "eth_unsubscribe"
+	subscriptionMethod          = "eth_subscription"
+	getLogsMethod               = "eth_getLogs"
+	getBlockByNumberMethod      = "eth_getBlockByNumber"
+	getTransactionReceiptMethod = "eth_getTransactionReceipt"
+	getBalanceMethod            = "eth_getBalance"
+	callMethod                  = "eth_call"
+	chainIDMethod               = "eth_chainId"
+	gasPriceMethod              = "eth_gasPrice"
+	getTransactionCountMethod   = "eth_getTransactionCount"
+	getTransactionByHashMethod  = "eth_getTransactionByHash"
+
+	// logsSubscription is the eth_subscribe subscription type for contract event logs
+	logsSubscription = "logs"
+	// newHeadsSubscription is the eth_subscribe subscription type for the headers of new blocks
+	newHeadsSubscription = "newHeads"
+
+	// minReconnectDelay and maxReconnectDelay bound the backoff between websocket reconnection attempts
+	minReconnectDelay = 500 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
+
+	// defaultMaxBatchSize is the number of requests sent per JSON-RPC batch when the endpoint sets none,
+	// within the limits of the public nodes
+	defaultMaxBatchSize = 50
+
+	// healthCheckInterval is the period of the health checks of the providers, and healthCheckTimeout
+	// bounds the wait for the reply of each
+	healthCheckInterval = 15 * time.Second
+	healthCheckTimeout  = 5 * time.Second
+	// maxHeadLag is the number of blocks a provider may be behind the most advanced one and still be healthy
+	maxHeadLag = 3
+	// latencySmoothing is the weight of the past requests over the last one in the moving average of the latency of a provider
+	latencySmoothing = 4
+
+	// subscriptionBufferSize is the number of notifications buffered per subscription, the logs overflowing it
+	// are fetched again with eth_getLogs once the consumer catches up
+	subscriptionBufferSize = 1024
+
+	// requestTimeout bounds the wait for the reply of a request sent over the websocket
+	requestTimeout = 30 * time.Second
 )
@@ -0,0 +1,67 @@
+package eth
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Endpoint is the location of an Ethereum JSON-RPC node
+type Endpoint struct {
+	// HTTPURL is the URL JSON-RPC requests are posted to
+	HTTPURL string
+	// WSURL is the websocket URL subscriptions are made on
+	WSURL string
+	// Header holds extra headers, such as authorization, sent with every request and websocket dial
+	Header http.Header
+	// MaxBatchSize is the maximum number of requests sent in a single JSON-RPC batch, a default one is used if 0
+	MaxBatchSize int
+}
+
+// DefaultEndpoint returns the public node used when no endpoint is configured
+func DefaultEndpoint() Endpoint {
+	httpURL := url.URL{Scheme: httpScheme, Host: rpcHost}
+	wsURL := url.URL{Scheme: webSocketScheme, Host: rpcHost, Path: "/"}
+
+	return Endpoint{
+		HTTPURL: httpURL.String(),
+		WSURL:   wsURL.String(),
+	}
+}
+
+// Validate checks that the endpoint URLs are absolute and use the expected schemes
+func (e Endpoint) Validate() error {
+	if err := validateURL(e.HTTPURL, "http", "https"); err != nil {
+		return fmt.Errorf("invalid HTTP URL: %w", err)
+	}
+
+	if err := validateURL(e.WSURL, "ws", "wss"); err != nil {
+		return fmt.Errorf("invalid websocket URL: %w", err)
+	}
+
+	if e.MaxBatchSize < 0 {
+		return fmt.Errorf("invalid max batch size %d, expected a positive number or 0 for the default", e.MaxBatchSize)
+	}
+
+	return nil
+}
+
+// validateURL checks that rawURL is an absolute URL with one of the given schemes
+func validateURL(rawURL string, schemes ...string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	if u.Host == "" {
+		return fmt.Errorf("missing host in %q", rawURL)
+	}
+
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unsupported scheme %q in %q, expected one of %v", u.Scheme, rawURL, schemes)
+}
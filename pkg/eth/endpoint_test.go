@@ -0,0 +1,34 @@
+package eth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpoint_Validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint Endpoint
+		wantErr  bool
+	}{
+		{name: "Default", endpoint: DefaultEndpoint()},
+		{name: "Local", endpoint: Endpoint{HTTPURL: "http://localhost:8545", WSURL: "ws://localhost:8546"}},
+		{name: "MissingHTTPURL", endpoint: Endpoint{WSURL: "ws://localhost:8546"}, wantErr: true},
+		{name: "MissingWSURL", endpoint: Endpoint{HTTPURL: "http://localhost:8545"}, wantErr: true},
+		{name: "MaxBatchSize", endpoint: Endpoint{HTTPURL: "http://localhost:8545", WSURL: "ws://localhost:8546", MaxBatchSize: 10}},
+		{name: "NegativeMaxBatchSize", endpoint: Endpoint{HTTPURL: "http://localhost:8545", WSURL: "ws://localhost:8546", MaxBatchSize: -1}, wantErr: true},
+		{name: "SwappedSchemes", endpoint: Endpoint{HTTPURL: "ws://localhost:8546", WSURL: "http://localhost:8545"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.endpoint.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
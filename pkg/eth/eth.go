@@ -1,150 +1,322 @@
 package eth
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"math/big"
+	"math/rand/v2"
 	"net/http"
-	"net/url"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 
 	"github.com/HomayoonAlimohammadi/blockchain-parser/internal/parser"
-	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/log"
-	wspkg "github.com/HomayoonAlimohammadi/blockchain-parser/pkg/websocket"
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/erc20"
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/eth/quantity"
 )
 
-// JSON-RPC request structure
-type RPCRequest struct {
-	Jsonrpc string `json:"jsonrpc"`
-	Method  string `json:"method"`
-	Params  []any  `json:"params"`
-	ID      int    `json:"id"`
-}
-
-// JSON-RPC response structure
-type RPCResponse struct {
-	Jsonrpc string `json:"jsonrpc"`
-	ID      int    `json:"id"`
-	Result  string `json:"result"`
-}
-
 // RPC caller structure
 type rpcCaller struct {
 	client   *http.Client
 	wsDialer *websocket.Dialer
+	// providers are the endpoints calls are routed to, in the configured order
+	providers []*provider
+
+	conns *connManager
+	// nextID is the ID of the last request sent
+	nextID atomic.Int64
+
+	minReconnectDelay   time.Duration
+	maxReconnectDelay   time.Duration
+	healthCheckInterval time.Duration
 }
 
-// NewRPCCaller creates a new RPC caller
-func NewRPCCaller(client *http.Client, wsDialer *websocket.Dialer) *rpcCaller {
-	return &rpcCaller{
+// NewRPCCaller creates a new RPC caller talking to the nodes at the given endpoints, the default one if none
+// is given. Calls are routed to the healthiest endpoint and fail over to the next ones, see MonitorProviders.
+func NewRPCCaller(client *http.Client, wsDialer *websocket.Dialer, endpoints ...Endpoint) *rpcCaller {
+	if len(endpoints) == 0 {
+		endpoints = []Endpoint{DefaultEndpoint()}
+	}
+
+	c := &rpcCaller{
 		client:   client,
 		wsDialer: wsDialer,
+
+		minReconnectDelay:   minReconnectDelay,
+		maxReconnectDelay:   maxReconnectDelay,
+		healthCheckInterval: healthCheckInterval,
 	}
-}
+	for _, endpoint := range endpoints {
+		c.providers = append(c.providers, newProvider(endpoint))
+	}
+	c.conns = newConnManager(c)
 
-// Subscribe calls eth_subscribe
-func (c *rpcCaller) Subscribe(ctx context.Context, address string) (<-chan parser.Transaction, error) {
-	u := url.URL{Scheme: webSocketScheme, Host: rpcHost, Path: "/"}
+	return c
+}
 
-	conn, _, err := c.wsDialer.Dial(u.String(), nil)
+// Subscribe calls eth_subscribe. All subscriptions share a single websocket connection and the
+// returned channel outlives it: whenever it fails, the connection is redialed with exponential
+// backoff, the subscription is re-issued and the logs missed in between are fetched with
+// eth_getLogs before resuming.
+func (c *rpcCaller) Subscribe(ctx context.Context, address string, filter parser.LogFilter) (<-chan parser.Log, error) {
+	// The logs missed before the first one is delivered are fetched from the head at the time of subscribing
+	headBlock, err := c.BlockNumber(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to dial websocket: %w", err)
+		return nil, fmt.Errorf("failed to get current block: %w", err)
 	}
 
-	req := RPCRequest{
-		Jsonrpc: rpcVersion,
-		Method:  subscribeMethod,
-		Params: []any{
-			"logs",
-			map[string]string{
-				"address": address,
-			},
-		},
+	sub := newLogsSubscription(address, filter, headBlock)
+	if err := c.conns.subscribe(ctx, sub); err != nil {
+		return nil, err
+	}
+
+	return sub.logs, nil
+}
+
+// SubscribeNewHeads calls eth_subscribe for the headers of new blocks, sharing the connection
+// and its reconnection handling with the logs subscriptions
+func (c *rpcCaller) SubscribeNewHeads(ctx context.Context) (<-chan parser.Header, error) {
+	sub := newHeadsSubscriptionOf()
+	if err := c.conns.subscribe(ctx, sub); err != nil {
+		return nil, err
 	}
 
-	err = conn.WriteJSON(req)
+	return sub.heads, nil
+}
+
+// Unsubscribe calls eth_unsubscribe for the subscription of an address and closes its channel
+func (c *rpcCaller) Unsubscribe(ctx context.Context, address string) error {
+	return c.conns.unsubscribe(ctx, address)
+}
+
+// ConnectionState returns the state of the subscription of an address
+func (c *rpcCaller) ConnectionState(address string) parser.ConnectionState {
+	return c.conns.connectionState(address)
+}
+
+// reconnectDelay returns the delay before a reconnection attempt: exponentially growing from
+// minReconnectDelay up to maxReconnectDelay, with a random jitter of up to half of it so
+// clients dropped at the same time do not redial at once
+func (c *rpcCaller) reconnectDelay(attempt int) time.Duration {
+	delay := c.maxReconnectDelay
+	if attempt < 32 {
+		delay = min(c.minReconnectDelay<<attempt, c.maxReconnectDelay)
+	}
+
+	half := delay / 2
+	return half + rand.N(half+1)
+}
+
+// missedLogs fetches the logs matching a filter from the given block up to the current one,
+// nothing is fetched if no block is known yet
+func (c *rpcCaller) missedLogs(ctx context.Context, filter parser.LogFilter, fromBlock uint64) ([]parser.Log, error) {
+	if fromBlock == 0 {
+		return nil, nil
+	}
+
+	headBlock, err := c.BlockNumber(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send subscription message: %w", err)
+		return nil, fmt.Errorf("failed to get current block: %w", err)
 	}
 
-	// First message is the ack with different format
-	if _, _, err := conn.ReadMessage(); err != nil {
-		return nil, fmt.Errorf("failed to read ack message: %w", err)
+	// Long outages leave gaps spanning more blocks than the nodes serve in a single call
+	var missed []parser.Log
+	err = parser.GetLogsInChunks(ctx, c, filter, fromBlock, headBlock, func(logs []parser.Log, _ uint64) error {
+		missed = append(missed, logs...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	resChan := make(chan parser.Transaction, 999999)
-	// We use new context because the parent context is cancelled when the request is done
-	listenCtx := context.Background()
-	go listenForTxn(listenCtx, conn, resChan)
+	return missed, nil
+}
 
-	return resChan, nil
+// BlockNumber calls eth_blockNumber for the number of the most recent block
+func (c *rpcCaller) BlockNumber(ctx context.Context) (uint64, error) {
+	return c.callQuantity(ctx, blockNumberMethod, nil)
 }
 
-// listenForTxn listens for transactions
-func listenForTxn(ctx context.Context, conn *websocket.Conn, resChan chan<- parser.Transaction) {
-	defer func() {
-		close(resChan)
-		conn.Close()
-	}()
+// ChainID calls eth_chainId for the ID of the chain the node is on, as used for transaction signing
+func (c *rpcCaller) ChainID(ctx context.Context) (uint64, error) {
+	return c.callQuantity(ctx, chainIDMethod, nil)
+}
 
-	for {
-		select {
-		case <-ctx.Done():
-			log.Error(ctx.Err(), "context done")
-			return
-		default:
-			_, message, err := conn.ReadMessage()
-			if wspkg.IsCloseError(err) {
-				log.Error(err, "connection closed")
-				return
-			} else if err != nil {
-				log.Error(err, "failed to read message")
-			}
+// GasPrice calls eth_gasPrice for the current gas price in wei
+func (c *rpcCaller) GasPrice(ctx context.Context) (*big.Int, error) {
+	return c.callBigQuantity(ctx, gasPriceMethod, nil)
+}
 
-			var txn parser.Transaction
-			if err := json.Unmarshal(message, &txn); err != nil {
-				log.Error(err, "failed to unmarshal message")
-			}
+// GetTransactionCount calls eth_getTransactionCount for the nonce of an address at a block
+func (c *rpcCaller) GetTransactionCount(ctx context.Context, address string, block uint64) (uint64, error) {
+	return c.callQuantity(ctx, getTransactionCountMethod, []any{address, quantity.Hex(block)})
+}
+
+// GetTransactionByHash calls eth_getTransactionByHash for a transaction, pending or mined
+func (c *rpcCaller) GetTransactionByHash(ctx context.Context, hash string) (*parser.Transaction, error) {
+	var txn *parser.Transaction
+	if err := c.Call(ctx, getTransactionByHashMethod, []any{hash}, &txn); err != nil {
+		return nil, err
+	} else if txn == nil {
+		return nil, fmt.Errorf("transaction %q not found", hash)
+	}
+
+	return txn, nil
+}
 
-			select {
-			case resChan <- txn:
-			default:
-				log.Warn("transaction missed", "txn", txn)
+// GetLogs calls eth_getLogs for the logs matching a filter within the given (inclusive) block range
+func (c *rpcCaller) GetLogs(ctx context.Context, filter parser.LogFilter, fromBlock, toBlock uint64) ([]parser.Log, error) {
+	params := filterParams(filter)
+	params["fromBlock"] = quantity.Hex(fromBlock)
+	params["toBlock"] = quantity.Hex(toBlock)
+
+	var logs []parser.Log
+	if err := c.Call(ctx, getLogsMethod, []any{params}, &logs); err != nil {
+		var rpcErr *RPCError
+		if errors.As(err, &rpcErr) && rpcErr.Code == CodeLimitExceeded {
+			return nil, fmt.Errorf("%w: %w", parser.ErrRangeTooLarge, err)
+		}
+		return nil, err
+	}
+
+	return logs, nil
+}
+
+// filterParams returns the filter object of eth_subscribe and eth_getLogs for a log filter.
+// A single address is sent as is rather than as a list, and empty topic positions as null to match any topic.
+func filterParams(filter parser.LogFilter) map[string]any {
+	params := map[string]any{}
+	if len(filter.Addresses) == 1 {
+		params["address"] = filter.Addresses[0]
+	} else {
+		params["address"] = filter.Addresses
+	}
+
+	// Trailing wildcards are left out, the positions past the last one match any topic
+	topics := filter.Topics
+	for len(topics) > 0 && len(topics[len(topics)-1]) == 0 {
+		topics = topics[:len(topics)-1]
+	}
+
+	if len(topics) > 0 {
+		positions := make([]any, len(topics))
+		for i, allowed := range topics {
+			if len(allowed) > 0 {
+				positions[i] = allowed
 			}
 		}
+		params["topics"] = positions
 	}
+
+	return params
 }
 
-// BlockNumber calls eth_blockNumber
-func (c *rpcCaller) BlockNumber(ctx context.Context) (string, error) {
-	reqBody := RPCRequest{
-		Jsonrpc: rpcVersion,
-		Method:  blockNumberMethod,
+// GetBlockByNumber calls eth_getBlockByNumber for a block along with its full transactions
+func (c *rpcCaller) GetBlockByNumber(ctx context.Context, number uint64) (*parser.Block, error) {
+	var block *parser.Block
+	if err := c.Call(ctx, getBlockByNumberMethod, []any{quantity.Hex(number), true}, &block); err != nil {
+		return nil, err
+	} else if block == nil {
+		return nil, fmt.Errorf("block %d not found", number)
 	}
 
-	jsonReq, err := json.Marshal(reqBody)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request body: %w", err)
+	return block, nil
+}
+
+// GetBlocksByNumber calls eth_getBlockByNumber for several blocks along with their full transactions,
+// batched, and returns them in the order of the numbers. A block that failed to be fetched is nil, with
+// the reason at the same index of the errors, while the error returned last fails the whole batch.
+func (c *rpcCaller) GetBlocksByNumber(ctx context.Context, numbers []uint64) ([]*parser.Block, []error, error) {
+	blocks := make([]*parser.Block, len(numbers))
+	elems := make([]BatchElem, len(numbers))
+	for i, number := range numbers {
+		elems[i] = BatchElem{Method: getBlockByNumberMethod, Params: []any{quantity.Hex(number), true}, Result: &blocks[i]}
 	}
 
-	url := fmt.Sprintf("https://%s", rpcHost)
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(jsonReq))
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+	if err := c.BatchCall(ctx, elems); err != nil {
+		return nil, nil, err
 	}
 
-	resp, err := c.client.Do(req)
+	errs := make([]error, len(numbers))
+	for i, elem := range elems {
+		if elem.Error != nil {
+			blocks[i] = nil
+			errs[i] = fmt.Errorf("failed to get block %d: %w", numbers[i], elem.Error)
+		} else if blocks[i] == nil {
+			errs[i] = fmt.Errorf("block %d not found", numbers[i])
+		}
+	}
+
+	return blocks, errs, nil
+}
+
+// GetHeaderByTag calls eth_getBlockByNumber for the header of a tagged block, such as safe or finalized
+func (c *rpcCaller) GetHeaderByTag(ctx context.Context, tag string) (*parser.Header, error) {
+	var header *parser.Header
+	if err := c.Call(ctx, getBlockByNumberMethod, []any{tag, false}, &header); err != nil {
+		return nil, err
+	} else if header == nil {
+		return nil, fmt.Errorf("block %q not found", tag)
+	}
+
+	return header, nil
+}
+
+// GetTransactionReceipt calls eth_getTransactionReceipt for the receipt of a mined transaction
+func (c *rpcCaller) GetTransactionReceipt(ctx context.Context, hash string) (*parser.Receipt, error) {
+	var receipt *parser.Receipt
+	if err := c.Call(ctx, getTransactionReceiptMethod, []any{hash}, &receipt); err != nil {
+		return nil, err
+	} else if receipt == nil {
+		return nil, fmt.Errorf("receipt of transaction %q not found", hash)
+	}
+
+	return receipt, nil
+}
+
+// GetTransactionReceipts calls eth_getTransactionReceipt for several mined transactions, batched,
+// and returns their receipts in the order of the hashes
+func (c *rpcCaller) GetTransactionReceipts(ctx context.Context, hashes []string) ([]*parser.Receipt, error) {
+	receipts := make([]*parser.Receipt, len(hashes))
+	elems := make([]BatchElem, len(hashes))
+	for i, hash := range hashes {
+		elems[i] = BatchElem{Method: getTransactionReceiptMethod, Params: []any{hash}, Result: &receipts[i]}
+	}
+
+	if err := c.BatchCall(ctx, elems); err != nil {
+		return nil, err
+	}
+
+	for i, elem := range elems {
+		if elem.Error != nil {
+			return nil, fmt.Errorf("failed to get receipt of transaction %q: %w", hashes[i], elem.Error)
+		} else if receipts[i] == nil {
+			return nil, fmt.Errorf("receipt of transaction %q not found", hashes[i])
+		}
+	}
+
+	return receipts, nil
+}
+
+// GetBalance calls eth_getBalance for the wei balance of an address at a block
+func (c *rpcCaller) GetBalance(ctx context.Context, address string, block uint64) (*big.Int, error) {
+	return c.callBigQuantity(ctx, getBalanceMethod, []any{address, quantity.Hex(block)})
+}
+
+// GetTokenBalance calls eth_call for the ERC-20 balanceOf of an owner on a token contract at a block
+func (c *rpcCaller) GetTokenBalance(ctx context.Context, token, owner string, block uint64) (*big.Int, error) {
+	data, err := erc20.EncodeBalanceOf(owner)
 	if err != nil {
-		return "", fmt.Errorf("failed to make request: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	var rpcResp RPCResponse
-	if err := json.NewDecoder(resp.Body).Decode(&rpcResp); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
+	var result string
+	params := []any{map[string]any{"to": token, "data": data}, quantity.Hex(block)}
+	if err := c.Call(ctx, callMethod, params, &result); err != nil {
+		return nil, err
 	}
 
-	return rpcResp.Result, nil
+	return erc20.DecodeBalance(result)
 }
@@ -5,58 +5,955 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/HomayoonAlimohammadi/blockchain-parser/internal/parser"
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/eth/quantity"
 	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestRPCCaller_BlockNumber(t *testing.T) {
-	expectedResult := "0x10"
-
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		resp := RPCResponse{
 			Jsonrpc: "2.0",
 			ID:      1,
-			Result:  expectedResult,
+			Result:  json.RawMessage(`"0x10"`),
 		}
 		json.NewEncoder(w).Encode(resp)
 	}))
 	defer server.Close()
 
 	client := server.Client()
-	rpcCaller := NewRPCCaller(client, nil)
+	rpcCaller := NewRPCCaller(client, nil, Endpoint{HTTPURL: server.URL})
 
 	result, err := rpcCaller.BlockNumber(context.Background())
 	assert.NoError(t, err)
-	assert.Equal(t, expectedResult, result)
+	assert.Equal(t, uint64(16), result)
+}
+
+func TestRPCCaller_EndpointHeader(t *testing.T) {
+	var gotHeader http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader = r.Header
+		json.NewEncoder(w).Encode(RPCResponse{Jsonrpc: "2.0", ID: 1, Result: json.RawMessage(`"0x10"`)})
+	}))
+	defer server.Close()
+
+	endpoint := Endpoint{
+		HTTPURL: server.URL,
+		Header:  http.Header{"Authorization": []string{"Bearer token"}},
+	}
+	rpcCaller := NewRPCCaller(server.Client(), nil, endpoint)
+
+	_, err := rpcCaller.BlockNumber(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer token", gotHeader.Get("Authorization"))
+}
+
+func TestRPCCaller_Call(t *testing.T) {
+	var ids []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req RPCRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		ids = append(ids, req.ID)
+
+		switch req.Method {
+		case "eth_call":
+			json.NewEncoder(w).Encode(map[string]any{
+				"jsonrpc": "2.0",
+				"id":      req.ID,
+				"error":   map[string]any{"code": 3, "message": "execution reverted", "data": "0x08c379a0"},
+			})
+		case "eth_syncing":
+			json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": req.ID, "result": map[string]any{"currentBlock": "0x10"}})
+		case "eth_mismatch":
+			json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": req.ID + 1, "result": true})
+		case "eth_empty":
+			json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": req.ID})
+		default:
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	rpcCaller := NewRPCCaller(server.Client(), nil, Endpoint{HTTPURL: server.URL})
+	ctx := context.Background()
+
+	// Results of any shape are decoded
+	var syncing struct {
+		CurrentBlock string `json:"currentBlock"`
+	}
+	assert.NoError(t, rpcCaller.Call(ctx, "eth_syncing", nil, &syncing))
+	assert.Equal(t, "0x10", syncing.CurrentBlock)
+
+	var rpcErr *RPCError
+	err := rpcCaller.Call(ctx, "eth_call", []any{}, nil)
+	if assert.ErrorAs(t, err, &rpcErr) {
+		assert.Equal(t, 3, rpcErr.Code)
+		assert.Equal(t, "execution reverted", rpcErr.Message)
+		assert.JSONEq(t, `"0x08c379a0"`, string(rpcErr.Data))
+	}
+
+	assert.ErrorContains(t, rpcCaller.Call(ctx, "eth_mismatch", nil, nil), "does not match request ID")
+	assert.ErrorIs(t, rpcCaller.Call(ctx, "eth_empty", nil, nil), errMissingResult)
+	assert.ErrorContains(t, rpcCaller.Call(ctx, "eth_limited", nil, nil), "429")
+
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, ids)
+}
+
+func TestRPCCaller_BatchCall(t *testing.T) {
+	var batchSizes []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqs []RPCRequest
+		json.NewDecoder(r.Body).Decode(&reqs)
+		batchSizes = append(batchSizes, len(reqs))
+
+		// Responses are answered in reverse order, and the ones of eth_dropped are missing
+		var resps []map[string]any
+		for i := len(reqs) - 1; i >= 0; i-- {
+			switch reqs[i].Method {
+			case "eth_echo":
+				resps = append(resps, map[string]any{"jsonrpc": "2.0", "id": reqs[i].ID, "result": reqs[i].Params[0]})
+			case "eth_failing":
+				resps = append(resps, map[string]any{"jsonrpc": "2.0", "id": reqs[i].ID, "error": map[string]any{"code": CodeLimitExceeded, "message": "limit exceeded"}})
+			}
+		}
+		json.NewEncoder(w).Encode(resps)
+	}))
+	defer server.Close()
+
+	rpcCaller := NewRPCCaller(server.Client(), nil, Endpoint{HTTPURL: server.URL, MaxBatchSize: 2})
+
+	var first, second, third string
+	elems := []BatchElem{
+		{Method: "eth_echo", Params: []any{"0x1"}, Result: &first},
+		{Method: "eth_failing", Params: []any{}, Result: new(string)},
+		{Method: "eth_echo", Params: []any{"0x2"}, Result: &second},
+		{Method: "eth_dropped", Params: []any{}, Result: new(string)},
+		{Method: "eth_echo", Params: []any{"0x3"}, Result: &third},
+	}
+	err := rpcCaller.BatchCall(context.Background(), elems)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 2, 1}, batchSizes)
+
+	assert.Equal(t, []string{"0x1", "0x2", "0x3"}, []string{first, second, third})
+	assert.NoError(t, elems[0].Error)
+	assert.NoError(t, elems[2].Error)
+	assert.NoError(t, elems[4].Error)
+
+	// A failed request does not fail the others of its batch
+	var rpcErr *RPCError
+	if assert.ErrorAs(t, elems[1].Error, &rpcErr) {
+		assert.Equal(t, CodeLimitExceeded, rpcErr.Code)
+	}
+	assert.ErrorIs(t, elems[3].Error, errMissingResponse)
+}
+
+func TestRPCCaller_BatchCall_Unsupported(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": nil, "error": map[string]any{"code": CodeMethodNotFound, "message": "batch requests are not supported"}})
+	}))
+	defer server.Close()
+
+	rpcCaller := NewRPCCaller(server.Client(), nil, Endpoint{HTTPURL: server.URL})
+
+	err := rpcCaller.BatchCall(context.Background(), []BatchElem{{Method: blockNumberMethod}})
+	var rpcErr *RPCError
+	if assert.ErrorAs(t, err, &rpcErr) {
+		assert.Equal(t, CodeMethodNotFound, rpcErr.Code)
+	}
+}
+
+func TestRPCCaller_Quantities(t *testing.T) {
+	var gotReq RPCRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&gotReq)
+
+		result := map[string]any{
+			chainIDMethod:             "0x1",
+			gasPriceMethod:            "0x3b9aca00",
+			getTransactionCountMethod: "0x2a",
+			blockNumberMethod:         "16",
+		}[gotReq.Method]
+		json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": gotReq.ID, "result": result})
+	}))
+	defer server.Close()
+
+	rpcCaller := NewRPCCaller(server.Client(), nil, Endpoint{HTTPURL: server.URL})
+	ctx := context.Background()
+
+	chainID, err := rpcCaller.ChainID(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), chainID)
+
+	gasPrice, err := rpcCaller.GasPrice(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "1000000000", gasPrice.String())
+
+	nonce, err := rpcCaller.GetTransactionCount(ctx, "0xaddress", 16)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(42), nonce)
+	assert.Equal(t, []any{"0xaddress", "0x10"}, gotReq.Params)
+
+	// Malformed quantities are reported rather than misread
+	_, err = rpcCaller.BlockNumber(ctx)
+	assert.ErrorContains(t, err, "missing 0x prefix")
+}
+
+func TestRPCCaller_GetLogs(t *testing.T) {
+	expectedLogs := []parser.Log{
+		{BlockNumber: "0x10", LogIndex: "0x0", TransactionHash: "0xhash"},
+	}
+
+	var gotReq RPCRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&gotReq)
+		json.NewEncoder(w).Encode(map[string]any{
+			"jsonrpc": "2.0",
+			"id":      gotReq.ID,
+			"result":  expectedLogs,
+		})
+	}))
+	defer server.Close()
+
+	rpcCaller := NewRPCCaller(server.Client(), nil, Endpoint{HTTPURL: server.URL})
+
+	logs, err := rpcCaller.GetLogs(context.Background(), parser.LogFilter{Addresses: []string{"0xAddress"}}, 16, 31)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedLogs, logs)
+
+	assert.Equal(t, getLogsMethod, gotReq.Method)
+	assert.Equal(t, []any{map[string]any{
+		"address":   "0xAddress",
+		"fromBlock": "0x10",
+		"toBlock":   "0x1f",
+	}}, gotReq.Params)
+
+	// Several addresses are sent as a list, wildcard positions as null and trailing ones left out
+	filter := parser.LogFilter{
+		Addresses: []string{"0xAddress", "0xOther"},
+		Topics:    [][]string{{"0xt0"}, nil, {"0xt2a", "0xt2b"}, {}},
+	}
+	_, err = rpcCaller.GetLogs(context.Background(), filter, 16, 31)
+	assert.NoError(t, err)
+	assert.Equal(t, []any{map[string]any{
+		"address":   []any{"0xAddress", "0xOther"},
+		"topics":    []any{[]any{"0xt0"}, nil, []any{"0xt2a", "0xt2b"}},
+		"fromBlock": "0x10",
+		"toBlock":   "0x1f",
+	}}, gotReq.Params)
+}
+
+func TestRPCCaller_GetLogs_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"jsonrpc": "2.0",
+			"id":      1,
+			"error":   map[string]any{"code": -32005, "message": "query returned more than 10000 results"},
+		})
+	}))
+	defer server.Close()
+
+	rpcCaller := NewRPCCaller(server.Client(), nil, Endpoint{HTTPURL: server.URL})
+
+	_, err := rpcCaller.GetLogs(context.Background(), parser.LogFilter{Addresses: []string{"0xAddress"}}, 0, 1000000)
+	assert.ErrorIs(t, err, parser.ErrRangeTooLarge)
+	var rpcErr *RPCError
+	if assert.ErrorAs(t, err, &rpcErr) {
+		assert.Equal(t, CodeLimitExceeded, rpcErr.Code)
+		assert.Equal(t, "query returned more than 10000 results", rpcErr.Message)
+	}
+}
+
+func TestRPCCaller_GetBlockByNumber(t *testing.T) {
+	expectedBlock := &parser.Block{
+		Number: "0x10",
+		Hash:   "0xblock",
+		Transactions: []parser.Transaction{
+			{Hash: "0xhash", From: "0xfrom", To: "0xto", Value: "0x1", BlockNumber: "0x10"},
+		},
+	}
+
+	var gotReq RPCRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&gotReq)
+		json.NewEncoder(w).Encode(map[string]any{
+			"jsonrpc": "2.0",
+			"id":      gotReq.ID,
+			"result":  expectedBlock,
+		})
+	}))
+	defer server.Close()
+
+	rpcCaller := NewRPCCaller(server.Client(), nil, Endpoint{HTTPURL: server.URL})
+
+	block, err := rpcCaller.GetBlockByNumber(context.Background(), 16)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedBlock, block)
+
+	assert.Equal(t, getBlockByNumberMethod, gotReq.Method)
+	assert.Equal(t, []any{"0x10", true}, gotReq.Params)
+}
+
+func TestRPCCaller_GetBlocksByNumber(t *testing.T) {
+	var gotReqs []RPCRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&gotReqs)
+
+		resps := make([]map[string]any, len(gotReqs))
+		for i, req := range gotReqs {
+			var result any
+			if number := req.Params[0].(string); number != "0x12" {
+				result = parser.Block{Number: number, Hash: "0xblock" + number}
+			}
+			resps[i] = map[string]any{"jsonrpc": "2.0", "id": req.ID, "result": result}
+		}
+		json.NewEncoder(w).Encode(resps)
+	}))
+	defer server.Close()
+
+	rpcCaller := NewRPCCaller(server.Client(), nil, Endpoint{HTTPURL: server.URL})
+
+	blocks, errs, err := rpcCaller.GetBlocksByNumber(context.Background(), []uint64{16, 17})
+	assert.NoError(t, err)
+	assert.Equal(t, []error{nil, nil}, errs)
+	assert.Equal(t, []*parser.Block{
+		{Number: "0x10", Hash: "0xblock0x10"},
+		{Number: "0x11", Hash: "0xblock0x11"},
+	}, blocks)
+
+	if assert.Len(t, gotReqs, 2) {
+		assert.Equal(t, getBlockByNumberMethod, gotReqs[0].Method)
+		assert.Equal(t, []any{"0x10", true}, gotReqs[0].Params)
+	}
+
+	// Blocks not produced yet only fail on their own
+	blocks, errs, err = rpcCaller.GetBlocksByNumber(context.Background(), []uint64{17, 18})
+	assert.NoError(t, err)
+	assert.Equal(t, []*parser.Block{{Number: "0x11", Hash: "0xblock0x11"}, nil}, blocks)
+	assert.NoError(t, errs[0])
+	assert.ErrorContains(t, errs[1], "block 18 not found")
+}
+
+func TestRPCCaller_GetHeaderByTag(t *testing.T) {
+	expectedHeader := &parser.Header{Number: "0x10", Hash: "0xblock"}
+
+	var gotReq RPCRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&gotReq)
+		json.NewEncoder(w).Encode(map[string]any{
+			"jsonrpc": "2.0",
+			"id":      gotReq.ID,
+			"result":  expectedHeader,
+		})
+	}))
+	defer server.Close()
+
+	rpcCaller := NewRPCCaller(server.Client(), nil, Endpoint{HTTPURL: server.URL})
+
+	header, err := rpcCaller.GetHeaderByTag(context.Background(), "finalized")
+	assert.NoError(t, err)
+	assert.Equal(t, expectedHeader, header)
+
+	assert.Equal(t, getBlockByNumberMethod, gotReq.Method)
+	assert.Equal(t, []any{"finalized", false}, gotReq.Params)
+}
+
+func TestRPCCaller_GetTransactionByHash(t *testing.T) {
+	expectedTxn := &parser.Transaction{Hash: "0xhash", From: "0xfrom", To: "0xto", Value: "0x1", Nonce: "0x2"}
+
+	var gotReq RPCRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&gotReq)
+
+		var result any
+		if gotReq.Params[0] == "0xhash" {
+			result = expectedTxn
+		}
+		json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": gotReq.ID, "result": result})
+	}))
+	defer server.Close()
+
+	rpcCaller := NewRPCCaller(server.Client(), nil, Endpoint{HTTPURL: server.URL})
+
+	txn, err := rpcCaller.GetTransactionByHash(context.Background(), "0xhash")
+	assert.NoError(t, err)
+	assert.Equal(t, expectedTxn, txn)
+	assert.Equal(t, getTransactionByHashMethod, gotReq.Method)
+
+	_, err = rpcCaller.GetTransactionByHash(context.Background(), "0xunknown")
+	assert.ErrorContains(t, err, "not found")
+}
+
+func TestRPCCaller_GetTransactionReceipt(t *testing.T) {
+	expectedReceipt := &parser.Receipt{
+		TransactionHash: "0xhash",
+		Status:          "0x1",
+		GasUsed:         "0x5208",
+		Logs:            []parser.Log{{LogIndex: "0x0", TransactionHash: "0xhash"}},
+	}
+
+	var gotReq RPCRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&gotReq)
+		json.NewEncoder(w).Encode(map[string]any{
+			"jsonrpc": "2.0",
+			"id":      gotReq.ID,
+			"result":  expectedReceipt,
+		})
+	}))
+	defer server.Close()
+
+	rpcCaller := NewRPCCaller(server.Client(), nil, Endpoint{HTTPURL: server.URL})
+
+	receipt, err := rpcCaller.GetTransactionReceipt(context.Background(), "0xhash")
+	assert.NoError(t, err)
+	assert.Equal(t, expectedReceipt, receipt)
+
+	assert.Equal(t, getTransactionReceiptMethod, gotReq.Method)
+	assert.Equal(t, []any{"0xhash"}, gotReq.Params)
+}
+
+func TestRPCCaller_GetTransactionReceipts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqs []RPCRequest
+		json.NewDecoder(r.Body).Decode(&reqs)
+
+		resps := make([]map[string]any, len(reqs))
+		for i, req := range reqs {
+			hash := req.Params[0].(string)
+			resps[i] = map[string]any{"jsonrpc": "2.0", "id": req.ID, "result": parser.Receipt{TransactionHash: hash, Status: "0x1"}}
+			if hash == "0xfailing" {
+				resps[i] = map[string]any{"jsonrpc": "2.0", "id": req.ID, "error": map[string]any{"code": CodeInternalError, "message": "internal error"}}
+			}
+		}
+		json.NewEncoder(w).Encode(resps)
+	}))
+	defer server.Close()
+
+	rpcCaller := NewRPCCaller(server.Client(), nil, Endpoint{HTTPURL: server.URL})
+
+	receipts, err := rpcCaller.GetTransactionReceipts(context.Background(), []string{"0xa", "0xb"})
+	assert.NoError(t, err)
+	assert.Equal(t, []*parser.Receipt{
+		{TransactionHash: "0xa", Status: "0x1"},
+		{TransactionHash: "0xb", Status: "0x1"},
+	}, receipts)
+
+	_, err = rpcCaller.GetTransactionReceipts(context.Background(), []string{"0xa", "0xfailing"})
+	assert.ErrorContains(t, err, `failed to get receipt of transaction "0xfailing"`)
+}
+
+func TestRPCCaller_GetBalance(t *testing.T) {
+	var gotReq RPCRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&gotReq)
+		json.NewEncoder(w).Encode(map[string]any{
+			"jsonrpc": "2.0",
+			"id":      gotReq.ID,
+			"result":  "0x10000000000000001",
+		})
+	}))
+	defer server.Close()
+
+	rpcCaller := NewRPCCaller(server.Client(), nil, Endpoint{HTTPURL: server.URL})
+
+	balance, err := rpcCaller.GetBalance(context.Background(), "0xaddress", 16)
+	assert.NoError(t, err)
+	assert.Equal(t, "18446744073709551617", balance.String())
+
+	assert.Equal(t, getBalanceMethod, gotReq.Method)
+	assert.Equal(t, []any{"0xaddress", "0x10"}, gotReq.Params)
+}
+
+func TestRPCCaller_GetTokenBalance(t *testing.T) {
+	const owner = "0x28c6c06298d514db089934071355e5743bf21d60"
+
+	var gotReq RPCRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&gotReq)
+		json.NewEncoder(w).Encode(map[string]any{
+			"jsonrpc": "2.0",
+			"id":      gotReq.ID,
+			"result":  "0x00000000000000000000000000000000000000000000000000000000000f4240",
+		})
+	}))
+	defer server.Close()
+
+	rpcCaller := NewRPCCaller(server.Client(), nil, Endpoint{HTTPURL: server.URL})
+
+	balance, err := rpcCaller.GetTokenBalance(context.Background(), "0xtoken", owner, 16)
+	assert.NoError(t, err)
+	assert.Equal(t, "1000000", balance.String())
+
+	assert.Equal(t, callMethod, gotReq.Method)
+	assert.Equal(t, []any{map[string]any{
+		"to":   "0xtoken",
+		"data": "0x70a08231000000000000000000000000" + owner[2:],
+	}, "0x10"}, gotReq.Params)
+}
+
+// wsURL returns the websocket URL of a test server
+func wsURL(server *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// notify sends an eth_subscription notification over a fake node connection
+func notify(conn *websocket.Conn, subID string, result any) {
+	conn.WriteJSON(map[string]any{
+		"jsonrpc": "2.0",
+		"method":  subscriptionMethod,
+		"params": map[string]any{
+			"subscription": subID,
+			"result":       result,
+		},
+	})
+}
+
+// answerHTTP answers the requests that are not websocket upgrades, eth_blockNumber with the given head
+// and the others, such as eth_getLogs, with no results, reporting whether it did
+func answerHTTP(w http.ResponseWriter, r *http.Request, head string) bool {
+	if websocket.IsWebSocketUpgrade(r) {
+		return false
+	}
+
+	var req RPCRequest
+	json.NewDecoder(r.Body).Decode(&req)
+
+	var result any = []any{}
+	if req.Method == blockNumberMethod {
+		result = head
+	}
+	json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": req.ID, "result": result})
+	return true
+}
+
+// reply answers a request received by a fake node connection
+func reply(conn *websocket.Conn, req RPCRequest, result any) {
+	conn.WriteJSON(map[string]any{
+		"jsonrpc": "2.0",
+		"id":      req.ID,
+		"result":  result,
+	})
 }
 
 func TestRPCCaller_Subscribe(t *testing.T) {
-	expectedTxn := parser.Transaction{
+	expectedTxn := parser.Log{
 		Data: "0x123",
 	}
 
+	gotParams := make(chan []any, 1)
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if answerHTTP(w, r, "0x10") {
+			return
+		}
+
 		conn, _ := websocket.Upgrade(w, r, nil, 1024, 1024)
 		defer conn.Close()
 
 		// Send ack message
-		conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+		var req RPCRequest
+		conn.ReadJSON(&req)
+		gotParams <- req.Params
+		reply(conn, req, "0x1")
 
 		// Send a transaction message
-		txnMsg, _ := json.Marshal(expectedTxn)
-		conn.WriteMessage(websocket.TextMessage, txnMsg)
+		notify(conn, "0x1", expectedTxn)
 	}))
 	defer server.Close()
 
 	wsDialer := websocket.DefaultDialer
 
-	rpcCaller := NewRPCCaller(nil, wsDialer)
-	resChan, err := rpcCaller.Subscribe(context.Background(), "0xAddress")
+	rpcCaller := NewRPCCaller(server.Client(), wsDialer, Endpoint{HTTPURL: server.URL, WSURL: wsURL(server)})
+	filter := parser.LogFilter{Addresses: []string{"0xAddress", "0xOther"}, Topics: [][]string{nil, {"0xt1"}}}
+	resChan, err := rpcCaller.Subscribe(context.Background(), "0xAddress", filter)
 	assert.NoError(t, err)
+	assert.Equal(t, []any{logsSubscription, map[string]any{
+		"address": []any{"0xAddress", "0xOther"},
+		"topics":  []any{nil, []any{"0xt1"}},
+	}}, <-gotParams)
 
 	txn := <-resChan
 	assert.Equal(t, expectedTxn, txn)
 }
+
+func TestRPCCaller_SubscribeNewHeads(t *testing.T) {
+	expectedHeader := parser.Header{Number: "0x10", Hash: "0xblock"}
+
+	gotParams := make(chan []any, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _ := websocket.Upgrade(w, r, nil, 1024, 1024)
+		defer conn.Close()
+
+		var req RPCRequest
+		conn.ReadJSON(&req)
+		gotParams <- req.Params
+		reply(conn, req, "0x1")
+
+		notify(conn, "0x1", expectedHeader)
+	}))
+	defer server.Close()
+
+	rpcCaller := NewRPCCaller(nil, websocket.DefaultDialer, Endpoint{WSURL: wsURL(server)})
+	heads, err := rpcCaller.SubscribeNewHeads(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []any{newHeadsSubscription}, <-gotParams)
+
+	select {
+	case header := <-heads:
+		assert.Equal(t, expectedHeader, header)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for header")
+	}
+}
+
+func TestRPCCaller_Subscribe_Multiplexed(t *testing.T) {
+	var connections atomic.Int32
+	unsubscribed := make(chan any, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if answerHTTP(w, r, "0x10") {
+			return
+		}
+
+		connections.Add(1)
+		conn, _ := websocket.Upgrade(w, r, nil, 1024, 1024)
+		defer conn.Close()
+
+		for {
+			var req RPCRequest
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+
+			switch req.Method {
+			case subscribeMethod:
+				// Subscription IDs are derived from the subscribed address
+				address := req.Params[1].(map[string]any)["address"].(string)
+				subID := "0x" + address
+				reply(conn, req, subID)
+				notify(conn, subID, parser.Log{Address: address})
+			case unsubscribeMethod:
+				unsubscribed <- req.Params[0]
+				reply(conn, req, true)
+			}
+		}
+	}))
+	defer server.Close()
+
+	rpcCaller := NewRPCCaller(server.Client(), websocket.DefaultDialer, Endpoint{HTTPURL: server.URL, WSURL: wsURL(server)})
+
+	resChan1, err := rpcCaller.Subscribe(context.Background(), "a1", parser.LogFilter{Addresses: []string{"a1"}})
+	assert.NoError(t, err)
+	resChan2, err := rpcCaller.Subscribe(context.Background(), "a2", parser.LogFilter{Addresses: []string{"a2"}})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "a1", (<-resChan1).Address)
+	assert.Equal(t, "a2", (<-resChan2).Address)
+	assert.EqualValues(t, 1, connections.Load())
+	assert.Equal(t, parser.ConnectionStateConnected, rpcCaller.ConnectionState("a1"))
+
+	_, err = rpcCaller.Subscribe(context.Background(), "a1", parser.LogFilter{Addresses: []string{"a1"}})
+	assert.Error(t, err)
+
+	err = rpcCaller.Unsubscribe(context.Background(), "a1")
+	assert.NoError(t, err)
+	assert.Equal(t, "0xa1", <-unsubscribed)
+
+	_, ok := <-resChan1
+	assert.False(t, ok)
+	assert.Equal(t, parser.ConnectionStateDisconnected, rpcCaller.ConnectionState("a1"))
+
+	err = rpcCaller.Unsubscribe(context.Background(), "a1")
+	assert.Error(t, err)
+}
+
+func TestRPCCaller_Subscribe_Reconnect(t *testing.T) {
+	delivered := parser.Log{BlockNumber: "0x10", TransactionHash: "0xa", LogIndex: "0x0"}
+	missed := parser.Log{BlockNumber: "0x11", TransactionHash: "0xb", LogIndex: "0x0"}
+	afterReconnect := parser.Log{BlockNumber: "0x12", TransactionHash: "0xc", LogIndex: "0x0"}
+
+	var connections atomic.Int32
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !websocket.IsWebSocketUpgrade(r) {
+			var req RPCRequest
+			json.NewDecoder(r.Body).Decode(&req)
+
+			resp := map[string]any{"jsonrpc": "2.0", "id": req.ID}
+			switch req.Method {
+			case blockNumberMethod:
+				resp["result"] = "0x12"
+			case getLogsMethod:
+				resp["result"] = []parser.Log{delivered, missed}
+			}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		conn, _ := websocket.Upgrade(w, r, nil, 1024, 1024)
+		defer conn.Close()
+
+		var req RPCRequest
+		conn.ReadJSON(&req)
+
+		if connections.Add(1) == 1 {
+			// Drop the first connection right after delivering a log
+			reply(conn, req, "0x1")
+			notify(conn, "0x1", delivered)
+			return
+		}
+
+		// The live stream resumes before the missed logs are fetched
+		reply(conn, req, "0x2")
+		notify(conn, "0x2", afterReconnect)
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	rpcCaller := NewRPCCaller(server.Client(), websocket.DefaultDialer, Endpoint{HTTPURL: server.URL, WSURL: wsURL(server)})
+	rpcCaller.minReconnectDelay = time.Millisecond
+	rpcCaller.maxReconnectDelay = time.Millisecond
+
+	resChan, err := rpcCaller.Subscribe(context.Background(), "0xAddress", parser.LogFilter{Addresses: []string{"0xAddress"}})
+	assert.NoError(t, err)
+
+	for _, expected := range []parser.Log{delivered, missed, afterReconnect} {
+		select {
+		case txn := <-resChan:
+			assert.Equal(t, expected, txn)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for transaction %v", expected)
+		}
+	}
+	assert.EqualValues(t, 2, connections.Load())
+}
+
+func TestRPCCaller_Subscribe_ReconnectBeforeFirstLog(t *testing.T) {
+	missed := parser.Log{BlockNumber: "0x11", TransactionHash: "0xa", LogIndex: "0x0"}
+	afterReconnect := parser.Log{BlockNumber: "0x7e1", TransactionHash: "0xb", LogIndex: "0x0"}
+
+	var head atomic.Uint64
+	head.Store(16)
+	var connections atomic.Int32
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !websocket.IsWebSocketUpgrade(r) {
+			var req RPCRequest
+			json.NewDecoder(r.Body).Decode(&req)
+
+			resp := map[string]any{"jsonrpc": "2.0", "id": req.ID}
+			switch req.Method {
+			case blockNumberMethod:
+				resp["result"] = quantity.Hex(head.Load())
+			case getLogsMethod:
+				// The node serves at most 600 blocks per call
+				params := req.Params[0].(map[string]any)
+				fromBlock, _ := quantity.ParseHex(params["fromBlock"].(string))
+				toBlock, _ := quantity.ParseHex(params["toBlock"].(string))
+				if toBlock-fromBlock >= 600 {
+					resp["error"] = map[string]any{"code": CodeLimitExceeded, "message": "limit exceeded"}
+				} else if fromBlock <= 17 && toBlock >= 17 {
+					resp["result"] = []parser.Log{missed}
+				} else {
+					resp["result"] = []parser.Log{}
+				}
+			}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		conn, _ := websocket.Upgrade(w, r, nil, 1024, 1024)
+		defer conn.Close()
+
+		var req RPCRequest
+		conn.ReadJSON(&req)
+
+		if connections.Add(1) == 1 {
+			// Drop the first connection before any log is delivered, the chain moving on meanwhile
+			head.Store(2016)
+			reply(conn, req, "0x1")
+			return
+		}
+
+		reply(conn, req, "0x2")
+		notify(conn, "0x2", afterReconnect)
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	rpcCaller := NewRPCCaller(server.Client(), websocket.DefaultDialer, Endpoint{HTTPURL: server.URL, WSURL: wsURL(server)})
+	rpcCaller.minReconnectDelay = time.Millisecond
+	rpcCaller.maxReconnectDelay = time.Millisecond
+
+	resChan, err := rpcCaller.Subscribe(context.Background(), "0xAddress", parser.LogFilter{Addresses: []string{"0xAddress"}})
+	assert.NoError(t, err)
+
+	// The logs missed are fetched from the head at the time of subscribing, in ranges the node serves
+	for _, expected := range []parser.Log{missed, afterReconnect} {
+		select {
+		case entry := <-resChan:
+			assert.Equal(t, expected, entry)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for log %v", expected)
+		}
+	}
+}
+
+func TestRPCCaller_Subscribe_Overflow(t *testing.T) {
+	logs := make([]parser.Log, subscriptionBufferSize+10)
+	for i := range logs {
+		logs[i] = parser.Log{BlockNumber: quantity.Hex(uint64(i + 1)), TransactionHash: "0xa", LogIndex: "0x0"}
+	}
+
+	var getLogsCalls atomic.Int32
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !websocket.IsWebSocketUpgrade(r) {
+			var req RPCRequest
+			json.NewDecoder(r.Body).Decode(&req)
+
+			resp := map[string]any{"jsonrpc": "2.0", "id": req.ID}
+			switch req.Method {
+			case blockNumberMethod:
+				resp["result"] = quantity.Hex(uint64(len(logs)))
+			case getLogsMethod:
+				getLogsCalls.Add(1)
+				params := req.Params[0].(map[string]any)
+				fromBlock, _ := quantity.ParseHex(params["fromBlock"].(string))
+				toBlock, _ := quantity.ParseHex(params["toBlock"].(string))
+				resp["result"] = logs[fromBlock-1 : toBlock]
+			}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		conn, _ := websocket.Upgrade(w, r, nil, 1024, 1024)
+		defer conn.Close()
+
+		var req RPCRequest
+		conn.ReadJSON(&req)
+		reply(conn, req, "0x1")
+		for _, entry := range logs {
+			notify(conn, "0x1", entry)
+		}
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	rpcCaller := NewRPCCaller(server.Client(), websocket.DefaultDialer, Endpoint{HTTPURL: server.URL, WSURL: wsURL(server)})
+	rpcCaller.minReconnectDelay = time.Millisecond
+	rpcCaller.maxReconnectDelay = time.Millisecond
+
+	resChan, err := rpcCaller.Subscribe(context.Background(), "0xAddress", parser.LogFilter{Addresses: []string{"0xAddress"}})
+	assert.NoError(t, err)
+
+	// The consumer lags behind until the channel is full, the logs overflowing it are fetched again
+	assert.Eventually(t, func() bool { return len(resChan) == subscriptionBufferSize }, 5*time.Second, time.Millisecond)
+	for _, expected := range logs {
+		select {
+		case entry := <-resChan:
+			assert.Equal(t, expected, entry)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for log %v", expected)
+		}
+	}
+	assert.Positive(t, getLogsCalls.Load())
+}
+
+func TestRPCCaller_Subscribe_ResubscribeRetry(t *testing.T) {
+	afterRetry := parser.Log{BlockNumber: "0x10", TransactionHash: "0xa", LogIndex: "0x0"}
+
+	var connections atomic.Int32
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if answerHTTP(w, r, "0x10") {
+			return
+		}
+
+		conn, _ := websocket.Upgrade(w, r, nil, 1024, 1024)
+		defer conn.Close()
+
+		var req RPCRequest
+		conn.ReadJSON(&req)
+
+		if connections.Add(1) == 1 {
+			// Drop the first connection right after subscribing
+			reply(conn, req, "0x1")
+			return
+		}
+
+		// The node refuses the first resubscription
+		conn.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": req.ID, "error": map[string]any{"code": CodeInternalError, "message": "internal error"}})
+
+		conn.ReadJSON(&req)
+		reply(conn, req, "0x2")
+		notify(conn, "0x2", afterRetry)
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	rpcCaller := NewRPCCaller(server.Client(), websocket.DefaultDialer, Endpoint{HTTPURL: server.URL, WSURL: wsURL(server)})
+	rpcCaller.minReconnectDelay = time.Millisecond
+	rpcCaller.maxReconnectDelay = time.Millisecond
+
+	resChan, err := rpcCaller.Subscribe(context.Background(), "0xAddress", parser.LogFilter{Addresses: []string{"0xAddress"}})
+	assert.NoError(t, err)
+
+	select {
+	case entry := <-resChan:
+		assert.Equal(t, afterRetry, entry)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the log delivered after resubscribing")
+	}
+	assert.Equal(t, parser.ConnectionStateConnected, rpcCaller.ConnectionState("0xAddress"))
+	assert.EqualValues(t, 2, connections.Load())
+}
+
+func TestRPCCaller_ReconnectDelay(t *testing.T) {
+	rpcCaller := NewRPCCaller(nil, nil, DefaultEndpoint())
+
+	for attempt, maxDelay := range []time.Duration{minReconnectDelay, 2 * minReconnectDelay, 4 * minReconnectDelay} {
+		delay := rpcCaller.reconnectDelay(attempt)
+		assert.GreaterOrEqual(t, delay, maxDelay/2)
+		assert.LessOrEqual(t, delay, maxDelay)
+	}
+
+	assert.LessOrEqual(t, rpcCaller.reconnectDelay(100), maxReconnectDelay)
+}
+
+func TestDeliver_Removed(t *testing.T) {
+	orphaned := parser.Log{BlockNumber: "0x10", BlockHash: "0xorphaned", TransactionHash: "0xa", LogIndex: "0x0"}
+	later := parser.Log{BlockNumber: "0x11", BlockHash: "0xlater", TransactionHash: "0xb", LogIndex: "0x0"}
+	canonical := parser.Log{BlockNumber: "0x10", BlockHash: "0xcanonical", TransactionHash: "0xa", LogIndex: "0x0"}
+
+	var cursor logCursor
+	resChan := make(chan parser.Log, 10)
+
+	deliver(orphaned, &cursor, resChan)
+	deliver(later, &cursor, resChan)
+
+	// The reorganisation removes both logs, their block is already behind the cursor
+	removedOrphaned, removedLater := orphaned, later
+	removedOrphaned.Removed, removedLater.Removed = true, true
+	deliver(removedLater, &cursor, resChan)
+	deliver(removedOrphaned, &cursor, resChan)
+
+	// The same transaction included in the new branch is delivered again, but only once
+	deliver(canonical, &cursor, resChan)
+	deliver(canonical, &cursor, resChan)
+	close(resChan)
+
+	var got []parser.Log
+	for entry := range resChan {
+		got = append(got, entry)
+	}
+	assert.Equal(t, []parser.Log{orphaned, later, removedLater, removedOrphaned, canonical}, got)
+}
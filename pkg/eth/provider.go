@@ -0,0 +1,264 @@
+package eth
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/HomayoonAlimohammadi/blockchain-parser/internal/parser"
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/eth/quantity"
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/log"
+)
+
+// provider is an endpoint calls are routed to, along with the health observed on the calls and checks made to it
+type provider struct {
+	endpoint Endpoint
+	// name identifies the provider in logs and health reports without the path and query of its URL,
+	// which often hold an API key
+	name string
+
+	mu sync.Mutex
+	// latency is the moving average of the duration of the successful requests, 0 until one is made
+	latency time.Duration
+	// headBlock is the most recent block reported by the last successful health check
+	headBlock uint64
+	// failures is the number of requests failed in a row
+	failures int
+	lastErr  error
+	// checkedAt is the time of the last health check
+	checkedAt time.Time
+}
+
+// newProvider creates a provider for an endpoint
+func newProvider(endpoint Endpoint) *provider {
+	p := &provider{endpoint: endpoint}
+	for _, rawURL := range []string{endpoint.HTTPURL, endpoint.WSURL} {
+		if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+			p.name = u.Scheme + "://" + u.Host
+			break
+		}
+	}
+
+	return p
+}
+
+// redactURL strips the URL from the errors of the url package, so the API key its path and query often
+// hold is neither logged nor reported in the health of the provider
+func redactURL(err error) error {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return err
+	}
+
+	return fmt.Errorf("%s: %w", urlErr.Op, urlErr.Err)
+}
+
+// recordSuccess records a successful request of the given duration
+func (p *provider) recordSuccess(latency time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.latency == 0 {
+		p.latency = latency
+	} else {
+		p.latency += (latency - p.latency) / latencySmoothing
+	}
+	p.failures = 0
+	p.lastErr = nil
+}
+
+// recordFailure records a failed request
+func (p *provider) recordFailure(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.failures++
+	p.lastErr = err
+}
+
+// recordCheck records a health check, along with the head block it reported if any
+func (p *provider) recordCheck(headBlock uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if headBlock != 0 {
+		p.headBlock = headBlock
+	}
+	p.checkedAt = time.Now()
+}
+
+// providerState is a snapshot of the health of a provider
+type providerState struct {
+	provider  *provider
+	latency   time.Duration
+	headBlock uint64
+	failures  int
+	lastErr   error
+	checkedAt time.Time
+}
+
+// states returns a snapshot of the health of every provider, in the configured order
+func (c *rpcCaller) states() []providerState {
+	states := make([]providerState, len(c.providers))
+	for i, p := range c.providers {
+		p.mu.Lock()
+		states[i] = providerState{
+			provider:  p,
+			latency:   p.latency,
+			headBlock: p.headBlock,
+			failures:  p.failures,
+			lastErr:   p.lastErr,
+			checkedAt: p.checkedAt,
+		}
+		p.mu.Unlock()
+	}
+
+	return states
+}
+
+// headLag returns the number of blocks a provider is behind the most advanced one, 0 if its head is unknown
+func headLag(state providerState, head uint64) uint64 {
+	if state.headBlock == 0 {
+		return 0
+	}
+	return head - state.headBlock
+}
+
+// healthy reports whether a provider did not fail its last request and is not lagging behind the others
+func healthy(state providerState, head uint64) bool {
+	return state.failures == 0 && headLag(state, head) <= maxHeadLag
+}
+
+// rankedStates returns a snapshot of the health of every provider, the healthiest first: the healthy
+// ones, then the least lagging, then the fastest. Providers that failed are kept as a last resort,
+// the ones failing the least first, and ties keep the configured order.
+func (c *rpcCaller) rankedStates() ([]providerState, uint64) {
+	states := c.states()
+
+	var head uint64
+	for _, state := range states {
+		head = max(head, state.headBlock)
+	}
+
+	slices.SortStableFunc(states, func(a, b providerState) int {
+		aHealthy, bHealthy := healthy(a, head), healthy(b, head)
+		if aHealthy != bHealthy {
+			if aHealthy {
+				return -1
+			}
+			return 1
+		}
+
+		if n := cmp.Compare(a.failures, b.failures); n != 0 {
+			return n
+		} else if n := cmp.Compare(headLag(a, head), headLag(b, head)); n != 0 {
+			return n
+		}
+
+		// Providers no request was made to yet come after the measured ones
+		if (a.latency == 0) != (b.latency == 0) {
+			if a.latency == 0 {
+				return 1
+			}
+			return -1
+		}
+		return cmp.Compare(a.latency, b.latency)
+	})
+
+	return states, head
+}
+
+// rankedProviders returns the providers, the healthiest first, the order calls are routed to them in
+func (c *rpcCaller) rankedProviders() []*provider {
+	states, _ := c.rankedStates()
+
+	providers := make([]*provider, len(states))
+	for i, state := range states {
+		providers[i] = state.provider
+	}
+
+	return providers
+}
+
+// ProviderHealth returns the health of every provider, in the order calls are routed to them
+func (c *rpcCaller) ProviderHealth() []parser.ProviderHealth {
+	states, head := c.rankedStates()
+	streaming := c.conns.streamingProvider()
+
+	health := make([]parser.ProviderHealth, len(states))
+	for i, state := range states {
+		health[i] = parser.ProviderHealth{
+			Name:                state.provider.name,
+			Healthy:             healthy(state, head),
+			Streaming:           state.provider == streaming,
+			LatencyMS:           state.latency.Milliseconds(),
+			HeadBlock:           state.headBlock,
+			HeadLag:             headLag(state, head),
+			ConsecutiveFailures: state.failures,
+			CheckedAt:           state.checkedAt,
+		}
+		if state.lastErr != nil {
+			health[i].LastError = state.lastErr.Error()
+		}
+	}
+
+	return health
+}
+
+// MonitorProviders checks the health of every provider right away, then periodically until the context is done
+func (c *rpcCaller) MonitorProviders(ctx context.Context) {
+	ticker := time.NewTicker(c.healthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		c.checkProviders(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkProviders calls eth_blockNumber on every provider concurrently, measuring its latency and head block
+func (c *rpcCaller) checkProviders(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, p := range c.providers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+
+			var head string
+			err := c.callProvider(checkCtx, p, blockNumberMethod, nil, &head)
+			if ctx.Err() != nil {
+				return
+			}
+
+			var headBlock uint64
+			if err == nil {
+				if headBlock, err = quantity.ParseHex(head); err != nil {
+					p.recordFailure(err)
+				}
+			} else if checkCtx.Err() != nil {
+				// Requests cut short by their context are not recorded as failures, but a check timing out is one
+				p.recordFailure(err)
+			}
+
+			if err != nil {
+				log.Warn("provider health check failed", "provider", p.name, "error", err)
+			}
+			p.recordCheck(headBlock)
+		}()
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,215 @@
+package eth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/eth/quantity"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeNode is a JSON-RPC server answering eth_blockNumber with its head, unless it is down
+type fakeNode struct {
+	*httptest.Server
+	head     atomic.Uint64
+	down     atomic.Bool
+	requests atomic.Int64
+}
+
+func newFakeNode(head uint64) *fakeNode {
+	node := &fakeNode{}
+	node.head.Store(head)
+	node.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		node.requests.Add(1)
+		if node.down.Load() {
+			http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		var req RPCRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Method == callMethod {
+			json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": req.ID, "error": map[string]any{"code": 3, "message": "execution reverted"}})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": req.ID, "result": quantity.Hex(node.head.Load())})
+	}))
+
+	return node
+}
+
+func (n *fakeNode) endpoint() Endpoint {
+	return Endpoint{HTTPURL: n.URL, WSURL: wsURL(n.Server)}
+}
+
+func TestRPCCaller_Failover(t *testing.T) {
+	primary, fallback := newFakeNode(16), newFakeNode(16)
+	defer primary.Close()
+	defer fallback.Close()
+
+	rpcCaller := NewRPCCaller(http.DefaultClient, nil, primary.endpoint(), fallback.endpoint())
+	ctx := context.Background()
+
+	// Calls go to the primary while it is healthy
+	_, err := rpcCaller.BlockNumber(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), primary.requests.Load())
+	assert.Equal(t, int64(0), fallback.requests.Load())
+
+	// Errors returned by the node are not failed over
+	err = rpcCaller.Call(ctx, callMethod, []any{}, nil)
+	assert.Error(t, err)
+	assert.Equal(t, int64(0), fallback.requests.Load())
+
+	// A failing provider is failed over, then ranked after the healthy ones
+	primary.down.Store(true)
+	block, err := rpcCaller.BlockNumber(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(16), block)
+	assert.Equal(t, int64(1), fallback.requests.Load())
+
+	_, err = rpcCaller.BlockNumber(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), primary.requests.Load())
+	assert.Equal(t, int64(2), fallback.requests.Load())
+
+	health := rpcCaller.ProviderHealth()
+	if assert.Len(t, health, 2) {
+		assert.Equal(t, fallback.URL, health[0].Name)
+		assert.True(t, health[0].Healthy)
+		assert.Equal(t, primary.URL, health[1].Name)
+		assert.False(t, health[1].Healthy)
+		assert.Equal(t, 1, health[1].ConsecutiveFailures)
+		assert.Contains(t, health[1].LastError, "503")
+	}
+
+	fallback.down.Store(true)
+	_, err = rpcCaller.BlockNumber(ctx)
+	assert.ErrorContains(t, err, "all 2 providers failed")
+}
+
+func TestRPCCaller_RedactURL(t *testing.T) {
+	down, fallback := newFakeNode(16), newFakeNode(16)
+	down.Close()
+	defer fallback.Close()
+
+	// The path of the URL holds the API key, which must not show in errors, logs, and health reports
+	endpoint := Endpoint{HTTPURL: down.URL + "/v3/secret-key"}
+	rpcCaller := NewRPCCaller(http.DefaultClient, nil, endpoint, fallback.endpoint())
+	ctx := context.Background()
+
+	_, err := rpcCaller.BlockNumber(ctx)
+	assert.NoError(t, err)
+
+	fallback.down.Store(true)
+	_, err = rpcCaller.BlockNumber(ctx)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), down.URL)
+		assert.NotContains(t, err.Error(), "secret-key")
+	}
+
+	rpcCaller.checkProviders(ctx)
+	for _, provider := range rpcCaller.ProviderHealth() {
+		assert.NotEmpty(t, provider.LastError, provider.Name)
+		assert.NotContains(t, provider.LastError, "secret-key", provider.Name)
+	}
+}
+
+func TestRPCCaller_CheckProviders(t *testing.T) {
+	primary, fallback := newFakeNode(16), newFakeNode(32)
+	defer primary.Close()
+	defer fallback.Close()
+
+	rpcCaller := NewRPCCaller(http.DefaultClient, nil, primary.endpoint(), fallback.endpoint())
+	ctx := context.Background()
+
+	// A provider lagging behind the others is unhealthy
+	rpcCaller.checkProviders(ctx)
+	health := rpcCaller.ProviderHealth()
+	if assert.Len(t, health, 2) {
+		assert.Equal(t, fallback.URL, health[0].Name)
+		assert.True(t, health[0].Healthy)
+		assert.Equal(t, uint64(32), health[0].HeadBlock)
+		assert.False(t, health[0].CheckedAt.IsZero())
+
+		assert.False(t, health[1].Healthy)
+		assert.Equal(t, uint64(16), health[1].HeadBlock)
+		assert.Equal(t, uint64(16), health[1].HeadLag)
+	}
+
+	block, err := rpcCaller.BlockNumber(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(32), block)
+
+	// Checks find out a provider is down without a call failing, and that it recovered
+	primary.head.Store(32)
+	fallback.down.Store(true)
+	rpcCaller.checkProviders(ctx)
+	health = rpcCaller.ProviderHealth()
+	if assert.Len(t, health, 2) {
+		assert.Equal(t, primary.URL, health[0].Name)
+		assert.True(t, health[0].Healthy)
+		assert.False(t, health[1].Healthy)
+	}
+
+	fallback.down.Store(false)
+	rpcCaller.checkProviders(ctx)
+	for _, provider := range rpcCaller.ProviderHealth() {
+		assert.True(t, provider.Healthy, provider.Name)
+	}
+}
+
+func TestRPCCaller_MonitorProviders(t *testing.T) {
+	node := newFakeNode(16)
+	defer node.Close()
+
+	rpcCaller := NewRPCCaller(http.DefaultClient, nil, node.endpoint())
+	rpcCaller.healthCheckInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		rpcCaller.MonitorProviders(ctx)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool { return node.requests.Load() >= 3 }, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+}
+
+func TestRPCCaller_Subscribe_Failover(t *testing.T) {
+	down := newFakeNode(16)
+	down.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _ := websocket.Upgrade(w, r, nil, 1024, 1024)
+		defer conn.Close()
+
+		var req RPCRequest
+		conn.ReadJSON(&req)
+		reply(conn, req, "0x1")
+
+		// Keep the connection open until the client is done
+		conn.ReadMessage()
+	}))
+	defer server.Close()
+
+	rpcCaller := NewRPCCaller(http.DefaultClient, websocket.DefaultDialer, down.endpoint(), Endpoint{HTTPURL: server.URL, WSURL: wsURL(server)})
+	_, err := rpcCaller.SubscribeNewHeads(context.Background())
+	assert.NoError(t, err)
+
+	health := rpcCaller.ProviderHealth()
+	if assert.Len(t, health, 2) {
+		assert.Equal(t, server.URL, health[0].Name)
+		assert.True(t, health[0].Streaming)
+		assert.False(t, health[1].Streaming)
+		assert.False(t, health[1].Healthy)
+	}
+}
@@ -0,0 +1,41 @@
+package quantity
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// ParseHex parses a 0x-prefixed hex quantity, such as a block number or a log index
+func ParseHex(s string) (uint64, error) {
+	digits, ok := strings.CutPrefix(s, "0x")
+	if !ok {
+		return 0, fmt.Errorf("missing 0x prefix in %q", s)
+	}
+
+	return strconv.ParseUint(digits, 16, 64)
+}
+
+// Hex encodes a quantity as the 0x-prefixed hex string expected by JSON-RPC
+func Hex(n uint64) string {
+	return fmt.Sprintf("0x%x", n)
+}
+
+// Decimal returns the decimal representation of a value, 0 if it is nil. Large values are encoded
+// in JSON as decimal strings, JSON numbers lose precision above 2^53 in most decoders.
+func Decimal(value *big.Int) string {
+	if value == nil {
+		return "0"
+	}
+	return value.String()
+}
+
+// ParseDecimal parses a value encoded by Decimal
+func ParseDecimal(s string) (*big.Int, error) {
+	value, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid value %q, expected a decimal integer", s)
+	}
+	return value, nil
+}
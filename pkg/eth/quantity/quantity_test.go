@@ -0,0 +1,38 @@
+package quantity
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHex(t *testing.T) {
+	n, err := ParseHex("0x10")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(16), n)
+
+	_, err = ParseHex("16")
+	assert.ErrorContains(t, err, "missing 0x prefix")
+
+	_, err = ParseHex("0xzz")
+	assert.Error(t, err)
+
+	assert.Equal(t, "0x10", Hex(16))
+	assert.Equal(t, "0x0", Hex(0))
+}
+
+func TestParseDecimal(t *testing.T) {
+	// Values above 2^53 keep their precision
+	value, ok := new(big.Int).SetString("18446744073709551617", 10)
+	assert.True(t, ok)
+	assert.Equal(t, "18446744073709551617", Decimal(value))
+	assert.Equal(t, "0", Decimal(nil))
+
+	parsed, err := ParseDecimal("18446744073709551617")
+	assert.NoError(t, err)
+	assert.Equal(t, value, parsed)
+
+	_, err = ParseDecimal("0x10")
+	assert.ErrorContains(t, err, "expected a decimal integer")
+}
@@ -0,0 +1,318 @@
+package eth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/eth/quantity"
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/log"
+)
+
+// Error codes of JSON-RPC and of the Ethereum JSON-RPC API (EIP-1474)
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+	// CodeLimitExceeded is returned by nodes when a request exceeds their limits, such as the
+	// blocks or results of a single eth_getLogs call
+	CodeLimitExceeded = -32005
+)
+
+var (
+	// errMissingResult is returned when a response holds neither a result nor an error
+	errMissingResult = errors.New("missing result")
+	// errMissingResponse is returned for the requests of a batch the node did not answer
+	errMissingResponse = errors.New("missing response in batch")
+)
+
+// RPCRequest is a JSON-RPC request
+type RPCRequest struct {
+	Jsonrpc string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  []any  `json:"params"`
+	ID      int    `json:"id"`
+}
+
+// RPCResponse is a JSON-RPC response, holding either the raw result or an error
+type RPCResponse struct {
+	Jsonrpc string          `json:"jsonrpc"`
+	ID      int             `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// RPCError is the error object of a failed JSON-RPC request, it can be matched with errors.As
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	// Data holds additional information on the error, such as the revert reason of eth_call
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// Error returns the code and message of the error
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// newID returns the ID of a new request, unique across the HTTP and websocket requests of the caller
+func (c *rpcCaller) newID() int {
+	return int(c.nextID.Add(1))
+}
+
+// newRequest returns a JSON-RPC request with a new ID
+func (c *rpcCaller) newRequest(method string, params []any) RPCRequest {
+	return RPCRequest{Jsonrpc: rpcVersion, Method: method, Params: params, ID: c.newID()}
+}
+
+// Call sends a JSON-RPC request over HTTP and decodes its result into result, which is left untouched
+// when nil. A request the node fails returns its *RPCError.
+func (c *rpcCaller) Call(ctx context.Context, method string, params []any, result any) error {
+	req := c.newRequest(method, params)
+
+	var resp RPCResponse
+	if err := c.post(ctx, req, &resp); err != nil {
+		return fmt.Errorf("%s failed: %w", method, err)
+	}
+
+	return decodeResponse(method, req.ID, resp, result)
+}
+
+// callProvider sends a JSON-RPC request to a single provider, without failing over
+func (c *rpcCaller) callProvider(ctx context.Context, p *provider, method string, params []any, result any) error {
+	req := c.newRequest(method, params)
+
+	var resp RPCResponse
+	if err := c.postTo(ctx, p, req, &resp); err != nil {
+		return fmt.Errorf("%s failed: %w", method, err)
+	}
+
+	return decodeResponse(method, req.ID, resp, result)
+}
+
+// BatchElem is a request of a batch call along with its outcome
+type BatchElem struct {
+	Method string
+	Params []any
+	// Result receives the decoded result, it is left untouched when nil or when the request failed
+	Result any
+	// Error is set when the request failed, the other requests of the batch are unaffected
+	Error error
+}
+
+// BatchCall sends the requests as JSON-RPC batches of at most the smallest maximum batch size of the endpoints,
+// saving a round-trip per request. The responses are matched to the requests by ID, whatever their order,
+// and the outcome of each request is set in its element. The returned error is for a batch failing as a whole.
+func (c *rpcCaller) BatchCall(ctx context.Context, elems []BatchElem) error {
+	// Any provider may receive a batch
+	size := 0
+	for _, p := range c.providers {
+		if limit := p.endpoint.MaxBatchSize; limit > 0 && (size == 0 || limit < size) {
+			size = limit
+		}
+	}
+	if size == 0 {
+		size = defaultMaxBatchSize
+	}
+
+	for start := 0; start < len(elems); start += size {
+		if err := c.batchCall(ctx, elems[start:min(start+size, len(elems))]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// batchCall sends a single batch of requests
+func (c *rpcCaller) batchCall(ctx context.Context, elems []BatchElem) error {
+	reqs := make([]RPCRequest, len(elems))
+	indexes := make(map[int]int, len(elems))
+	for i, elem := range elems {
+		reqs[i] = c.newRequest(elem.Method, elem.Params)
+		indexes[reqs[i].ID] = i
+	}
+
+	// Nodes not supporting batches answer with a single error object rather than an array
+	var raw json.RawMessage
+	if err := c.post(ctx, reqs, &raw); err != nil {
+		return fmt.Errorf("batch failed: %w", err)
+	}
+
+	var resps []RPCResponse
+	if err := json.Unmarshal(raw, &resps); err != nil {
+		var resp RPCResponse
+		if json.Unmarshal(raw, &resp) == nil && resp.Error != nil {
+			return fmt.Errorf("batch failed: %w", resp.Error)
+		}
+		return fmt.Errorf("failed to decode batch response: %w", err)
+	}
+
+	answered := make([]bool, len(elems))
+	for _, resp := range resps {
+		i, ok := indexes[resp.ID]
+		if !ok || answered[i] {
+			continue
+		}
+
+		elems[i].Error = decodeResponse(elems[i].Method, resp.ID, resp, elems[i].Result)
+		answered[i] = true
+	}
+
+	for i := range elems {
+		if !answered[i] {
+			elems[i].Error = fmt.Errorf("%s failed: %w", elems[i].Method, errMissingResponse)
+		}
+	}
+
+	return nil
+}
+
+// post posts a JSON-RPC request, or a batch of them, to the healthiest provider and decodes the response
+// into resp. It fails over to the next provider when one cannot be reached or does not answer, errors
+// returned by the node itself are not retried as the other providers would answer the same.
+func (c *rpcCaller) post(ctx context.Context, body any, resp any) error {
+	providers := c.rankedProviders()
+	if len(providers) == 1 {
+		return c.postTo(ctx, providers[0], body, resp)
+	}
+
+	errs := make([]error, 0, len(providers))
+	for _, p := range providers {
+		err := c.postTo(ctx, p, body, resp)
+		if err == nil {
+			return nil
+		} else if ctx.Err() != nil {
+			return err
+		}
+
+		log.Warn("provider failed, failing over", "provider", p.name, "error", err)
+		errs = append(errs, fmt.Errorf("%s: %w", p.name, err))
+	}
+
+	return fmt.Errorf("all %d providers failed: %w", len(providers), errors.Join(errs...))
+}
+
+// postTo posts a JSON-RPC request, or a batch of them, to a single provider and decodes the response
+// into resp, recording the outcome in the health of the provider
+func (c *rpcCaller) postTo(ctx context.Context, p *provider, body any, resp any) error {
+	start := time.Now()
+	raw, err := c.postRaw(ctx, p.endpoint, body)
+	if err != nil {
+		// Requests cancelled by the caller say nothing of the provider
+		if ctx.Err() == nil {
+			p.recordFailure(err)
+		}
+		return err
+	}
+	p.recordSuccess(time.Since(start))
+
+	if err := json.Unmarshal(raw, resp); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return nil
+}
+
+// postRaw posts a JSON-RPC request, or a batch of them, to an endpoint and returns the JSON response
+func (c *rpcCaller) postRaw(ctx context.Context, endpoint Endpoint, body any) (json.RawMessage, error) {
+	jsonReq, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.HTTPURL, bytes.NewBuffer(jsonReq))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", redactURL(err))
+	}
+
+	for key, values := range endpoint.Header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	httpResp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", redactURL(err))
+	}
+	defer httpResp.Body.Close()
+
+	// Nodes behind gateways answer rate limits and outages with a status, along with a JSON-RPC body or not
+	if httpResp.StatusCode == http.StatusTooManyRequests || httpResp.StatusCode >= http.StatusInternalServerError {
+		return nil, fmt.Errorf("unexpected status %s", httpResp.Status)
+	}
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(httpResp.Body).Decode(&raw); err != nil {
+		if httpResp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status %s", httpResp.Status)
+		}
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return raw, nil
+}
+
+// decodeResponse decodes the result of the response to the request of the given ID into result
+func decodeResponse(method string, id int, resp RPCResponse, result any) error {
+	if resp.Error != nil {
+		return fmt.Errorf("%s failed: %w", method, resp.Error)
+	} else if resp.ID != id {
+		return fmt.Errorf("%s failed: response ID %d does not match request ID %d", method, resp.ID, id)
+	} else if len(resp.Result) == 0 {
+		return fmt.Errorf("%s failed: %w", method, errMissingResult)
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(resp.Result, result); err != nil {
+		return fmt.Errorf("failed to decode %s result: %w", method, err)
+	}
+
+	return nil
+}
+
+// callQuantity calls a method returning a hex quantity that fits in an uint64
+func (c *rpcCaller) callQuantity(ctx context.Context, method string, params []any) (uint64, error) {
+	var result string
+	if err := c.Call(ctx, method, params, &result); err != nil {
+		return 0, err
+	}
+
+	value, err := quantity.ParseHex(result)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s result: %w", method, err)
+	}
+
+	return value, nil
+}
+
+// callBigQuantity calls a method returning a hex quantity of any size, such as wei amounts
+func (c *rpcCaller) callBigQuantity(ctx context.Context, method string, params []any) (*big.Int, error) {
+	var result string
+	if err := c.Call(ctx, method, params, &result); err != nil {
+		return nil, err
+	}
+
+	digits, ok := strings.CutPrefix(result, "0x")
+	if !ok {
+		return nil, fmt.Errorf("failed to parse %s result: missing 0x prefix in %q", method, result)
+	}
+
+	value, ok := new(big.Int).SetString(digits, 16)
+	if !ok {
+		return nil, fmt.Errorf("failed to parse %s result: invalid hex quantity %q", method, result)
+	}
+
+	return value, nil
+}
@@ -0,0 +1,130 @@
+package nft
+
+import (
+	"cmp"
+	"encoding/json"
+	"math/big"
+	"slices"
+
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/eth/quantity"
+)
+
+// Holding is an amount of a token of a contract held by an owner
+type Holding struct {
+	Contract string
+	Standard Standard
+	TokenID  *big.Int
+	Amount   *big.Int
+}
+
+// holdingJSON holds the token ID and amount as decimal strings
+type holdingJSON struct {
+	Contract string   `json:"contract"`
+	Standard Standard `json:"standard"`
+	TokenID  string   `json:"tokenId"`
+	Amount   string   `json:"amount"`
+}
+
+// MarshalJSON encodes the holding with its token ID and amount as decimal strings
+func (h Holding) MarshalJSON() ([]byte, error) {
+	return json.Marshal(holdingJSON{Contract: h.Contract, Standard: h.Standard, TokenID: quantity.Decimal(h.TokenID), Amount: quantity.Decimal(h.Amount)})
+}
+
+// tokenKey identifies a token of a contract
+type tokenKey struct {
+	contract string
+	id       string
+}
+
+// Ledger derives the holdings of every owner from transfers applied in chain order.
+// It only knows the transfers it is given, so the holdings of tokens minted before the
+// first one applied are partial: ERC-1155 balances that would be negative are not reported.
+type Ledger struct {
+	// owners holds the owner of every ERC-721 token
+	owners map[tokenKey]string
+	// balances holds the balance of every owner of every ERC-1155 token
+	balances map[tokenKey]map[string]*big.Int
+	// ids holds the parsed ID of every token
+	ids map[tokenKey]*big.Int
+}
+
+// NewLedger creates a new empty ledger
+func NewLedger() *Ledger {
+	return &Ledger{
+		owners:   make(map[tokenKey]string),
+		balances: make(map[tokenKey]map[string]*big.Int),
+		ids:      make(map[tokenKey]*big.Int),
+	}
+}
+
+// Apply applies a transfer emitted by a contract
+func (l *Ledger) Apply(contract string, transfer Transfer) {
+	contract = normalize(contract)
+	from, to := normalize(transfer.From), normalize(transfer.To)
+
+	for _, token := range transfer.Tokens {
+		if token.ID == nil || token.Amount == nil {
+			continue
+		}
+
+		key := tokenKey{contract: contract, id: token.ID.String()}
+		l.ids[key] = token.ID
+
+		if transfer.Standard == ERC721 {
+			if to == ZeroAddress {
+				delete(l.owners, key)
+			} else {
+				l.owners[key] = to
+			}
+			continue
+		}
+
+		balances, ok := l.balances[key]
+		if !ok {
+			balances = make(map[string]*big.Int)
+			l.balances[key] = balances
+		}
+
+		if from != ZeroAddress {
+			balances[from] = new(big.Int).Sub(balanceOf(balances, from), token.Amount)
+		}
+		if to != ZeroAddress {
+			balances[to] = new(big.Int).Add(balanceOf(balances, to), token.Amount)
+		}
+	}
+}
+
+// balanceOf returns the balance of an owner, zero if unknown
+func balanceOf(balances map[string]*big.Int, owner string) *big.Int {
+	if balance, ok := balances[owner]; ok {
+		return balance
+	}
+	return new(big.Int)
+}
+
+// HoldingsOf returns the tokens held by an owner, ordered by contract and token ID
+func (l *Ledger) HoldingsOf(owner string) []Holding {
+	owner = normalize(owner)
+
+	holdings := []Holding{}
+	for key, holder := range l.owners {
+		if holder == owner {
+			holdings = append(holdings, Holding{Contract: key.contract, Standard: ERC721, TokenID: l.ids[key], Amount: big.NewInt(1)})
+		}
+	}
+
+	for key, balances := range l.balances {
+		if balance, ok := balances[owner]; ok && balance.Sign() > 0 {
+			holdings = append(holdings, Holding{Contract: key.contract, Standard: ERC1155, TokenID: l.ids[key], Amount: balance})
+		}
+	}
+
+	slices.SortFunc(holdings, func(a, b Holding) int {
+		if c := cmp.Compare(a.Contract, b.Contract); c != 0 {
+			return c
+		}
+		return a.TokenID.Cmp(b.TokenID)
+	})
+
+	return holdings
+}
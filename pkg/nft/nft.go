@@ -0,0 +1,198 @@
+package nft
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/abi"
+	"github.com/HomayoonAlimohammadi/blockchain-parser/pkg/eth/quantity"
+)
+
+const (
+	// TransferTopic is the keccak256 hash of Transfer(address,address,uint256), shared with ERC-20
+	TransferTopic = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+	// TransferSingleTopic is the keccak256 hash of TransferSingle(address,address,address,uint256,uint256)
+	TransferSingleTopic = "0xc3d58168c5ae7397731d063d5bbf3d657854427343f4c083240f7aacaa2d0f62"
+	// TransferBatchTopic is the keccak256 hash of TransferBatch(address,address,address,uint256[],uint256[])
+	TransferBatchTopic = "0x4a39dc06d4c0dbc64b70af90fd698a233a518aa5d07e595d983b8c0526c8f7fb"
+
+	// ZeroAddress is the sender of mints and the recipient of burns
+	ZeroAddress = "0x0000000000000000000000000000000000000000"
+)
+
+// ErrNotNFT is returned when a log is not an ERC-721 or ERC-1155 transfer. ERC-20 transfers share the
+// signature of ERC-721 ones, but their value is not indexed, so they have three topics only.
+var ErrNotNFT = errors.New("not an NFT transfer")
+
+// Standard is the token standard of a transfer
+type Standard string
+
+const (
+	ERC721  Standard = "erc721"
+	ERC1155 Standard = "erc1155"
+)
+
+// events are the transfer events of both standards
+var events = mustParse(`[
+	{"type": "event", "name": "Transfer", "inputs": [
+		{"name": "from", "type": "address", "indexed": true},
+		{"name": "to", "type": "address", "indexed": true},
+		{"name": "tokenId", "type": "uint256", "indexed": true}
+	]},
+	{"type": "event", "name": "TransferSingle", "inputs": [
+		{"name": "operator", "type": "address", "indexed": true},
+		{"name": "from", "type": "address", "indexed": true},
+		{"name": "to", "type": "address", "indexed": true},
+		{"name": "id", "type": "uint256"},
+		{"name": "value", "type": "uint256"}
+	]},
+	{"type": "event", "name": "TransferBatch", "inputs": [
+		{"name": "operator", "type": "address", "indexed": true},
+		{"name": "from", "type": "address", "indexed": true},
+		{"name": "to", "type": "address", "indexed": true},
+		{"name": "ids", "type": "uint256[]"},
+		{"name": "values", "type": "uint256[]"}
+	]}
+]`)
+
+func mustParse(data string) *abi.ABI {
+	parsed, err := abi.Parse([]byte(data))
+	if err != nil {
+		panic(fmt.Sprintf("invalid NFT events ABI: %v", err))
+	}
+	return parsed
+}
+
+// Token is an amount of a token, always 1 for ERC-721 tokens
+type Token struct {
+	ID     *big.Int
+	Amount *big.Int
+}
+
+// Transfer is a decoded ERC-721 Transfer, or ERC-1155 TransferSingle or TransferBatch event,
+// the tokens of the single transfers being the only one
+type Transfer struct {
+	Standard Standard
+	// Operator is the account that made an ERC-1155 transfer, empty for ERC-721 ones
+	Operator string
+	From     string
+	To       string
+	Tokens   []Token
+}
+
+// DecodeTransfer decodes the topics and data of a log emitting an ERC-721 or ERC-1155 transfer
+func DecodeTransfer(topics []string, data string) (*Transfer, error) {
+	if len(topics) == 0 {
+		return nil, ErrNotNFT
+	}
+
+	event, ok := events.EventByTopic(topics[0])
+	if !ok || (event.Name == "Transfer" && len(topics) != 4) {
+		return nil, ErrNotNFT
+	}
+
+	args, err := event.Decode(topics[1:], data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", event.Name, err)
+	}
+
+	switch event.Name {
+	case "Transfer":
+		return &Transfer{
+			Standard: ERC721,
+			From:     args[0].Value.(string),
+			To:       args[1].Value.(string),
+			Tokens:   []Token{{ID: args[2].Value.(*big.Int), Amount: big.NewInt(1)}},
+		}, nil
+	case "TransferSingle":
+		return &Transfer{
+			Standard: ERC1155,
+			Operator: args[0].Value.(string),
+			From:     args[1].Value.(string),
+			To:       args[2].Value.(string),
+			Tokens:   []Token{{ID: args[3].Value.(*big.Int), Amount: args[4].Value.(*big.Int)}},
+		}, nil
+	default:
+		ids, amounts := args[3].Value.([]any), args[4].Value.([]any)
+		if len(ids) != len(amounts) {
+			return nil, fmt.Errorf("failed to decode %s: %d ids for %d values", event.Name, len(ids), len(amounts))
+		}
+
+		tokens := make([]Token, len(ids))
+		for i := range ids {
+			tokens[i] = Token{ID: ids[i].(*big.Int), Amount: amounts[i].(*big.Int)}
+		}
+
+		return &Transfer{
+			Standard: ERC1155,
+			Operator: args[0].Value.(string),
+			From:     args[1].Value.(string),
+			To:       args[2].Value.(string),
+			Tokens:   tokens,
+		}, nil
+	}
+}
+
+// transferJSON and tokenJSON hold the token IDs and amounts as decimal strings, see quantity.Decimal
+type transferJSON struct {
+	Standard Standard `json:"standard"`
+	Operator string   `json:"operator,omitempty"`
+	From     string   `json:"from"`
+	To       string   `json:"to"`
+	Tokens   []Token  `json:"tokens"`
+}
+
+type tokenJSON struct {
+	ID     string `json:"id"`
+	Amount string `json:"amount"`
+}
+
+// MarshalJSON encodes the transfer
+func (t Transfer) MarshalJSON() ([]byte, error) {
+	return json.Marshal(transferJSON(t))
+}
+
+// UnmarshalJSON decodes a transfer encoded by MarshalJSON
+func (t *Transfer) UnmarshalJSON(data []byte) error {
+	var raw transferJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*t = Transfer(raw)
+	return nil
+}
+
+// MarshalJSON encodes the token with its ID and amount as decimal strings
+func (t Token) MarshalJSON() ([]byte, error) {
+	return json.Marshal(tokenJSON{ID: quantity.Decimal(t.ID), Amount: quantity.Decimal(t.Amount)})
+}
+
+// UnmarshalJSON decodes a token encoded by MarshalJSON
+func (t *Token) UnmarshalJSON(data []byte) error {
+	var raw tokenJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	id, err := quantity.ParseDecimal(raw.ID)
+	if err != nil {
+		return err
+	}
+
+	amount, err := quantity.ParseDecimal(raw.Amount)
+	if err != nil {
+		return err
+	}
+
+	*t = Token{ID: id, Amount: amount}
+	return nil
+}
+
+// normalize lowercases an address so the ones of different sources compare equal
+func normalize(address string) string {
+	return strings.ToLower(address)
+}
@@ -0,0 +1,120 @@
+package nft
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	operatorTopic = "0x0000000000000000000000001e0049783f008a0085193e00003d00cd54003c71"
+	fromTopic     = "0x00000000000000000000000028c6c06298d514db089934071355e5743bf21d60"
+	toTopic       = "0x000000000000000000000000A0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+	zeroTopic     = "0x0000000000000000000000000000000000000000000000000000000000000000"
+
+	from = "0x28c6c06298d514db089934071355e5743bf21d60"
+	to   = "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"
+)
+
+// word returns the hex encoded 32 bytes word of a value, without the 0x prefix
+func word(value int64) string {
+	return fmt.Sprintf("%064x", value)
+}
+
+func TestDecodeTransfer_ERC721(t *testing.T) {
+	transfer, err := DecodeTransfer([]string{TransferTopic, fromTopic, toTopic, "0x" + word(42)}, "0x")
+	assert.NoError(t, err)
+	assert.Equal(t, ERC721, transfer.Standard)
+	assert.Equal(t, "", transfer.Operator)
+	assert.Equal(t, from, transfer.From)
+	assert.Equal(t, to, transfer.To)
+	assert.Equal(t, []Token{{ID: big.NewInt(42), Amount: big.NewInt(1)}}, transfer.Tokens)
+
+	encoded, err := json.Marshal(transfer)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"standard":"erc721","from":"`+from+`","to":"`+to+`","tokens":[{"id":"42","amount":"1"}]}`, string(encoded))
+
+	var decoded Transfer
+	assert.NoError(t, json.Unmarshal(encoded, &decoded))
+	assert.Equal(t, *transfer, decoded)
+}
+
+func TestDecodeTransfer_ERC1155(t *testing.T) {
+	single, err := DecodeTransfer([]string{TransferSingleTopic, operatorTopic, fromTopic, toTopic}, "0x"+word(7)+word(100))
+	assert.NoError(t, err)
+	assert.Equal(t, ERC1155, single.Standard)
+	assert.Equal(t, "0x1e0049783f008a0085193e00003d00cd54003c71", single.Operator)
+	assert.Equal(t, []Token{{ID: big.NewInt(7), Amount: big.NewInt(100)}}, single.Tokens)
+
+	// Two dynamic arrays: their offsets, then the length and elements of each
+	data := "0x" + word(64) + word(160) +
+		word(2) + word(1) + word(2) +
+		word(2) + word(10) + word(20)
+	batch, err := DecodeTransfer([]string{TransferBatchTopic, operatorTopic, zeroTopic, toTopic}, data)
+	assert.NoError(t, err)
+	assert.Equal(t, ZeroAddress, batch.From)
+	assert.Equal(t, []Token{
+		{ID: big.NewInt(1), Amount: big.NewInt(10)},
+		{ID: big.NewInt(2), Amount: big.NewInt(20)},
+	}, batch.Tokens)
+}
+
+func TestDecodeTransfer_Invalid(t *testing.T) {
+	// An ERC-20 transfer does not index its value
+	_, err := DecodeTransfer([]string{TransferTopic, fromTopic, toTopic}, "0x"+word(1))
+	assert.ErrorIs(t, err, ErrNotNFT)
+
+	_, err = DecodeTransfer([]string{"0x1234"}, "0x")
+	assert.ErrorIs(t, err, ErrNotNFT)
+
+	_, err = DecodeTransfer(nil, "0x")
+	assert.ErrorIs(t, err, ErrNotNFT)
+
+	// Truncated data
+	_, err = DecodeTransfer([]string{TransferSingleTopic, operatorTopic, fromTopic, toTopic}, "0x"+word(7))
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrNotNFT)
+}
+
+func TestLedger(t *testing.T) {
+	const (
+		punks = "0xB47e3cd837dDF8e4c57F05d70Ab865de6e193BBB"
+		items = "0x76be3b62873462d2142405439777e971754e8e77"
+		other = "0x1111111111111111111111111111111111111111"
+	)
+
+	ledger := NewLedger()
+	// ERC-721: minted to from, then sent to to, and another token minted to from
+	ledger.Apply(punks, Transfer{Standard: ERC721, From: ZeroAddress, To: from, Tokens: []Token{{ID: big.NewInt(2), Amount: big.NewInt(1)}}})
+	ledger.Apply(punks, Transfer{Standard: ERC721, From: from, To: to, Tokens: []Token{{ID: big.NewInt(2), Amount: big.NewInt(1)}}})
+	ledger.Apply(punks, Transfer{Standard: ERC721, From: ZeroAddress, To: from, Tokens: []Token{{ID: big.NewInt(1), Amount: big.NewInt(1)}}})
+	// ERC-1155: 30 of token 5 minted to from, who sends 10 to to, and a token transferred before its mint was seen
+	ledger.Apply(items, Transfer{Standard: ERC1155, From: ZeroAddress, To: from, Tokens: []Token{{ID: big.NewInt(5), Amount: big.NewInt(30)}}})
+	ledger.Apply(items, Transfer{Standard: ERC1155, From: from, To: to, Tokens: []Token{{ID: big.NewInt(5), Amount: big.NewInt(10)}}})
+	ledger.Apply(items, Transfer{Standard: ERC1155, From: from, To: other, Tokens: []Token{{ID: big.NewInt(6), Amount: big.NewInt(1)}}})
+
+	holdings := ledger.HoldingsOf("0x28C6c06298d514Db089934071355E5743bf21d60")
+	assert.Equal(t, []Holding{
+		{Contract: "0x76be3b62873462d2142405439777e971754e8e77", Standard: ERC1155, TokenID: big.NewInt(5), Amount: big.NewInt(20)},
+		{Contract: "0xb47e3cd837ddf8e4c57f05d70ab865de6e193bbb", Standard: ERC721, TokenID: big.NewInt(1), Amount: big.NewInt(1)},
+	}, holdings)
+
+	holdings = ledger.HoldingsOf(to)
+	assert.Len(t, holdings, 2)
+
+	// Burning removes the token
+	ledger.Apply(punks, Transfer{Standard: ERC721, From: to, To: ZeroAddress, Tokens: []Token{{ID: big.NewInt(2), Amount: big.NewInt(1)}}})
+	holdings = ledger.HoldingsOf(to)
+	assert.Equal(t, []Holding{
+		{Contract: "0x76be3b62873462d2142405439777e971754e8e77", Standard: ERC1155, TokenID: big.NewInt(5), Amount: big.NewInt(10)},
+	}, holdings)
+
+	encoded, err := json.Marshal(holdings)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"contract":"0x76be3b62873462d2142405439777e971754e8e77","standard":"erc1155","tokenId":"5","amount":"10"}]`, string(encoded))
+
+	assert.Empty(t, ledger.HoldingsOf("0x2222222222222222222222222222222222222222"))
+}